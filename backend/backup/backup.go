@@ -0,0 +1,457 @@
+// Package backup writes all household data to a portable zip archive and
+// restores it on PostgreSQL or SQLite, whichever the database runs on.
+//
+// An archive holds manifest.json and one JSON array of rows per table under
+// tables/. Rows keep the IDs they had; restore inserts them with new IDs and
+// rewrites the references, so an archive can also be added to a database that
+// already has data. Shopping lists are worked out from the meal plan and the
+// stock, so they come back with those.
+//
+// Merging an archive into a database that has data matches rows by what they
+// are: ingredients by name or alias, recipes, locations, tags and members by
+// name, meal plans by date, meal and recipe. A matched row is kept as it is
+// in the database, together with everything that belongs to it (stock,
+// steps, history, ...), so restoring the same archive twice adds nothing the
+// second time.
+package backup
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	Format  = "house-backup"
+	Version = 1
+)
+
+// ErrArchive is wrapped by every error about the archive itself rather than the database.
+var ErrArchive = errors.New("invalid backup archive")
+
+type Manifest struct {
+	Format    string         `json:"format"`
+	Version   int            `json:"version"`
+	CreatedAt time.Time      `json:"created_at"`
+	Tables    map[string]int `json:"tables"` // Rows per table
+}
+
+// A ref is a column holding the id of a row in another table.
+type ref struct {
+	column, table string
+	optional      bool // ON DELETE SET NULL: without the row the column is cleared instead of dropping this row
+}
+
+type table struct {
+	name string
+	id   string // Generated key, renumbered on restore; empty for link tables
+	refs []ref
+
+	// match finds the id of an existing row that takes the place of an
+	// archived one; its parameters are the archived row's key columns
+	match string
+	key   []string
+	// owners are ref columns: a row belonging to a matched row is skipped,
+	// the matched row keeps what it has
+	owners []string
+}
+
+const byName = "SELECT id FROM %s WHERE LOWER(name) = LOWER($1) ORDER BY id LIMIT 1"
+
+// tables lists every table after the ones it references.
+var tables = []table{
+	{name: "locations", id: "id", match: fmt.Sprintf(byName, "locations"), key: []string{"name"}},
+	// By name or alias, as handlers.findIngredientByName
+	{name: "ingredients", id: "id", refs: []ref{{"location_id", "locations", true}}, match: `
+		SELECT id FROM (
+			SELECT id, 0 AS rank FROM ingredients WHERE LOWER(name) = LOWER($1)
+			UNION ALL
+			SELECT ingredient_id, 1 FROM ingredient_aliases WHERE LOWER(alias) = LOWER($1)
+		) m
+		ORDER BY rank, id LIMIT 1`, key: []string{"name"}},
+	{name: "recipes", id: "id", match: fmt.Sprintf(byName, "recipes"), key: []string{"name"}},
+	{name: "recipe_ingredients", refs: []ref{{"recipe_id", "recipes", false}, {"ingredient_id", "ingredients", false}},
+		owners: []string{"recipe_id"}},
+	{name: "recipe_steps", id: "id", refs: []ref{{"recipe_id", "recipes", false}}, owners: []string{"recipe_id"}},
+	{name: "recipe_step_ingredients", refs: []ref{
+		{"step_id", "recipe_steps", false}, {"recipe_id", "recipes", false}, {"ingredient_id", "ingredients", false}},
+		owners: []string{"recipe_id"}},
+	{name: "tags", id: "id", match: fmt.Sprintf(byName, "tags"), key: []string{"name"}},
+	{name: "recipe_tags", refs: []ref{{"recipe_id", "recipes", false}, {"tag_id", "tags", false}}, owners: []string{"recipe_id"}},
+	{name: "recipe_components", refs: []ref{{"recipe_id", "recipes", false}, {"component_recipe_id", "recipes", false}},
+		owners: []string{"recipe_id"}},
+	{name: "meal_plan", id: "id", refs: []ref{{"recipe_id", "recipes", true}},
+		match: "SELECT id FROM meal_plan WHERE date = $1::DATE AND meal_type = $2 AND COALESCE(recipe_id, 0) = COALESCE($3, 0) ORDER BY id LIMIT 1",
+		key:   []string{"date", "meal_type", "recipe_id"}},
+	{name: "prepared_foods", id: "id", refs: []ref{{"recipe_id", "recipes", false}, {"meal_plan_id", "meal_plan", true}},
+		owners: []string{"recipe_id", "meal_plan_id"}},
+	{name: "inventory_history", id: "id", refs: []ref{
+		{"ingredient_id", "ingredients", false}, {"from_location_id", "locations", true}, {"to_location_id", "locations", true}},
+		owners: []string{"ingredient_id"}},
+	{name: "stocktakes", id: "id", refs: []ref{{"location_id", "locations", true}},
+		match: "SELECT id FROM stocktakes WHERE started_at = $1 ORDER BY id LIMIT 1", key: []string{"started_at"},
+		owners: []string{"location_id"}},
+	{name: "stocktake_counts", refs: []ref{{"stocktake_id", "stocktakes", false}, {"ingredient_id", "ingredients", false}},
+		owners: []string{"stocktake_id", "ingredient_id"}},
+	{name: "waste_log", id: "id", refs: []ref{{"ingredient_id", "ingredients", true}, {"recipe_id", "recipes", true}},
+		match: "SELECT id FROM waste_log WHERE discarded_at = $1 AND name = $2 ORDER BY id LIMIT 1", key: []string{"discarded_at", "name"},
+		owners: []string{"ingredient_id", "recipe_id"}},
+	{name: "ingredient_nutrition", refs: []ref{{"ingredient_id", "ingredients", false}}, owners: []string{"ingredient_id"}},
+	{name: "ingredient_flags", refs: []ref{{"ingredient_id", "ingredients", false}}, owners: []string{"ingredient_id"}},
+	{name: "household_members", id: "id", match: fmt.Sprintf(byName, "household_members"), key: []string{"name"}},
+	{name: "member_restrictions", refs: []ref{{"member_id", "household_members", false}}, owners: []string{"member_id"}},
+	{name: "meal_attendance", refs: []ref{{"meal_plan_id", "meal_plan", false}, {"member_id", "household_members", false}},
+		owners: []string{"meal_plan_id"}},
+	{name: "ingredient_substitutes", id: "id", refs: []ref{
+		{"ingredient_id", "ingredients", false}, {"substitute_id", "ingredients", false}, {"recipe_id", "recipes", false}},
+		owners: []string{"ingredient_id"}},
+	{name: "ingredient_aliases", id: "id", refs: []ref{{"ingredient_id", "ingredients", false}}, owners: []string{"ingredient_id"}},
+}
+
+// Write snapshots every table into a zip archive written to w.
+func Write(ctx context.Context, db *sql.DB, w io.Writer) (*Manifest, error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	m := &Manifest{Format: Format, Version: Version, CreatedAt: time.Now().UTC(), Tables: map[string]int{}}
+	z := zip.NewWriter(w)
+	for _, t := range tables {
+		rows, err := dump(ctx, tx, t.name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", t.name, err)
+		}
+		f, err := z.Create("tables/" + t.name + ".json")
+		if err != nil {
+			return nil, err
+		}
+		if err := json.NewEncoder(f).Encode(rows); err != nil {
+			return nil, err
+		}
+		m.Tables[t.name] = len(rows)
+	}
+
+	f, err := z.Create("manifest.json")
+	if err != nil {
+		return nil, err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(m); err != nil {
+		return nil, err
+	}
+	return m, z.Close()
+}
+
+func dump(ctx context.Context, tx *sql.Tx, name string) ([]map[string]interface{}, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT * FROM "+name+" ORDER BY 1")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	out := []map[string]interface{}{}
+	values := make([]interface{}, len(columns))
+	ptrs := make([]interface{}, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]interface{}, len(columns))
+		for i, c := range columns {
+			row[c.Name()] = exportValue(values[i], typeName(c))
+		}
+		out = append(out, row)
+	}
+	return out, rows.Err()
+}
+
+// exportValue turns what either driver scans into the same JSON: numbers,
+// booleans, YYYY-MM-DD dates and RFC 3339 times.
+func exportValue(v interface{}, typ string) interface{} {
+	if b, ok := v.([]byte); ok {
+		v = string(b)
+	}
+	switch v := v.(type) {
+	case string:
+		if typ == "NUMERIC" || typ == "DECIMAL" {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return f
+			}
+		}
+	case int64:
+		if strings.HasPrefix(typ, "BOOL") {
+			return v != 0
+		}
+	case time.Time:
+		if typ == "DATE" {
+			return v.Format("2006-01-02")
+		}
+		return v.UTC().Format(time.RFC3339Nano)
+	}
+	return v
+}
+
+// typeName is the column's type without length or precision, e.g. VARCHAR for VARCHAR(100).
+func typeName(c *sql.ColumnType) string {
+	name, _, _ := strings.Cut(strings.ToUpper(c.DatabaseTypeName()), "(")
+	return strings.TrimSpace(name)
+}
+
+// Mode says what happens to the data already in the database on restore.
+type Mode string
+
+const (
+	Merge   Mode = "merge"   // Add the archive's rows that are not there yet, see the package comment
+	Replace Mode = "replace" // Delete all data first
+)
+
+type Report struct {
+	Mode     Mode           `json:"mode"`
+	Manifest Manifest       `json:"manifest"`
+	Restored map[string]int `json:"restored"`
+	Matched  map[string]int `json:"matched"` // Rows that took the place of an existing row
+	Skipped  map[string]int `json:"skipped"` // Duplicates, rows of matched rows and rows whose parent row was skipped
+}
+
+// Restore inserts the rows of an archive made by Write in one transaction.
+func Restore(ctx context.Context, db *sql.DB, r io.ReaderAt, size int64, mode Mode) (*Report, error) {
+	if mode != Merge && mode != Replace {
+		return nil, fmt.Errorf("unknown restore mode %q", mode)
+	}
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrArchive, err)
+	}
+	rep := &Report{Mode: mode, Restored: map[string]int{}, Matched: map[string]int{}, Skipped: map[string]int{}}
+	found, err := readJSON(z, "manifest.json", &rep.Manifest)
+	switch {
+	case err != nil:
+		return nil, err
+	case !found || rep.Manifest.Format != Format:
+		return nil, fmt.Errorf("%w: no %s manifest", ErrArchive, Format)
+	case rep.Manifest.Version > Version:
+		return nil, fmt.Errorf("%w: version %d is newer than this server reads (%d)", ErrArchive, rep.Manifest.Version, Version)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if mode == Replace {
+		for i := len(tables) - 1; i >= 0; i-- {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM "+tables[i].name); err != nil {
+				return nil, fmt.Errorf("%s: %w", tables[i].name, err)
+			}
+		}
+	}
+
+	ids := map[string]map[int64]int64{}    // Old id to new id, per table
+	matched := map[string]map[int64]bool{} // New ids of matched rows, per table
+	for _, t := range tables {
+		ids[t.name] = map[int64]int64{}
+		matched[t.name] = map[int64]bool{}
+		var rows []map[string]interface{}
+		// Tables added after the archive was made are simply left empty
+		if _, err := readJSON(z, "tables/"+t.name+".json", &rows); err != nil {
+			return nil, err
+		}
+		if err := restoreTable(ctx, tx, t, rows, ids, matched, rep); err != nil {
+			return nil, fmt.Errorf("%s: %w", t.name, err)
+		}
+	}
+	return rep, tx.Commit()
+}
+
+func restoreTable(ctx context.Context, tx *sql.Tx, t table, rows []map[string]interface{}, ids map[string]map[int64]int64, matched map[string]map[int64]bool, rep *Report) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	types, err := columnTypes(ctx, tx, t.name)
+	if err != nil {
+		return err
+	}
+
+	// Columns this database has that the archive has too; the rest keep their defaults
+	var columns, params []string
+	for name := range rows[0] {
+		if _, ok := types[name]; ok && name != t.id {
+			columns = append(columns, name)
+			params = append(params, "$"+strconv.Itoa(len(columns)))
+		}
+	}
+	query := "INSERT INTO " + t.name + " (" + strings.Join(columns, ", ") + ") VALUES (" + strings.Join(params, ", ") + ") ON CONFLICT DO NOTHING"
+	if t.id != "" {
+		query += " RETURNING " + t.id
+	}
+	insert, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+
+rows:
+	for _, row := range rows {
+		for _, ref := range t.refs {
+			if row[ref.column] == nil {
+				continue
+			}
+			id, ok := ids[ref.table][toInt(row[ref.column])]
+			switch {
+			case ok:
+				row[ref.column] = id
+			case ref.optional:
+				row[ref.column] = nil
+			default:
+				rep.Skipped[t.name]++
+				continue rows
+			}
+		}
+
+		for _, column := range t.owners {
+			if owner := refTable(t, column); row[column] != nil && matched[owner][toInt(row[column])] {
+				rep.Skipped[t.name]++
+				continue rows
+			}
+		}
+
+		oldID := toInt(row[t.id])
+		if t.match != "" {
+			args := make([]interface{}, len(t.key))
+			for i, c := range t.key {
+				args[i] = importValue(row[c], types[c])
+			}
+			var id int64
+			err := tx.QueryRowContext(ctx, t.match, args...).Scan(&id)
+			if err == nil {
+				ids[t.name][oldID] = id
+				matched[t.name][id] = true
+				rep.Matched[t.name]++
+				continue
+			} else if err != sql.ErrNoRows {
+				return err
+			}
+		}
+
+		args := make([]interface{}, len(columns))
+		for i, c := range columns {
+			args[i] = importValue(row[c], types[c])
+		}
+		if t.id == "" {
+			res, err := insert.ExecContext(ctx, args...)
+			if err != nil {
+				return err
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				rep.Skipped[t.name]++
+				continue
+			}
+		} else {
+			var id int64
+			err := insert.QueryRowContext(ctx, args...).Scan(&id)
+			if err == sql.ErrNoRows {
+				rep.Skipped[t.name]++
+				continue
+			} else if err != nil {
+				return err
+			}
+			ids[t.name][oldID] = id
+		}
+		rep.Restored[t.name]++
+	}
+	return nil
+}
+
+// refTable is the table the ref column of t points at.
+func refTable(t table, column string) string {
+	for _, r := range t.refs {
+		if r.column == column {
+			return r.table
+		}
+	}
+	return ""
+}
+
+func columnTypes(ctx context.Context, tx *sql.Tx, name string) (map[string]string, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT * FROM "+name+" WHERE 1 = 0")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	types := make(map[string]string, len(columns))
+	for _, c := range columns {
+		types[c.Name()] = typeName(c)
+	}
+	return types, nil
+}
+
+// importValue converts a value read from an archive for a column of type typ.
+func importValue(v interface{}, typ string) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case string:
+		if typ == "DATE" || strings.HasPrefix(typ, "TIMESTAMP") {
+			for _, layout := range []string{"2006-01-02", time.RFC3339} {
+				if t, err := time.Parse(layout, v); err == nil {
+					return t
+				}
+			}
+		}
+	}
+	return v
+}
+
+func toInt(v interface{}) int64 {
+	switch v := v.(type) {
+	case json.Number:
+		n, _ := v.Int64()
+		return n
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	}
+	return 0
+}
+
+// readJSON decodes the archive member name into v, reporting whether it exists.
+func readJSON(z *zip.Reader, name string, v interface{}) (bool, error) {
+	f, err := z.Open(name)
+	if err != nil {
+		return false, nil
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return true, fmt.Errorf("%w: %s: %v", ErrArchive, name, err)
+	}
+	return true, nil
+}
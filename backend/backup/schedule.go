@@ -0,0 +1,94 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Kano-Chien/house_management/backend/metrics"
+)
+
+const filePrefix = "house-backup-"
+
+// FileName is the name of an archive made at t; names sort by time.
+func FileName(t time.Time) string {
+	return filePrefix + t.UTC().Format("20060102-150405") + ".zip"
+}
+
+// WriteFile writes an archive into dir and returns its path. The archive is
+// written under a temporary name first so dir never holds a partial one.
+func WriteFile(ctx context.Context, db *sql.DB, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(dir, ".backup-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := Write(ctx, db, f); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, FileName(time.Now()))
+	return path, os.Rename(f.Name(), path)
+}
+
+// Schedule writes an archive into dir every interval and deletes all but the
+// newest keep archives there. It runs until ctx is done; a failed backup is
+// logged and tried again at the next interval.
+func Schedule(ctx context.Context, db *sql.DB, dir string, interval time.Duration, keep int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		path, err := WriteFile(ctx, db, dir)
+		if ctx.Err() != nil {
+			return
+		}
+		metrics.JobRun("backup", err)
+		if err != nil {
+			slog.Error("Scheduled backup failed", "error", err)
+			continue
+		}
+		slog.Info("Backup written", "path", path)
+		if err := prune(dir, keep); err != nil {
+			slog.Warn("Removing old backups failed", "error", err)
+		}
+	}
+}
+
+// prune deletes the oldest archives in dir beyond the newest keep.
+func prune(dir string, keep int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), filePrefix) && strings.HasSuffix(e.Name(), ".zip") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	for len(names) > keep {
+		if err := os.Remove(filepath.Join(dir, names[0])); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
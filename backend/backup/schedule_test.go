@@ -0,0 +1,31 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2026, 1, 1, 3, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		os.WriteFile(filepath.Join(dir, FileName(start.AddDate(0, 0, i))), nil, 0o644)
+	}
+	os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0o644)
+
+	if err := prune(dir, 2); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(dir)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	want := []string{"house-backup-20260104-030000.zip", "house-backup-20260105-030000.zip", "notes.txt"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("after prune = %v, want %v", names, want)
+	}
+}
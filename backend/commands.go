@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Kano-Chien/house_management/backend/backup"
+	"github.com/Kano-Chien/house_management/backend/config"
+)
+
+// runBackup implements "backup [-o file]".
+func runBackup(db *sql.DB, args []string) {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	out := fs.String("o", backup.FileName(time.Now()), "archive to write")
+	fs.Parse(args)
+
+	f, err := os.Create(*out)
+	if err != nil {
+		fatal("Cannot create archive", "error", err)
+	}
+	m, err := backup.Write(context.Background(), db, f)
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		os.Remove(*out)
+		fatal("Backup failed", "error", err)
+	}
+	rows := 0
+	for _, n := range m.Tables {
+		rows += n
+	}
+	fmt.Printf("Wrote %d rows from %d tables to %s\n", rows, len(m.Tables), *out)
+}
+
+// runRestore implements "restore [-replace] file".
+func runRestore(db *sql.DB, args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	replace := fs.Bool("replace", false, "delete all data before restoring instead of adding to it")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fatal("Usage: restore [-replace] file")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fatal("Cannot open archive", "error", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		fatal("Cannot open archive", "error", err)
+	}
+	mode := backup.Merge
+	if *replace {
+		mode = backup.Replace
+	}
+	rep, err := backup.Restore(context.Background(), db, f, info.Size(), mode)
+	if err != nil {
+		fatal("Restore failed", "error", err)
+	}
+	names := make([]string, 0, len(rep.Manifest.Tables))
+	for name := range rep.Manifest.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("%-24s %d restored, %d matched, %d skipped\n", name, rep.Restored[name], rep.Matched[name], rep.Skipped[name])
+	}
+}
+
+// startBackups writes an archive to cfg.BackupDir every cfg.BackupInterval,
+// keeping the newest cfg.BackupKeep, until ctx is done. jobs is done when the
+// scheduler has stopped. Nothing runs without a BackupDir.
+func startBackups(ctx context.Context, jobs *sync.WaitGroup, db *sql.DB, cfg *config.Config) {
+	if cfg.BackupDir == "" {
+		return
+	}
+	slog.Info("Scheduled backups", "dir", cfg.BackupDir, "interval", cfg.BackupInterval, "keep", cfg.BackupKeep)
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		backup.Schedule(ctx, db, cfg.BackupDir, cfg.BackupInterval, cfg.BackupKeep)
+	}()
+}
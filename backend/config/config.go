@@ -0,0 +1,291 @@
+// Package config reads the server's settings. Every setting has a default and
+// can be set, each overriding the one before, in a file of KEY=value lines
+// (.env unless -config names another), as an environment variable of the same
+// name and as a command-line flag.
+package config
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	ListenAddr      string
+	ShutdownTimeout time.Duration // How long requests in flight may take to finish on shutdown
+	DatabaseURL     string
+	CORSOrigins     []string // "*" allows any origin
+
+	LineChannelSecret string
+	LineAccessToken   string
+
+	AdminToken string // Bearer token for the /api/admin routes, which are off while empty
+
+	// Scheduled backups, off while BackupDir is empty
+	BackupDir      string
+	BackupInterval time.Duration
+	BackupKeep     int
+
+	LeftoverDays int // How long cooked leftovers keep
+	ExpiringDays int // How far ahead the expiring list looks by default
+
+	LowStockThreshold float64 // Stock left after planned meals below which an ingredient is on the shopping list
+
+	Location *time.Location // Decides what "today" is
+
+	LogLevel  slog.Level
+	LogFormat string // text or json
+
+	values map[string]string // As read, for String
+}
+
+type setting struct {
+	key, flag, def, usage string
+	redact                func(string) string // Set for secrets
+	set                   func(c *Config, v string) error
+}
+
+var settings = []setting{
+	{"LISTEN_ADDR", "listen", ":8080", "address to serve on", nil, func(c *Config, v string) error {
+		if _, _, err := net.SplitHostPort(v); err != nil {
+			return err
+		}
+		c.ListenAddr = v
+		return nil
+	}},
+	{"SHUTDOWN_TIMEOUT", "shutdown-timeout", "10s", "time to finish requests in flight on SIGTERM", nil, func(c *Config, v string) (err error) {
+		c.ShutdownTimeout, err = time.ParseDuration(v)
+		if err == nil && c.ShutdownTimeout <= 0 {
+			err = errors.New("must be positive")
+		}
+		return err
+	}},
+	{"DATABASE_URL", "database-url", "user=house_user password=house_pass dbname=house_management sslmode=disable",
+		"PostgreSQL connection string, or sqlite:file", redactPassword, func(c *Config, v string) error {
+			if v == "" {
+				return errors.New("must not be empty")
+			}
+			if strings.Contains(v, "://") {
+				if _, err := url.Parse(v); err != nil {
+					return err
+				}
+			}
+			c.DatabaseURL = v
+			return nil
+		}},
+	{"CORS_ORIGINS", "cors-origins", "*", "comma-separated origins allowed to call the API, * for any", nil, func(c *Config, v string) error {
+		c.CORSOrigins = nil
+		for _, origin := range strings.Split(v, ",") {
+			origin = strings.TrimSpace(origin)
+			if origin == "" {
+				continue
+			}
+			if origin != "*" {
+				u, err := url.Parse(origin)
+				if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.Trim(u.Path, "/") != "" {
+					return fmt.Errorf("%q is not an origin such as https://example.com", origin)
+				}
+				origin = u.Scheme + "://" + u.Host
+			}
+			c.CORSOrigins = append(c.CORSOrigins, origin)
+		}
+		return nil
+	}},
+	{"LINE_CHANNEL_SECRET", "line-channel-secret", "", "LINE Messaging API channel secret", hide, func(c *Config, v string) error {
+		c.LineChannelSecret = v
+		return nil
+	}},
+	{"LINE_CHANNEL_ACCESS_TOKEN", "line-access-token", "", "LINE Messaging API channel access token", hide, func(c *Config, v string) error {
+		c.LineAccessToken = v
+		return nil
+	}},
+	{"ADMIN_TOKEN", "admin-token", "", "bearer token for the backup and restore API, off when empty", hide, func(c *Config, v string) error {
+		if v != "" && len(v) < 16 {
+			return errors.New("must be at least 16 characters")
+		}
+		c.AdminToken = v
+		return nil
+	}},
+	{"BACKUP_DIR", "backup-dir", "", "directory for scheduled backups, none when empty", nil, func(c *Config, v string) error {
+		c.BackupDir = v
+		return nil
+	}},
+	{"BACKUP_INTERVAL", "backup-interval", "24h", "time between scheduled backups", nil, func(c *Config, v string) (err error) {
+		c.BackupInterval, err = time.ParseDuration(v)
+		if err == nil && c.BackupInterval < time.Minute {
+			err = errors.New("must be at least 1m")
+		}
+		return err
+	}},
+	{"BACKUP_KEEP", "backup-keep", "7", "number of scheduled backups to keep", nil, func(c *Config, v string) (err error) {
+		c.BackupKeep, err = positive(v)
+		return err
+	}},
+	{"LEFTOVER_EXPIRY_DAYS", "leftover-days", "3", "days cooked leftovers keep", nil, func(c *Config, v string) (err error) {
+		c.LeftoverDays, err = positive(v)
+		return err
+	}},
+	{"EXPIRING_DAYS", "expiring-days", "3", "days ahead the expiring list looks", nil, func(c *Config, v string) (err error) {
+		c.ExpiringDays, err = positive(v)
+		return err
+	}},
+	{"LOW_STOCK_THRESHOLD", "low-stock", "3", "stock left after planned meals below which a tracked ingredient is on the shopping list", nil, func(c *Config, v string) (err error) {
+		c.LowStockThreshold, err = strconv.ParseFloat(v, 64)
+		if err == nil && !(c.LowStockThreshold > 0) {
+			err = errors.New("must be positive")
+		}
+		return err
+	}},
+	{"LOG_LEVEL", "log-level", "info", "debug, info, warn or error", nil, func(c *Config, v string) error {
+		return c.LogLevel.UnmarshalText([]byte(v))
+	}},
+	{"LOG_FORMAT", "log-format", "text", "text, or json for log collectors", nil, func(c *Config, v string) error {
+		if v != "text" && v != "json" {
+			return errors.New("must be text or json")
+		}
+		c.LogFormat = v
+		return nil
+	}},
+	{"TZ", "timezone", "Local", "IANA time zone, e.g. Asia/Taipei", nil, func(c *Config, v string) (err error) {
+		c.Location, err = time.LoadLocation(v)
+		return err
+	}},
+}
+
+// Load reads the settings from args (the command line without the program
+// name), the environment and the config file. It returns the arguments left
+// after the flags, and every invalid setting at once.
+func Load(args []string) (*Config, []string, error) {
+	flags := flag.NewFlagSet("server", flag.ContinueOnError)
+	file := flags.String("config", ".env", "file of KEY=value settings")
+	for _, s := range settings {
+		flags.String(s.flag, s.def, s.usage+" ("+s.key+")")
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	fromFlags := map[string]string{}
+	flags.Visit(func(f *flag.Flag) { fromFlags[f.Name] = f.Value.String() })
+
+	fromFile, err := readFile(*file)
+	if errors.Is(err, fs.ErrNotExist) && fromFlags["config"] == "" {
+		err = nil // The default file is optional
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c := &Config{values: map[string]string{}}
+	var errs []error
+	for _, s := range settings {
+		v := s.def
+		if fv, ok := fromFile[s.key]; ok {
+			v = fv
+		}
+		if ev, ok := os.LookupEnv(s.key); ok {
+			v = ev
+		}
+		if fv, ok := fromFlags[s.flag]; ok {
+			v = fv
+		}
+		c.values[s.key] = v
+		if err := s.set(c, v); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.key, err))
+		}
+	}
+	if c.LineChannelSecret != "" && c.LineAccessToken == "" {
+		errs = append(errs, errors.New("LINE_CHANNEL_SECRET is set without LINE_CHANNEL_ACCESS_TOKEN"))
+	}
+	return c, flags.Args(), errors.Join(errs...)
+}
+
+// readFile reads KEY=value lines. Blank lines and # comments are skipped, and
+// values may be quoted.
+func readFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected KEY=value", path, n)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[strings.TrimSpace(key)] = value
+	}
+	return values, scanner.Err()
+}
+
+func positive(v string) (int, error) {
+	n, err := strconv.Atoi(v)
+	if err == nil && n <= 0 {
+		err = errors.New("must be positive")
+	}
+	return n, err
+}
+
+// String lists the settings as KEY=value with secrets hidden.
+func (c *Config) String() string {
+	parts := make([]string, 0, len(settings))
+	for _, s := range settings {
+		parts = append(parts, s.key+"="+c.shown(s))
+	}
+	return strings.Join(parts, " ")
+}
+
+// LogValue logs the settings as a group with secrets hidden.
+func (c *Config) LogValue() slog.Value {
+	attrs := make([]slog.Attr, 0, len(settings))
+	for _, s := range settings {
+		attrs = append(attrs, slog.String(s.key, c.shown(s)))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+func (c *Config) shown(s setting) string {
+	if s.redact != nil {
+		return s.redact(c.values[s.key])
+	}
+	return c.values[s.key]
+}
+
+func hide(v string) string {
+	if v == "" {
+		return ""
+	}
+	return "[redacted]"
+}
+
+var passwordParam = regexp.MustCompile(`(password=)('[^']*'|\S+)`)
+
+// redactPassword hides the password of a URL or key=value connection string.
+func redactPassword(v string) string {
+	if strings.Contains(v, "://") {
+		if u, err := url.Parse(v); err == nil {
+			v = u.Redacted()
+		}
+	}
+	return passwordParam.ReplaceAllString(v, "${1}[redacted]")
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "house.env")
+	os.WriteFile(file, []byte(`# Settings
+LISTEN_ADDR=:9000
+DATABASE_URL="postgres://house:s3cret@db/house"
+BACKUP_KEEP=3
+LINE_CHANNEL_SECRET=abc
+LINE_CHANNEL_ACCESS_TOKEN=def
+`), 0o644)
+	t.Setenv("BACKUP_KEEP", "5")
+	t.Setenv("CORS_ORIGINS", "https://house.example.com/, http://localhost:5173")
+
+	c, args, err := Load([]string{"-config", file, "-backup-keep", "9", "backup", "-o", "x.zip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ListenAddr != ":9000" || c.BackupKeep != 9 || c.BackupInterval != 24*time.Hour || c.LeftoverDays != 3 || c.LowStockThreshold != 3 {
+		t.Errorf("config = %+v", c)
+	}
+	if len(c.CORSOrigins) != 2 || c.CORSOrigins[0] != "https://house.example.com" {
+		t.Errorf("CORS origins = %v", c.CORSOrigins)
+	}
+	if strings.Join(args, " ") != "backup -o x.zip" {
+		t.Errorf("args = %v", args)
+	}
+	if s := c.String(); strings.Contains(s, "s3cret") || strings.Contains(s, "abc") || strings.Contains(s, "def") ||
+		!strings.Contains(s, "LINE_CHANNEL_SECRET=[redacted]") {
+		t.Errorf("String() = %s", s)
+	}
+
+	if got := redactPassword("user=house password=pass dbname=house"); got != "user=house password=[redacted] dbname=house" {
+		t.Errorf("redactPassword = %q", got)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	t.Setenv("BACKUP_INTERVAL", "10s")
+	t.Setenv("TZ", "Mars/Olympus")
+	t.Setenv("LOW_STOCK_THRESHOLD", "0")
+	t.Setenv("LINE_CHANNEL_SECRET", "abc")
+	_, _, err := Load([]string{"-config", filepath.Join(t.TempDir(), "missing.env")})
+	if err == nil {
+		t.Fatal("missing -config file accepted")
+	}
+
+	_, _, err = Load([]string{"-listen", "8080"})
+	for _, want := range []string{"LISTEN_ADDR", "BACKUP_INTERVAL", "TZ", "LOW_STOCK_THRESHOLD", "LINE_CHANNEL_ACCESS_TOKEN"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("error %v does not mention %s", err, want)
+		}
+	}
+}
@@ -80,3 +80,298 @@ BEGIN
         ALTER TABLE meal_plan ADD COLUMN is_cooked BOOLEAN DEFAULT FALSE;
     END IF;
 END $$;
+
+CREATE TABLE IF NOT EXISTS locations (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL UNIQUE,
+    description TEXT DEFAULT ''
+);
+
+-- Add location_id column if table already exists without it
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name='ingredients' AND column_name='location_id') THEN
+        ALTER TABLE ingredients ADD COLUMN location_id INTEGER REFERENCES locations(id) ON DELETE SET NULL;
+    END IF;
+END $$;
+
+-- Stock movements and corrections, newest last
+CREATE TABLE IF NOT EXISTS inventory_history (
+    id SERIAL PRIMARY KEY,
+    ingredient_id INTEGER REFERENCES ingredients(id) ON DELETE CASCADE,
+    action VARCHAR(30) NOT NULL,
+    quantity_change DECIMAL(10, 2) DEFAULT 0,
+    from_location_id INTEGER REFERENCES locations(id) ON DELETE SET NULL,
+    to_location_id INTEGER REFERENCES locations(id) ON DELETE SET NULL,
+    note TEXT DEFAULT '',
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+-- Stocktake sessions: counts are collected first and applied together on commit
+CREATE TABLE IF NOT EXISTS stocktakes (
+    id SERIAL PRIMARY KEY,
+    status VARCHAR(20) NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'committed', 'cancelled')),
+    category VARCHAR(20),
+    location_id INTEGER REFERENCES locations(id) ON DELETE SET NULL,
+    reason TEXT DEFAULT '',
+    started_at TIMESTAMP DEFAULT NOW(),
+    committed_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS stocktake_counts (
+    stocktake_id INTEGER REFERENCES stocktakes(id) ON DELETE CASCADE,
+    ingredient_id INTEGER REFERENCES ingredients(id) ON DELETE CASCADE,
+    counted_quantity DECIMAL(10, 2) NOT NULL,
+    PRIMARY KEY (stocktake_id, ingredient_id)
+);
+
+-- Structured recipe steps, ordered by position (1-based)
+CREATE TABLE IF NOT EXISTS recipe_steps (
+    id SERIAL PRIMARY KEY,
+    recipe_id INTEGER REFERENCES recipes(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    text TEXT NOT NULL,
+    duration_seconds INTEGER,
+    temperature DECIMAL(5, 1),
+    temperature_unit VARCHAR(1) DEFAULT 'C' CHECK (temperature_unit IN ('C', 'F'))
+);
+
+-- Recipe ingredients used in a step; removing the ingredient from the recipe removes the reference
+CREATE TABLE IF NOT EXISTS recipe_step_ingredients (
+    step_id INTEGER REFERENCES recipe_steps(id) ON DELETE CASCADE,
+    recipe_id INTEGER NOT NULL,
+    ingredient_id INTEGER NOT NULL,
+    PRIMARY KEY (step_id, ingredient_id),
+    FOREIGN KEY (recipe_id, ingredient_id) REFERENCES recipe_ingredients(recipe_id, ingredient_id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+-- Recipe tags: cuisine (Italian), course (Dessert), dietary (Vegetarian) or other (Quick)
+CREATE TABLE IF NOT EXISTS tags (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(50) NOT NULL,
+    kind VARCHAR(20) NOT NULL DEFAULT 'other' CHECK (kind IN ('cuisine', 'course', 'dietary', 'other'))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS tags_name_key ON tags (LOWER(name));
+
+CREATE TABLE IF NOT EXISTS recipe_tags (
+    recipe_id INTEGER REFERENCES recipes(id) ON DELETE CASCADE,
+    tag_id INTEGER REFERENCES tags(id) ON DELETE CASCADE,
+    PRIMARY KEY (recipe_id, tag_id)
+);
+
+-- Add servings column (how many servings one batch makes) if table already exists without it
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name='recipes' AND column_name='servings') THEN
+        ALTER TABLE recipes ADD COLUMN servings INTEGER DEFAULT 1;
+    END IF;
+END $$;
+
+-- Recipes used as components of other recipes, e.g. a sauce used in four dishes
+CREATE TABLE IF NOT EXISTS recipe_components (
+    recipe_id INTEGER REFERENCES recipes(id) ON DELETE CASCADE,
+    component_recipe_id INTEGER REFERENCES recipes(id) ON DELETE CASCADE,
+    quantity DECIMAL(10, 2) NOT NULL,
+    unit VARCHAR(10) NOT NULL DEFAULT 'serving' CHECK (unit IN ('serving', 'batch')),
+    PRIMARY KEY (recipe_id, component_recipe_id),
+    CHECK (recipe_id <> component_recipe_id)
+);
+
+-- Food cooked in advance, consumed by recipes that use it as a component
+CREATE TABLE IF NOT EXISTS prepared_foods (
+    id SERIAL PRIMARY KEY,
+    recipe_id INTEGER REFERENCES recipes(id) ON DELETE CASCADE,
+    servings DECIMAL(10, 2) NOT NULL,
+    prepared_on DATE DEFAULT CURRENT_DATE,
+    expiry_date DATE
+);
+
+-- Ingredients of every recipe with components expanded recursively and scaled
+-- by how much of each component is used. Nesting is capped at 10 levels.
+CREATE OR REPLACE VIEW recipe_ingredients_expanded AS
+WITH RECURSIVE tree (root_id, recipe_id, factor, depth) AS (
+    SELECT id, id, CAST(1 AS DECIMAL), 0 FROM recipes
+    UNION ALL
+    SELECT t.root_id, rc.component_recipe_id,
+        t.factor * CASE WHEN rc.unit = 'batch' THEN rc.quantity ELSE rc.quantity / GREATEST(COALESCE(c.servings, 1), 1) END,
+        t.depth + 1
+    FROM tree t
+    JOIN recipe_components rc ON rc.recipe_id = t.recipe_id
+    JOIN recipes c ON c.id = rc.component_recipe_id
+    WHERE t.depth < 10
+)
+SELECT t.root_id AS recipe_id, ri.ingredient_id, SUM(ri.quantity * t.factor) AS quantity
+FROM tree t
+JOIN recipe_ingredients ri ON ri.recipe_id = t.recipe_id
+GROUP BY t.root_id, ri.ingredient_id;
+
+-- Add kind column (batch cooked in advance or leftover from a meal) if table already exists without it
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name='prepared_foods' AND column_name='kind') THEN
+        ALTER TABLE prepared_foods ADD COLUMN kind VARCHAR(10) NOT NULL DEFAULT 'batch' CHECK (kind IN ('batch', 'leftover'));
+        ALTER TABLE prepared_foods ADD COLUMN meal_plan_id INTEGER REFERENCES meal_plan(id) ON DELETE SET NULL;
+    END IF;
+END $$;
+
+-- Add servings and is_leftovers columns if table already exists without them
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name='meal_plan' AND column_name='servings') THEN
+        ALTER TABLE meal_plan ADD COLUMN servings DECIMAL(10, 2);
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name='meal_plan' AND column_name='is_leftovers') THEN
+        ALTER TABLE meal_plan ADD COLUMN is_leftovers BOOLEAN DEFAULT FALSE;
+    END IF;
+END $$;
+
+-- Discarded food, kept even after the ingredient or recipe is deleted so waste reports stay complete
+CREATE TABLE IF NOT EXISTS waste_log (
+    id SERIAL PRIMARY KEY,
+    ingredient_id INTEGER REFERENCES ingredients(id) ON DELETE SET NULL,
+    recipe_id INTEGER REFERENCES recipes(id) ON DELETE SET NULL, -- Set for discarded prepared food
+    name VARCHAR(255) NOT NULL,
+    quantity DECIMAL(10, 2) NOT NULL,
+    unit VARCHAR(50) DEFAULT '',
+    value DECIMAL(10, 2) DEFAULT 0,
+    reason VARCHAR(20) NOT NULL CHECK (reason IN ('expired', 'spoiled', 'over-cooked', 'other')),
+    note TEXT DEFAULT '',
+    discarded_at TIMESTAMP DEFAULT NOW()
+);
+
+-- Nutrition facts per ingredient, either per 100 g or per stock unit. Sodium is in mg, kcal in kcal, the rest in g.
+CREATE TABLE IF NOT EXISTS ingredient_nutrition (
+    ingredient_id INTEGER PRIMARY KEY REFERENCES ingredients(id) ON DELETE CASCADE,
+    basis VARCHAR(10) NOT NULL DEFAULT '100g' CHECK (basis IN ('100g', 'unit')),
+    grams_per_unit DECIMAL(10, 2), -- Weight of one stock unit for the 100g basis, e.g. one egg = 50
+    kcal DECIMAL(10, 2) DEFAULT 0,
+    protein DECIMAL(10, 2) DEFAULT 0,
+    fat DECIMAL(10, 2) DEFAULT 0,
+    carbs DECIMAL(10, 2) DEFAULT 0,
+    fibre DECIMAL(10, 2) DEFAULT 0,
+    sodium DECIMAL(10, 2) DEFAULT 0,
+    source VARCHAR(20) DEFAULT 'manual'
+);
+
+-- Nutrition per stock unit of each ingredient. Weight units convert to grams directly
+-- (volumes as water); other units need grams_per_unit or are left out.
+CREATE OR REPLACE VIEW ingredient_nutrition_per_unit AS
+SELECT n.ingredient_id,
+    n.kcal * f.factor AS kcal, n.protein * f.factor AS protein, n.fat * f.factor AS fat,
+    n.carbs * f.factor AS carbs, n.fibre * f.factor AS fibre, n.sodium * f.factor AS sodium
+FROM ingredient_nutrition n
+JOIN ingredients i ON n.ingredient_id = i.id
+CROSS JOIN LATERAL (
+    SELECT CASE WHEN n.basis = 'unit' THEN 1
+        ELSE COALESCE(n.grams_per_unit, CASE LOWER(TRIM(COALESCE(i.unit, '')))
+            WHEN 'g' THEN 1 WHEN '克' THEN 1 WHEN '公克' THEN 1
+            WHEN 'kg' THEN 1000 WHEN '公斤' THEN 1000
+            WHEN 'mg' THEN 0.001
+            WHEN 'ml' THEN 1 WHEN '毫升' THEN 1 WHEN 'cc' THEN 1
+            WHEN 'l' THEN 1000 WHEN '公升' THEN 1000
+            WHEN 'oz' THEN 28.35 WHEN 'lb' THEN 453.6
+            WHEN '斤' THEN 600 WHEN '兩' THEN 37.5
+        END) / 100
+    END AS factor
+) f
+WHERE f.factor IS NOT NULL;
+
+-- Allergens and diet-relevant contents of an ingredient (peanut, dairy, meat, ...)
+CREATE TABLE IF NOT EXISTS ingredient_flags (
+    ingredient_id INTEGER REFERENCES ingredients(id) ON DELETE CASCADE,
+    flag VARCHAR(30) NOT NULL,
+    PRIMARY KEY (ingredient_id, flag)
+);
+
+-- People who eat at home, guests included, and their allergies or diets
+CREATE TABLE IF NOT EXISTS household_members (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL UNIQUE,
+    is_guest BOOLEAN DEFAULT FALSE
+);
+
+-- A restriction is an ingredient flag (an allergy) or a diet; strict ones block scheduling
+CREATE TABLE IF NOT EXISTS member_restrictions (
+    member_id INTEGER REFERENCES household_members(id) ON DELETE CASCADE,
+    restriction VARCHAR(30) NOT NULL,
+    strict BOOLEAN DEFAULT TRUE,
+    PRIMARY KEY (member_id, restriction)
+);
+
+-- Add guest_count column (guests who are not household members) if table already exists without it
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name='meal_plan' AND column_name='guest_count') THEN
+        ALTER TABLE meal_plan ADD COLUMN guest_count INTEGER DEFAULT 0;
+    END IF;
+END $$;
+
+-- Add line_user_id column so members can use LINE commands, if table already exists without it
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name='household_members' AND column_name='line_user_id') THEN
+        ALTER TABLE household_members ADD COLUMN line_user_id VARCHAR(64) UNIQUE;
+    END IF;
+END $$;
+
+-- One-time codes a member sends to the LINE bot to link their LINE account,
+-- handed out by the app so nobody can link themselves to someone else
+CREATE TABLE IF NOT EXISTS line_link_codes (
+    code VARCHAR(16) PRIMARY KEY,
+    member_id INTEGER NOT NULL UNIQUE REFERENCES household_members(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP NOT NULL
+);
+
+-- Who eats a planned meal. Members without a row follow the default: household
+-- members attend, guest members do not.
+CREATE TABLE IF NOT EXISTS meal_attendance (
+    meal_plan_id INTEGER REFERENCES meal_plan(id) ON DELETE CASCADE,
+    member_id INTEGER REFERENCES household_members(id) ON DELETE CASCADE,
+    attending BOOLEAN NOT NULL,
+    PRIMARY KEY (meal_plan_id, member_id)
+);
+
+-- Servings and batches each planned meal needs. Explicit servings win, otherwise
+-- attendance decides; without any household members a meal is one batch.
+-- Leftover meals cook nothing.
+CREATE OR REPLACE VIEW meal_plan_servings AS
+SELECT mp.id AS meal_plan_id, s.servings,
+    CASE WHEN COALESCE(mp.is_leftovers, FALSE) THEN 0
+        ELSE COALESCE(CEIL(s.servings / GREATEST(COALESCE(r.servings, 1), 1)), 1)
+    END AS batches
+FROM meal_plan mp
+LEFT JOIN recipes r ON mp.recipe_id = r.id
+CROSS JOIN LATERAL (
+    SELECT COALESCE(mp.servings, CASE
+        WHEN EXISTS (SELECT 1 FROM household_members) THEN (
+            SELECT COUNT(*) FROM household_members m
+            LEFT JOIN meal_attendance a ON a.member_id = m.id AND a.meal_plan_id = mp.id
+            WHERE COALESCE(a.attending, NOT COALESCE(m.is_guest, FALSE))
+        ) + COALESCE(mp.guest_count, 0)
+        ELSE NULLIF(COALESCE(mp.guest_count, 0), 0)
+    END) AS servings
+) s;
+
+-- Ingredients that can stand in for another: ratio is the substitute quantity per
+-- unit of the original. A rule without a recipe applies to every recipe.
+CREATE TABLE IF NOT EXISTS ingredient_substitutes (
+    id SERIAL PRIMARY KEY,
+    ingredient_id INTEGER NOT NULL REFERENCES ingredients(id) ON DELETE CASCADE,
+    substitute_id INTEGER NOT NULL REFERENCES ingredients(id) ON DELETE CASCADE,
+    ratio DECIMAL(10, 3) NOT NULL DEFAULT 1 CHECK (ratio > 0),
+    recipe_id INTEGER REFERENCES recipes(id) ON DELETE CASCADE,
+    note TEXT DEFAULT '',
+    CHECK (ingredient_id <> substitute_id)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS ingredient_substitutes_key ON ingredient_substitutes (ingredient_id, substitute_id, COALESCE(recipe_id, 0));
+
+-- Other names an ingredient is known by ("egg", "雞蛋" for Eggs), used wherever ingredients are looked up by name
+CREATE TABLE IF NOT EXISTS ingredient_aliases (
+    id SERIAL PRIMARY KEY,
+    ingredient_id INTEGER NOT NULL REFERENCES ingredients(id) ON DELETE CASCADE,
+    alias VARCHAR(255) NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS ingredient_aliases_alias_key ON ingredient_aliases (LOWER(alias));
@@ -0,0 +1,294 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"modernc.org/sqlite"
+)
+
+// The handlers are written in PostgreSQL's dialect. sqliteDriver wraps the
+// pure-Go SQLite driver and translates what SQLite lacks: queries are rewritten
+// below, GREATEST, LEAST and TO_CHAR are added as functions, dates are bound as
+// YYYY-MM-DD text and read back as time.Time.
+type sqliteDriver struct {
+	base driver.Driver
+}
+
+const sqliteDriverName = "sqlite-postgres"
+
+func init() {
+	// sql.Open only looks the driver up, so this does not touch any file
+	db, err := sql.Open("sqlite", "")
+	if err != nil {
+		panic(err)
+	}
+	sql.Register(sqliteDriverName, &sqliteDriver{base: db.Driver()})
+	db.Close()
+
+	sqlite.MustRegisterDeterministicScalarFunction("greatest", -1, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		return pick(args, 1), nil
+	})
+	sqlite.MustRegisterDeterministicScalarFunction("least", -1, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		return pick(args, -1), nil
+	})
+	sqlite.MustRegisterDeterministicScalarFunction("to_char", 2, toChar)
+	sqlite.MustRegisterDeterministicScalarFunction("pg_array", 1, pgArray)
+}
+
+func openSQLite(path string) (*sql.DB, error) {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	// Transactions take the write lock when they begin, which is what the
+	// FOR UPDATE clauses dropped below stood for
+	db, err := sql.Open(sqliteDriverName, path+sep+
+		"_pragma=foreign_keys(1)&_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	if path == ":memory:" {
+		// Every connection would get its own empty database
+		db.SetMaxOpenConns(1)
+	}
+	return db, nil
+}
+
+func (d *sqliteDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.base.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteConn{c}, nil
+}
+
+var rewrites = []struct {
+	pattern *regexp.Regexp
+	replace string
+}{
+	{regexp.MustCompile(`\s+FOR UPDATE( OF \w+)?`), ""},
+	{regexp.MustCompile(`([\w.]+) - (\$\d+)::DATE\b`), `CAST(julianday($1) - julianday($2) AS INTEGER)`},
+	{regexp.MustCompile(`(\$\d+)::DATE \+ 1\b`), `date($1, '+1 day')`},
+	{regexp.MustCompile(`(\$\d+)::DATE\b`), `date($1)`},
+	{regexp.MustCompile(`(\$\d+)::(INTEGER|VARCHAR|TEXT)\b`), `CAST($1 AS $2)`},
+	{regexp.MustCompile(`([\w.]+) = ANY\((\$\d+)\)`), `$1 IN (SELECT value FROM json_each(pg_array($2)))`},
+	{regexp.MustCompile(`\bILIKE\b`), "LIKE"},
+	{regexp.MustCompile(`\bNOW\(\)`), "CURRENT_TIMESTAMP"},
+}
+
+var rewritten sync.Map
+
+// rewrite translates a PostgreSQL query to SQLite.
+func rewrite(query string) string {
+	if q, ok := rewritten.Load(query); ok {
+		return q.(string)
+	}
+	q := query
+	for _, r := range rewrites {
+		q = r.pattern.ReplaceAllString(q, r.replace)
+	}
+	rewritten.Store(query, q)
+	return q
+}
+
+// bind stores times as text that sorts and compares like the schema's dates.
+func bind(args []driver.NamedValue) []driver.NamedValue {
+	for i, a := range args {
+		t, ok := a.Value.(time.Time)
+		if !ok {
+			continue
+		}
+		if t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 && t.Nanosecond() == 0 {
+			args[i].Value = t.Format("2006-01-02")
+		} else {
+			args[i].Value = t.UTC().Format("2006-01-02 15:04:05")
+		}
+	}
+	return args
+}
+
+type sqliteConn struct {
+	driver.Conn
+}
+
+func (c *sqliteConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.Conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+}
+
+func (c *sqliteConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *sqliteConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	s, err := c.Conn.(driver.ConnPrepareContext).PrepareContext(ctx, rewrite(query))
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteStmt{s}, nil
+}
+
+func (c *sqliteConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.Conn.(driver.ExecerContext).ExecContext(ctx, rewrite(query), bind(args))
+}
+
+func (c *sqliteConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := c.Conn.(driver.QueryerContext).QueryContext(ctx, rewrite(query), bind(args))
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteRows{rows}, nil
+}
+
+func (c *sqliteConn) ResetSession(ctx context.Context) error {
+	return c.Conn.(driver.SessionResetter).ResetSession(ctx)
+}
+
+type sqliteStmt struct {
+	driver.Stmt
+}
+
+func (s *sqliteStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.Stmt.(driver.StmtExecContext).ExecContext(ctx, bind(args))
+}
+
+func (s *sqliteStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := s.Stmt.(driver.StmtQueryContext).QueryContext(ctx, bind(args))
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteRows{rows}, nil
+}
+
+// The driver parses columns declared DATE or TIMESTAMP itself; sqliteRows also
+// parses dates that come out of expressions, which have no declared type.
+type sqliteRows struct {
+	driver.Rows
+}
+
+var dateText = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}( \d{2}:\d{2}:\d{2}(\.\d+)?)?$`)
+
+func (r *sqliteRows) Next(dest []driver.Value) error {
+	if err := r.Rows.Next(dest); err != nil {
+		return err
+	}
+	for i, v := range dest {
+		s, ok := v.(string)
+		if !ok || !dateText.MatchString(s) || r.ColumnTypeDatabaseTypeName(i) != "" {
+			continue
+		}
+		if t, ok := parseTime(s); ok {
+			dest[i] = t
+		}
+	}
+	return nil
+}
+
+func (r *sqliteRows) ColumnTypeDatabaseTypeName(index int) string {
+	if t, ok := r.Rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return t.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
+
+func parseTime(s string) (time.Time, bool) {
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// pick returns the greatest (sign 1) or least (sign -1) non-NULL value, as
+// PostgreSQL's GREATEST and LEAST do.
+func pick(args []driver.Value, sign int) driver.Value {
+	var best driver.Value
+	for _, v := range args {
+		if v != nil && (best == nil || compare(v, best)*sign > 0) {
+			best = v
+		}
+	}
+	return best
+}
+
+// compare orders values the way SQLite does: numbers before text.
+func compare(a, b driver.Value) int {
+	x, aNum := number(a)
+	y, bNum := number(b)
+	switch {
+	case aNum && bNum:
+		if x < y {
+			return -1
+		} else if x > y {
+			return 1
+		}
+		return 0
+	case aNum:
+		return -1
+	case bNum:
+		return 1
+	}
+	return strings.Compare(text(a), text(b))
+}
+
+func number(v driver.Value) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func text(v driver.Value) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	case time.Time:
+		return s.Format("2006-01-02 15:04:05")
+	}
+	return ""
+}
+
+var toCharFormat = strings.NewReplacer("YYYY", "2006", "MM", "01", "DD", "02", "HH24", "15", "MI", "04", "SS", "05")
+
+// toChar implements TO_CHAR for dates and times with the formats the handlers use.
+func toChar(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+	if args[0] == nil {
+		return nil, nil
+	}
+	t, ok := parseTime(text(args[0]))
+	if !ok {
+		return nil, nil
+	}
+	return t.Format(toCharFormat.Replace(text(args[1]))), nil
+}
+
+// pgArray turns a PostgreSQL array literal such as {1,2,3}, which is what
+// pq.Array binds, into JSON for json_each.
+func pgArray(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+	literal := strings.TrimSuffix(strings.TrimPrefix(text(args[0]), "{"), "}")
+	items := []interface{}{}
+	if literal != "" {
+		for _, item := range strings.Split(literal, ",") {
+			if n, err := strconv.ParseFloat(item, 64); err == nil {
+				items = append(items, n)
+			} else {
+				items = append(items, strings.Trim(item, `"`))
+			}
+		}
+	}
+	b, err := json.Marshal(items)
+	return string(b), err
+}
@@ -0,0 +1,403 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Kano-Chien/house_management/backend/models"
+)
+
+// minMatchScore is the lowest similarity still suggested as a possible duplicate.
+const minMatchScore = 0.6
+
+// findIngredientByName looks an ingredient up by name or alias (case-insensitive),
+// preferring a name match. It returns sql.ErrNoRows when nothing matches.
+func findIngredientByName(q queryer, name string) (int, error) {
+	var id int
+	err := q.QueryRow(`
+		SELECT id FROM (
+			SELECT id, 0 AS rank FROM ingredients WHERE LOWER(name) = LOWER($1)
+			UNION ALL
+			SELECT ingredient_id, 1 FROM ingredient_aliases WHERE LOWER(alias) = LOWER($1)
+		) m
+		ORDER BY rank, id LIMIT 1
+	`, strings.TrimSpace(name)).Scan(&id)
+	return id, err
+}
+
+// loadIngredientAliases returns the aliases of ingredients, keyed by ingredient
+// ID. ingredientID 0 loads every ingredient.
+func loadIngredientAliases(q queryer, ingredientID int) (map[int][]string, error) {
+	rows, err := q.Query("SELECT ingredient_id, alias FROM ingredient_aliases WHERE $1 = 0 OR ingredient_id = $1 ORDER BY alias", ingredientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	aliases := map[int][]string{}
+	for rows.Next() {
+		var id int
+		var alias string
+		if err := rows.Scan(&id, &alias); err != nil {
+			return nil, err
+		}
+		aliases[id] = append(aliases[id], alias)
+	}
+	return aliases, rows.Err()
+}
+
+// normalizeIngredientName lowercases a name and drops an English plural ending,
+// so "Eggs" and "egg" compare equal.
+func normalizeIngredientName(name string) string {
+	s := strings.Join(strings.Fields(strings.ToLower(name)), " ")
+	if len(s) <= 3 || utf8.RuneCountInString(s) != len(s) {
+		return s
+	}
+	switch {
+	case strings.HasSuffix(s, "ies"):
+		return s[:len(s)-3] + "y"
+	case strings.HasSuffix(s, "oes"), strings.HasSuffix(s, "ches"), strings.HasSuffix(s, "shes"):
+		return s[:len(s)-2]
+	case strings.HasSuffix(s, "s") && !strings.HasSuffix(s, "ss"):
+		return s[:len(s)-1]
+	}
+	return s
+}
+
+// nameSimilarity scores two ingredient names from 0 to 1 by edit distance,
+// with names that contain each other scored at least 0.75.
+func nameSimilarity(a, b string) float64 {
+	a, b = normalizeIngredientName(a), normalizeIngredientName(b)
+	if a == b {
+		return 1
+	}
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 || len(rb) == 0 {
+		return 0
+	}
+
+	// Levenshtein distance over runes, one row at a time
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	longest := max(len(ra), len(rb))
+	score := 1 - float64(prev[len(rb)])/float64(longest)
+
+	if min(len(ra), len(rb)) >= 2 && (strings.Contains(a, b) || strings.Contains(b, a)) {
+		score = max(score, 0.75)
+	}
+	return score
+}
+
+// suggestIngredients returns the ingredients whose name or an alias looks like
+// name, best first. exclude is left out (0 for none).
+func suggestIngredients(q queryer, name string, limit, exclude int) ([]models.IngredientMatch, error) {
+	rows, err := q.Query(`
+		SELECT id, name, name FROM ingredients
+		UNION ALL
+		SELECT a.ingredient_id, i.name, a.alias FROM ingredient_aliases a JOIN ingredients i ON a.ingredient_id = i.id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []models.IngredientMatch
+	for rows.Next() {
+		var m models.IngredientMatch
+		if err := rows.Scan(&m.ID, &m.Name, &m.MatchedOn); err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return rankIngredientMatches(name, candidates, limit, exclude), nil
+}
+
+// rankIngredientMatches scores candidates, one per name or alias, against name
+// and keeps the best match of each ingredient, best first.
+func rankIngredientMatches(name string, candidates []models.IngredientMatch, limit, exclude int) []models.IngredientMatch {
+	best := map[int]models.IngredientMatch{}
+	for _, m := range candidates {
+		if m.ID == exclude {
+			continue
+		}
+		m.Score = nameSimilarity(name, m.MatchedOn)
+		if m.Score >= minMatchScore && m.Score > best[m.ID].Score {
+			best[m.ID] = m
+		}
+	}
+
+	matches := make([]models.IngredientMatch, 0, len(best))
+	for _, m := range best {
+		m.Score = float64(int(m.Score*100+0.5)) / 100
+		matches = append(matches, m)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].Name < matches[j].Name
+	})
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+// SuggestIngredients lists existing ingredients similar to ?name=, e.g. to offer
+// "Eggs" before creating "egg". ?limit= defaults to 5.
+func (h *InventoryHandler) SuggestIngredients(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if name == "" {
+		jsonError(w, "name required", http.StatusBadRequest)
+		return
+	}
+	limit := 5
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			jsonError(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	matches, err := h.Ingredients.SuggestIngredients(name, limit, 0)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(matches)
+}
+
+// SetAliases replaces the aliases of an ingredient.
+func (h *InventoryHandler) SetAliases(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		IngredientID int      `json:"ingredient_id"`
+		Aliases      []string `json:"aliases"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	var name string
+	err = tx.QueryRow("SELECT name FROM ingredients WHERE id = $1", req.IngredientID).Scan(&name)
+	if err == sql.ErrNoRows {
+		jsonError(w, "Ingredient not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if _, err := tx.Exec("DELETE FROM ingredient_aliases WHERE ingredient_id = $1", req.IngredientID); err != nil {
+		writeError(w, err)
+		return
+	}
+	aliases := []string{}
+	for _, alias := range req.Aliases {
+		alias = strings.TrimSpace(alias)
+		if alias == "" || strings.EqualFold(alias, name) {
+			continue
+		}
+		// An alias must not find a different ingredient
+		other, err := findIngredientByName(tx, alias)
+		if err == nil && other != req.IngredientID {
+			jsonError(w, fmt.Sprintf("%q already names ingredient %d", alias, other), http.StatusConflict)
+			return
+		} else if err != nil && err != sql.ErrNoRows {
+			writeError(w, err)
+			return
+		}
+		_, err = tx.Exec("INSERT INTO ingredient_aliases (ingredient_id, alias) VALUES ($1, $2) ON CONFLICT DO NOTHING", req.IngredientID, alias)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		aliases = append(aliases, alias)
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "updated", "aliases": aliases})
+}
+
+// MergeIngredients folds a duplicate ingredient into the canonical one: recipe
+// quantities, stock history, waste, counts, nutrition, flags, substitutes and
+// stock move over, the duplicate's name becomes an alias and the duplicate is deleted.
+func (h *InventoryHandler) MergeIngredients(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		CanonicalID int `json:"canonical_id"`
+		DuplicateID int `json:"duplicate_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.CanonicalID == req.DuplicateID {
+		jsonError(w, "An ingredient cannot be merged into itself", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	type ingredient struct {
+		name, unit string
+		stock      float64
+	}
+	found := map[int]ingredient{}
+	rows, err := tx.Query(
+		"SELECT id, name, COALESCE(unit, ''), current_stock FROM ingredients WHERE id IN ($1, $2) FOR UPDATE",
+		req.CanonicalID, req.DuplicateID,
+	)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	for rows.Next() {
+		var id int
+		var ing ingredient
+		if err := rows.Scan(&id, &ing.name, &ing.unit, &ing.stock); err != nil {
+			rows.Close()
+			writeError(w, err)
+			return
+		}
+		found[id] = ing
+	}
+	rows.Close()
+	canonical, ok := found[req.CanonicalID]
+	duplicate, ok2 := found[req.DuplicateID]
+	if !ok || !ok2 {
+		jsonError(w, "Ingredient not found", http.StatusNotFound)
+		return
+	}
+	// Quantities are counted in the ingredient's unit, so they only add up when the units agree
+	if canonical.unit != "" && duplicate.unit != "" && !strings.EqualFold(canonical.unit, duplicate.unit) {
+		jsonError(w, fmt.Sprintf("unit mismatch: %s is counted in %q, %s in %q", canonical.name, canonical.unit, duplicate.name, duplicate.unit), http.StatusConflict)
+		return
+	}
+
+	// Recipes using both keep one line with the quantities added up; the
+	// duplicate's step references follow before its line is deleted with it
+	steps := []string{
+		`INSERT INTO recipe_step_ingredients (step_id, recipe_id, ingredient_id)
+		 SELECT si.step_id, si.recipe_id, $1 FROM recipe_step_ingredients si
+		 JOIN recipe_ingredients ri ON ri.recipe_id = si.recipe_id AND ri.ingredient_id = $1
+		 WHERE si.ingredient_id = $2
+		 ON CONFLICT DO NOTHING`,
+		`UPDATE recipe_ingredients AS c SET quantity = c.quantity + d.quantity
+		 FROM recipe_ingredients d
+		 WHERE c.ingredient_id = $1 AND d.ingredient_id = $2 AND d.recipe_id = c.recipe_id`,
+		// Step references follow through ON UPDATE CASCADE
+		`UPDATE recipe_ingredients SET ingredient_id = $1
+		 WHERE ingredient_id = $2 AND recipe_id NOT IN (SELECT recipe_id FROM recipe_ingredients WHERE ingredient_id = $1)`,
+		`UPDATE inventory_history SET ingredient_id = $1 WHERE ingredient_id = $2`,
+		`UPDATE waste_log SET ingredient_id = $1 WHERE ingredient_id = $2`,
+		`UPDATE stocktake_counts AS c SET counted_quantity = c.counted_quantity + d.counted_quantity
+		 FROM stocktake_counts d
+		 WHERE c.ingredient_id = $1 AND d.ingredient_id = $2 AND d.stocktake_id = c.stocktake_id`,
+		`UPDATE stocktake_counts SET ingredient_id = $1
+		 WHERE ingredient_id = $2 AND stocktake_id NOT IN (SELECT stocktake_id FROM stocktake_counts WHERE ingredient_id = $1)`,
+		`UPDATE ingredient_nutrition SET ingredient_id = $1
+		 WHERE ingredient_id = $2 AND NOT EXISTS (SELECT 1 FROM ingredient_nutrition WHERE ingredient_id = $1)`,
+		`INSERT INTO ingredient_flags (ingredient_id, flag)
+		 SELECT $1, flag FROM ingredient_flags WHERE ingredient_id = $2
+		 ON CONFLICT DO NOTHING`,
+		`INSERT INTO ingredient_substitutes (ingredient_id, substitute_id, ratio, recipe_id, note)
+		 SELECT CASE WHEN ingredient_id = $2 THEN $1 ELSE ingredient_id END,
+			CASE WHEN substitute_id = $2 THEN $1 ELSE substitute_id END,
+			ratio, recipe_id, note
+		 FROM ingredient_substitutes
+		 WHERE (ingredient_id = $2 OR substitute_id = $2) AND NOT (ingredient_id IN ($1, $2) AND substitute_id IN ($1, $2))
+		 ON CONFLICT DO NOTHING`,
+		`UPDATE ingredient_aliases SET ingredient_id = $1 WHERE ingredient_id = $2`,
+		`UPDATE ingredients AS c SET
+			current_stock = c.current_stock + d.current_stock,
+			expiry_date = LEAST(c.expiry_date, d.expiry_date),
+			price = CASE WHEN COALESCE(c.price, 0) = 0 THEN d.price ELSE c.price END,
+			unit = COALESCE(c.unit, d.unit),
+			location_id = COALESCE(c.location_id, d.location_id),
+			is_tracked = c.is_tracked OR d.is_tracked
+		 FROM ingredients d
+		 WHERE c.id = $1 AND d.id = $2`,
+	}
+	for _, query := range steps {
+		if _, err := tx.Exec(query, req.CanonicalID, req.DuplicateID); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM ingredients WHERE id = $1", req.DuplicateID); err != nil {
+		writeError(w, err)
+		return
+	}
+	if !strings.EqualFold(canonical.name, duplicate.name) {
+		_, err := tx.Exec("INSERT INTO ingredient_aliases (ingredient_id, alias) VALUES ($1, $2) ON CONFLICT DO NOTHING", req.CanonicalID, duplicate.name)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	_, err = tx.Exec(
+		"INSERT INTO inventory_history (ingredient_id, action, quantity_change, note) VALUES ($1, 'merge', $2, $3)",
+		req.CanonicalID, duplicate.stock, fmt.Sprintf("merged %s", duplicate.name),
+	)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":        "merged",
+		"id":            req.CanonicalID,
+		"current_stock": canonical.stock + duplicate.stock,
+	})
+}
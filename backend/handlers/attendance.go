@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kano-Chien/house_management/backend/models"
+	"github.com/lib/pq"
+)
+
+// setAttendees records that exactly the listed members eat a meal.
+func setAttendees(tx *sql.Tx, mealPlanID int, memberIDs []int) error {
+	if _, err := tx.Exec("DELETE FROM meal_attendance WHERE meal_plan_id = $1", mealPlanID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`
+		INSERT INTO meal_attendance (meal_plan_id, member_id, attending)
+		SELECT $1, m.id, m.id = ANY($2) FROM household_members m
+	`, mealPlanID, pq.Array(memberIDs))
+	return err
+}
+
+// markAttendance sets whether a member eats the meals of one date and meal type,
+// returning how many planned meals were changed.
+func markAttendance(q queryer, memberID int, date time.Time, mealType string, attending bool) (int, error) {
+	result, err := q.Exec(`
+		INSERT INTO meal_attendance (meal_plan_id, member_id, attending)
+		SELECT mp.id, $1, $4 FROM meal_plan mp
+		WHERE mp.date = $2::DATE AND LOWER(mp.meal_type) = LOWER($3)
+		ON CONFLICT (meal_plan_id, member_id) DO UPDATE SET attending = EXCLUDED.attending
+	`, memberID, date.Format("2006-01-02"), mealType, attending)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+func loadAttendees(q queryer, mealPlanID int) ([]models.MealAttendee, error) {
+	rows, err := q.Query(`
+		SELECT m.id, m.name, COALESCE(m.is_guest, FALSE), COALESCE(a.attending, NOT COALESCE(m.is_guest, FALSE))
+		FROM household_members m
+		LEFT JOIN meal_attendance a ON a.member_id = m.id AND a.meal_plan_id = $1
+		ORDER BY m.is_guest, m.name
+	`, mealPlanID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attendees := []models.MealAttendee{}
+	for rows.Next() {
+		var a models.MealAttendee
+		if err := rows.Scan(&a.MemberID, &a.Name, &a.IsGuest, &a.Attending); err != nil {
+			return nil, err
+		}
+		attendees = append(attendees, a)
+	}
+	return attendees, rows.Err()
+}
+
+// writeAttendance responds with who eats a meal, the servings that makes and
+// any diet conflicts of the recipe with the people attending.
+func writeAttendance(w http.ResponseWriter, q queryer, mealPlanID int) {
+	var recipeID sql.NullInt64
+	var guests int
+	var servings sql.NullFloat64
+	err := q.QueryRow(`
+		SELECT mp.recipe_id, COALESCE(mp.guest_count, 0), s.servings
+		FROM meal_plan mp
+		JOIN meal_plan_servings s ON s.meal_plan_id = mp.id
+		WHERE mp.id = $1
+	`, mealPlanID).Scan(&recipeID, &guests, &servings)
+	if err == sql.ErrNoRows {
+		jsonError(w, "Meal plan not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	attendees, err := loadAttendees(q, mealPlanID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var warnings []models.DietConflict
+	if recipeID.Valid {
+		ids := []int{}
+		for _, a := range attendees {
+			if a.Attending {
+				ids = append(ids, a.MemberID)
+			}
+		}
+		if warnings, err = checkRecipeForMembers(q, int(recipeID.Int64), ids); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	var planned *float64
+	if servings.Valid {
+		planned = &servings.Float64
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"meal_plan_id":     mealPlanID,
+		"members":          attendees,
+		"guest_count":      guests,
+		"planned_servings": planned,
+		"warnings":         warnings,
+	})
+}
+
+func (h *MealPlanHandler) GetAttendance(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("meal_plan_id"))
+	if err != nil {
+		jsonError(w, "meal_plan_id required", http.StatusBadRequest)
+		return
+	}
+	writeAttendance(w, h.DB, id)
+}
+
+// SetAttendance replaces who eats a meal. Fields left out are kept.
+func (h *MealPlanHandler) SetAttendance(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MealPlanID int   `json:"meal_plan_id"`
+		MemberIDs  []int `json:"member_ids"`  // Everyone attending; members not listed are out
+		GuestCount *int  `json:"guest_count"` // Guests who are not household members
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.GuestCount != nil && *req.GuestCount < 0 {
+		jsonError(w, "guest_count cannot be negative", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE meal_plan SET guest_count = COALESCE($1, guest_count) WHERE id = $2", req.GuestCount, req.MealPlanID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		jsonError(w, "Meal plan not found", http.StatusNotFound)
+		return
+	}
+	if req.MemberIDs != nil {
+		if err := setAttendees(tx, req.MealPlanID, req.MemberIDs); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeAttendance(w, h.DB, req.MealPlanID)
+}
+
+// MarkAttendance lets a member say they are out for (or back for) the meals of a
+// date, e.g. {"member_id": 2, "date": "2026-10-20", "meal_type": "Dinner"}.
+func (h *MealPlanHandler) MarkAttendance(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MemberID  int    `json:"member_id"`
+		Date      string `json:"date"`      // YYYY-MM-DD, default today
+		MealType  string `json:"meal_type"` // Default Dinner
+		Attending bool   `json:"attending"` // Default false: out
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	date := time.Now()
+	if req.Date != "" {
+		var err error
+		if date, err = time.Parse("2006-01-02", req.Date); err != nil {
+			jsonError(w, "Invalid date format. Use YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.MealType == "" {
+		req.MealType = "Dinner"
+	}
+	req.MealType = strings.ToUpper(req.MealType[:1]) + strings.ToLower(req.MealType[1:])
+	var v validator
+	v.check(mealTypes[req.MealType], "meal_type", "must be Breakfast, Lunch or Dinner")
+	if v.failed(w) {
+		return
+	}
+
+	var exists bool
+	if err := h.DB.QueryRow("SELECT EXISTS (SELECT 1 FROM household_members WHERE id = $1)", req.MemberID).Scan(&exists); err != nil {
+		writeError(w, err)
+		return
+	}
+	if !exists {
+		jsonError(w, "Member not found", http.StatusNotFound)
+		return
+	}
+
+	n, err := markAttendance(h.DB, req.MemberID, date, req.MealType, req.Attending)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if n == 0 {
+		jsonError(w, "No "+req.MealType+" planned on "+date.Format("2006-01-02"), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "updated", "meals": n})
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Kano-Chien/house_management/backend/backup"
+)
+
+// maxArchiveSize bounds an uploaded backup; a household's data is far smaller.
+const maxArchiveSize = 256 << 20
+
+type BackupHandler struct {
+	DB *sql.DB
+}
+
+// AdminOnly lets through requests carrying "Authorization: Bearer <token>".
+// The token is a header rather than a cookie, so a page on another site
+// cannot send it even while CORS allows any origin.
+func AdminOnly(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			jsonError(w, "Admin token required", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Download answers with a backup archive of all data.
+func (h *BackupHandler) Download(w http.ResponseWriter, r *http.Request) {
+	// Built in memory first so a failure is still reported as an error
+	var buf bytes.Buffer
+	if _, err := backup.Write(r.Context(), h.DB, &buf); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, backup.FileName(time.Now())))
+	w.Write(buf.Bytes())
+}
+
+// Restore loads the archive sent as the request body. ?mode=replace deletes all
+// data first; the default merge adds the archive's rows to the existing ones.
+func (h *BackupHandler) Restore(w http.ResponseWriter, r *http.Request) {
+	mode := backup.Merge
+	if m := r.URL.Query().Get("mode"); m != "" {
+		mode = backup.Mode(m)
+	}
+	var v validator
+	v.check(mode == backup.Merge || mode == backup.Replace, "mode", "must be merge or replace")
+	if v.failed(w) {
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxArchiveSize))
+	if err != nil {
+		jsonError(w, "Backup archive too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	rep, err := backup.Restore(r.Context(), h.DB, bytes.NewReader(body), int64(len(body)), mode)
+	if errors.Is(err, backup.ErrArchive) {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rep)
+}
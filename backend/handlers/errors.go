@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// APIError is the JSON body of every error response, e.g.
+// {"code": "validation_failed", "message": "Invalid input", "fields": {"name": "is required"}}.
+type APIError struct {
+	Status  int               `json:"-"`
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`  // Field name -> problem
+	Details interface{}       `json:"details,omitempty"` // What caused the error, e.g. the diet conflicts of a meal
+
+	RequestID string `json:"request_id,omitempty"` // Filled in from the X-Request-ID response header
+}
+
+func (e *APIError) Error() string { return e.Message }
+
+// newAPIError makes an error whose code follows the status: 404 is "not_found".
+func newAPIError(status int, message string) *APIError {
+	return &APIError{Status: status, Code: statusCode(status), Message: message}
+}
+
+func statusCode(status int) string {
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+func writeAPIError(w http.ResponseWriter, e *APIError) {
+	body := *e
+	body.RequestID = w.Header().Get("X-Request-ID")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// jsonError is http.Error with a JSON body.
+func jsonError(w http.ResponseWriter, message string, status int) {
+	writeAPIError(w, newAPIError(status, message))
+}
+
+// writeError reports an error from the database or a helper. Constraint
+// violations and bad input rejected by the database become 4xx responses; anything
+// else is logged and answered with a 500 that does not expose the cause.
+func writeError(w http.ResponseWriter, err error) {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		writeAPIError(w, apiErr)
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		jsonError(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	if e := dbAPIError(err); e != nil {
+		writeAPIError(w, e)
+		return
+	}
+
+	slog.Error("Request failed", "request_id", w.Header().Get("X-Request-ID"), "error", err)
+	jsonError(w, "Internal server error", http.StatusInternalServerError)
+}
+
+// dbAPIError maps database errors caused by the request to a 4xx error, nil for the rest.
+func dbAPIError(err error) *APIError {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqAPIError(pqErr)
+	}
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteAPIError(sqliteErr)
+	}
+	return nil
+}
+
+// pqAPIError maps PostgreSQL errors caused by the request to a 4xx error, nil for the rest.
+func pqAPIError(err *pq.Error) *APIError {
+	switch err.Code.Name() {
+	case "foreign_key_violation":
+		// Deleting something still in use, or pointing at something that does not exist
+		if strings.Contains(err.Detail, "still referenced") {
+			return &APIError{Status: http.StatusConflict, Code: "in_use", Message: "Still in use by " + err.Table}
+		}
+		e := &APIError{Status: http.StatusUnprocessableEntity, Code: "unknown_reference", Message: "Refers to a record that does not exist"}
+		if field := detailColumn(err.Detail); field != "" {
+			e.Fields = map[string]string{field: "does not exist"}
+		}
+		return e
+	case "unique_violation":
+		e := &APIError{Status: http.StatusConflict, Code: "duplicate", Message: "Already exists"}
+		if field := detailColumn(err.Detail); field != "" {
+			e.Fields = map[string]string{field: "already exists"}
+		}
+		return e
+	case "check_violation":
+		e := &APIError{Status: http.StatusUnprocessableEntity, Code: "check_violation", Message: "Value not allowed"}
+		// Column checks are named <table>_<column>_check
+		if field := strings.TrimSuffix(strings.TrimPrefix(err.Constraint, err.Table+"_"), "_check"); field != "" && field != err.Constraint {
+			e.Fields = map[string]string{field: "not allowed"}
+		}
+		return e
+	case "not_null_violation":
+		return &APIError{Status: http.StatusUnprocessableEntity, Code: "validation_failed", Message: "Missing value",
+			Fields: map[string]string{err.Column: "is required"}}
+	case "string_data_right_truncation":
+		return &APIError{Status: http.StatusUnprocessableEntity, Code: "validation_failed", Message: "Value too long"}
+	case "invalid_text_representation", "invalid_datetime_format", "datetime_field_overflow", "numeric_value_out_of_range":
+		return &APIError{Status: http.StatusBadRequest, Code: "bad_request", Message: "Invalid value: " + err.Message}
+	case "serialization_failure", "deadlock_detected":
+		return &APIError{Status: http.StatusConflict, Code: "retry", Message: "Conflicting update, try again"}
+	}
+	return nil
+}
+
+// sqliteAPIError is pqAPIError for SQLite, whose messages name the column as
+// "UNIQUE constraint failed: tags.name" but not the row.
+func sqliteAPIError(err *sqlite.Error) *APIError {
+	_, detail, _ := strings.Cut(err.Error(), " constraint failed: ")
+	detail, _, _ = strings.Cut(detail, " (")
+	switch err.Code() {
+	case sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY:
+		// Every reference cascades or is cleared on delete, so only a missing record fails
+		return &APIError{Status: http.StatusUnprocessableEntity, Code: "unknown_reference", Message: "Refers to a record that does not exist"}
+	case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+		e := &APIError{Status: http.StatusConflict, Code: "duplicate", Message: "Already exists"}
+		if _, field, ok := strings.Cut(detail, "."); ok && !strings.Contains(field, ",") {
+			e.Fields = map[string]string{field: "already exists"}
+		}
+		return e
+	case sqlite3.SQLITE_CONSTRAINT_CHECK:
+		e := &APIError{Status: http.StatusUnprocessableEntity, Code: "check_violation", Message: "Value not allowed"}
+		// Column checks read "kind IN (...)" or "ratio > 0"
+		if m := checkColumn.FindStringSubmatch(detail); m != nil {
+			e.Fields = map[string]string{m[1]: "not allowed"}
+		}
+		return e
+	case sqlite3.SQLITE_CONSTRAINT_NOTNULL:
+		_, field, _ := strings.Cut(detail, ".")
+		return &APIError{Status: http.StatusUnprocessableEntity, Code: "validation_failed", Message: "Missing value",
+			Fields: map[string]string{field: "is required"}}
+	case sqlite3.SQLITE_BUSY:
+		return &APIError{Status: http.StatusConflict, Code: "retry", Message: "Conflicting update, try again"}
+	}
+	return nil
+}
+
+var checkColumn = regexp.MustCompile(`^(\w+) (IN \(|>|>=|<|<=) `)
+
+// detailColumn returns the column from a detail like "Key (recipe_id)=(5) is not present in table".
+func detailColumn(detail string) string {
+	start := strings.Index(detail, "Key (")
+	if start < 0 {
+		return ""
+	}
+	rest := detail[start+len("Key ("):]
+	end := strings.Index(rest, ")")
+	if end < 0 {
+		return ""
+	}
+	// Expression keys such as lower(name) are reported by their inner column
+	column := rest[:end]
+	if i := strings.LastIndex(column, "("); i >= 0 {
+		column = column[i+1:]
+	}
+	return column
+}
+
+// validator collects field problems so a request is rejected with all of them at once.
+type validator struct {
+	fields map[string]string
+}
+
+// check records message for field unless ok. The first problem of a field is kept.
+func (v *validator) check(ok bool, field, message string) {
+	if ok {
+		return
+	}
+	if v.fields == nil {
+		v.fields = map[string]string{}
+	}
+	if _, seen := v.fields[field]; !seen {
+		v.fields[field] = message
+	}
+}
+
+// err returns a 400 validation error listing the failed checks, nil when all passed.
+func (v *validator) err() error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+	return &APIError{Status: http.StatusBadRequest, Code: "validation_failed", Message: "Invalid input", Fields: v.fields}
+}
+
+// failed writes the validation error when a check failed.
+func (v *validator) failed(w http.ResponseWriter) bool {
+	if err := v.err(); err != nil {
+		writeError(w, err)
+		return true
+	}
+	return false
+}
+
+// JSONErrors rewrites the plain-text errors net/http writes itself (unknown
+// routes, wrong methods) into the JSON error body.
+func JSONErrors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&plainErrorWriter{ResponseWriter: w}, r)
+	})
+}
+
+type plainErrorWriter struct {
+	http.ResponseWriter
+	status int // Set once a plain-text error is being replaced
+}
+
+func (w *plainErrorWriter) WriteHeader(status int) {
+	if status >= 400 && strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		w.status = status
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *plainErrorWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		return w.ResponseWriter.Write(b)
+	}
+	status := w.status
+	w.status = 0
+	w.Header().Del("X-Content-Type-Options")
+	writeAPIError(w.ResponseWriter, newAPIError(status, strings.TrimSpace(string(b))))
+	return len(b), nil
+}
@@ -0,0 +1,778 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kano-Chien/house_management/backend/database"
+	"github.com/Kano-Chien/house_management/backend/metrics"
+	"github.com/Kano-Chien/house_management/backend/models"
+)
+
+type testHandlers struct {
+	store     Store
+	inventory *InventoryHandler
+	recipes   *RecipeHandler
+	mealPlans *MealPlanHandler
+	shopping  *ShoppingListHandler
+}
+
+// eachStore runs test on the in-memory store and on the SQL store over a
+// fresh SQLite file, so both keep the same behaviour.
+func eachStore(t *testing.T, test func(t *testing.T, th testHandlers)) {
+	t.Run("memory", func(t *testing.T) {
+		store := NewMemoryStore()
+		test(t, testHandlers{
+			store:     store,
+			inventory: &InventoryHandler{Ingredients: store},
+			recipes:   &RecipeHandler{Recipes: store, Ingredients: store},
+			mealPlans: &MealPlanHandler{MealPlans: store},
+			shopping:  &ShoppingListHandler{Shopping: store},
+		})
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		db, err := database.Open("sqlite:" + filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		if err := database.Migrate(db); err != nil {
+			t.Fatal(err)
+		}
+		store := NewSQLStore(db)
+		test(t, testHandlers{
+			store:     store,
+			inventory: &InventoryHandler{DB: db, Ingredients: store},
+			recipes:   &RecipeHandler{DB: db, Recipes: store, Ingredients: store},
+			mealPlans: &MealPlanHandler{DB: db, MealPlans: store},
+			shopping:  &ShoppingListHandler{Shopping: store},
+		})
+	})
+}
+
+// newTestDB opens a migrated SQLite database in a temporary file.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := database.Open("sqlite:" + filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := database.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// call runs a handler with body encoded as JSON (nil for none) and checks the status.
+func call(t *testing.T, h http.HandlerFunc, method, target string, body interface{}, status int) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest(method, target, &buf))
+	if w.Code != status {
+		t.Fatalf("%s %s: status %d, want %d: %s", method, target, w.Code, status, w.Body.String())
+	}
+	return w
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %q: %v", w.Body.String(), err)
+	}
+}
+
+func (th testHandlers) addIngredient(t *testing.T, name string, stock float64, tracked bool) int {
+	t.Helper()
+	var i models.Ingredient
+	decode(t, call(t, th.inventory.AddIngredient, "POST", "/api/ingredients",
+		map[string]interface{}{"name": name, "current_stock": stock, "unit": "g", "is_tracked": tracked}, http.StatusCreated), &i)
+	return i.ID
+}
+
+func (th testHandlers) addRecipe(t *testing.T, name string, servings int, ingredients ...models.RecipeIngredient) int {
+	t.Helper()
+	var r models.Recipe
+	decode(t, call(t, th.recipes.CreateRecipe, "POST", "/api/recipes",
+		models.Recipe{Name: name, Servings: servings, Ingredients: ingredients}, http.StatusCreated), &r)
+	return r.ID
+}
+
+func (th testHandlers) schedule(t *testing.T, meal map[string]interface{}) int {
+	t.Helper()
+	var resp struct {
+		ID int `json:"id"`
+	}
+	decode(t, call(t, th.mealPlans.ScheduleMeal, "POST", "/api/meal-plans", meal, http.StatusOK), &resp)
+	return resp.ID
+}
+
+func (th testHandlers) stock(t *testing.T, id int) float64 {
+	t.Helper()
+	i, err := th.store.GetIngredient(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return i.CurrentStock
+}
+
+func (th testHandlers) meal(t *testing.T, id int) models.MealPlan {
+	t.Helper()
+	var plan []models.MealPlan
+	decode(t, call(t, th.mealPlans.GetMealPlan, "GET", "/api/meal-plans", nil, http.StatusOK), &plan)
+	for _, m := range plan {
+		if m.ID == id {
+			return m
+		}
+	}
+	t.Fatalf("meal %d not in the plan", id)
+	return models.MealPlan{}
+}
+
+func date(days int) string {
+	return time.Now().AddDate(0, 0, days).Format("2006-01-02")
+}
+
+func TestIngredients(t *testing.T) {
+	eachStore(t, func(t *testing.T, th testHandlers) {
+
+		w := call(t, th.inventory.AddIngredient, "POST", "/api/ingredients",
+			map[string]interface{}{"name": " ", "current_stock": -1, "category": "toys"}, http.StatusBadRequest)
+		var apiErr APIError
+		decode(t, w, &apiErr)
+		for _, field := range []string{"name", "current_stock", "category"} {
+			if apiErr.Fields[field] == "" {
+				t.Errorf("no validation error for %s: %+v", field, apiErr)
+			}
+		}
+
+		eggs := th.addIngredient(t, "Eggs", 12, true)
+		th.addIngredient(t, "Soap", 2, true)
+
+		var inventory []models.Ingredient
+		decode(t, call(t, th.inventory.GetInventory, "GET", "/api/ingredients", nil, http.StatusOK), &inventory)
+		if len(inventory) != 2 || inventory[0].Name != "Eggs" || inventory[0].Category != "food" {
+			t.Fatalf("inventory = %+v", inventory)
+		}
+		decode(t, call(t, th.inventory.GetInventory, "GET", "/api/ingredients?location_id=none", nil, http.StatusOK), &inventory)
+		if len(inventory) != 2 {
+			t.Errorf("unassigned items = %d, want 2", len(inventory))
+		}
+		call(t, th.inventory.GetInventory, "GET", "/api/ingredients?location_id=x", nil, http.StatusBadRequest)
+
+		var got models.Ingredient
+		decode(t, call(t, th.inventory.GetIngredient, "GET", "/api/ingredients/1?id=1", nil, http.StatusOK), &got)
+		if got.ID != eggs || got.CurrentStock != 12 {
+			t.Errorf("ingredient = %+v", got)
+		}
+		call(t, th.inventory.GetIngredient, "GET", "/api/ingredients/99?id=99", nil, http.StatusNotFound)
+		call(t, th.inventory.GetIngredient, "GET", "/api/ingredients/x?id=x", nil, http.StatusBadRequest)
+
+		// Editing keeps the fields that are left out
+		call(t, th.inventory.EditIngredient, "PATCH", "/api/ingredients/1",
+			map[string]interface{}{"id": eggs, "price": 4.5}, http.StatusOK)
+		got, _ = th.store.GetIngredient(eggs)
+		if got.Name != "Eggs" || got.CurrentStock != 12 || got.Price != 4.5 || !got.IsTracked {
+			t.Errorf("after edit = %+v", got)
+		}
+		call(t, th.inventory.EditIngredient, "PATCH", "/api/ingredients/1",
+			map[string]interface{}{"id": eggs, "category": "toys"}, http.StatusBadRequest)
+		call(t, th.inventory.EditIngredient, "PATCH", "/api/ingredients/99",
+			map[string]interface{}{"id": 99, "price": 1}, http.StatusNotFound)
+
+		call(t, th.inventory.UpdateStock, "PUT", "/api/ingredients/1/stock",
+			map[string]interface{}{"id": eggs, "new_stock": 6}, http.StatusOK)
+		if s := th.stock(t, eggs); s != 6 {
+			t.Errorf("stock = %v, want 6", s)
+		}
+		call(t, th.inventory.UpdateStock, "PUT", "/api/ingredients/1/stock",
+			map[string]interface{}{"id": eggs, "new_stock": -1}, http.StatusBadRequest)
+		call(t, th.inventory.UpdateStock, "PUT", "/api/ingredients/99/stock",
+			map[string]interface{}{"id": 99, "new_stock": 1}, http.StatusNotFound)
+
+		call(t, th.inventory.DeleteIngredient, "DELETE", "/api/ingredients/1", map[string]int{"id": eggs}, http.StatusOK)
+		call(t, th.inventory.DeleteIngredient, "DELETE", "/api/ingredients/1", map[string]int{"id": eggs}, http.StatusNotFound)
+	})
+}
+
+func TestSuggestIngredients(t *testing.T) {
+	eachStore(t, func(t *testing.T, th testHandlers) {
+		th.addIngredient(t, "Eggs", 1, true)
+		th.addIngredient(t, "Flour", 1, true)
+
+		var matches []models.IngredientMatch
+		decode(t, call(t, th.inventory.SuggestIngredients, "GET", "/api/ingredients/suggest?name=egg", nil, http.StatusOK), &matches)
+		if len(matches) != 1 || matches[0].Name != "Eggs" || matches[0].Score != 1 {
+			t.Errorf("matches = %+v", matches)
+		}
+		call(t, th.inventory.SuggestIngredients, "GET", "/api/ingredients/suggest", nil, http.StatusBadRequest)
+		call(t, th.inventory.SuggestIngredients, "GET", "/api/ingredients/suggest?name=egg&limit=0", nil, http.StatusBadRequest)
+	})
+}
+
+func TestRecipes(t *testing.T) {
+	eachStore(t, func(t *testing.T, th testHandlers) {
+		flour := th.addIngredient(t, "Flour", 1000, true)
+
+		call(t, th.recipes.CreateRecipe, "POST", "/api/recipes", models.Recipe{Name: ""}, http.StatusBadRequest)
+		call(t, th.recipes.CreateRecipe, "POST", "/api/recipes",
+			models.Recipe{Name: "Bread", Ingredients: []models.RecipeIngredient{{IngredientID: 99, Quantity: 1}}}, http.StatusUnprocessableEntity)
+		call(t, th.recipes.CreateRecipe, "POST", "/api/recipes", models.Recipe{
+			Name:        "Bread",
+			Ingredients: []models.RecipeIngredient{{IngredientID: flour, Quantity: 500}},
+			Steps:       []models.RecipeStep{{Text: "Knead", IngredientIDs: []int{flour + 100}}},
+		}, http.StatusBadRequest)
+
+		var bread models.Recipe
+		decode(t, call(t, th.recipes.CreateRecipe, "POST", "/api/recipes", models.Recipe{
+			Name:        "Bread",
+			Notes:       "Crusty",
+			Ingredients: []models.RecipeIngredient{{IngredientID: flour, Quantity: 500}},
+			Steps:       []models.RecipeStep{{Text: "Knead", IngredientIDs: []int{flour}}},
+		}, http.StatusCreated), &bread)
+		if bread.ID == 0 || bread.Servings != 1 || bread.Steps[0].ID == 0 || bread.Steps[0].TemperatureUnit != "C" {
+			t.Fatalf("created = %+v", bread)
+		}
+
+		var recipes []models.Recipe
+		decode(t, call(t, th.recipes.GetRecipes, "GET", "/api/recipes", nil, http.StatusOK), &recipes)
+		if len(recipes) != 1 || recipes[0].Name != "Bread" {
+			t.Errorf("recipes = %+v", recipes)
+		}
+		call(t, th.recipes.GetRecipes, "GET", "/api/recipes?member_ids=x", nil, http.StatusBadRequest)
+
+		// Renaming keeps the notes and servings
+		call(t, th.recipes.UpdateRecipeName, "PATCH", "/api/recipes/1", map[string]interface{}{"id": bread.ID, "name": "Loaf"}, http.StatusOK)
+		got, _ := th.store.GetRecipe(bread.ID)
+		if got.Name != "Loaf" || got.Notes != "Crusty" || got.Servings != 1 {
+			t.Errorf("after rename = %+v", got)
+		}
+		call(t, th.recipes.UpdateRecipeName, "PATCH", "/api/recipes/1", map[string]interface{}{"id": bread.ID, "servings": 4}, http.StatusOK)
+		if got, _ = th.store.GetRecipe(bread.ID); got.Servings != 4 || got.Name != "Loaf" {
+			t.Errorf("after servings = %+v", got)
+		}
+		call(t, th.recipes.UpdateRecipeName, "PATCH", "/api/recipes/1", map[string]interface{}{"id": bread.ID, "servings": 0}, http.StatusBadRequest)
+		call(t, th.recipes.UpdateRecipeName, "PATCH", "/api/recipes/99", map[string]interface{}{"id": 99, "name": "X"}, http.StatusNotFound)
+
+		call(t, th.recipes.DeleteRecipe, "DELETE", "/api/recipes/1", map[string]int{"id": bread.ID}, http.StatusOK)
+		decode(t, call(t, th.recipes.GetRecipes, "GET", "/api/recipes", nil, http.StatusOK), &recipes)
+		if len(recipes) != 0 {
+			t.Errorf("recipes after delete = %+v", recipes)
+		}
+	})
+}
+
+func TestRecipeIngredients(t *testing.T) {
+	eachStore(t, func(t *testing.T, th testHandlers) {
+		eggs := th.addIngredient(t, "Eggs", 12, true)
+		cake := th.addRecipe(t, "Cake", 8)
+
+		call(t, th.recipes.AddRecipeIngredient, "POST", "/api/recipes/1/ingredients",
+			map[string]interface{}{"recipe_id": cake, "ingredient_id": eggs, "quantity": 0}, http.StatusBadRequest)
+		call(t, th.recipes.AddRecipeIngredient, "POST", "/api/recipes/99/ingredients",
+			map[string]interface{}{"recipe_id": 99, "ingredient_id": eggs, "quantity": 1}, http.StatusUnprocessableEntity)
+		call(t, th.recipes.AddRecipeIngredient, "POST", "/api/recipes/1/ingredients",
+			map[string]interface{}{"recipe_id": cake, "ingredient_id": eggs, "quantity": 3}, http.StatusOK)
+
+		// A name that is not known yet creates the ingredient and suggests look-alikes
+		var added struct {
+			IngredientID int                      `json:"ingredient_id"`
+			Similar      []models.IngredientMatch `json:"similar"`
+		}
+		decode(t, call(t, th.recipes.AddRecipeIngredient, "POST", "/api/recipes/1/ingredients",
+			map[string]interface{}{"recipe_id": cake, "ingredient_name": "egg whites", "quantity": 2, "is_tracked": false}, http.StatusOK), &added)
+		if added.IngredientID == eggs || added.IngredientID == 0 {
+			t.Fatalf("egg whites got id %d", added.IngredientID)
+		}
+		if len(added.Similar) != 1 || added.Similar[0].ID != eggs {
+			t.Errorf("similar = %+v", added.Similar)
+		}
+		// An existing name is reused
+		eggWhites := added.IngredientID
+		added.IngredientID, added.Similar = 0, nil
+		decode(t, call(t, th.recipes.AddRecipeIngredient, "POST", "/api/recipes/1/ingredients",
+			map[string]interface{}{"recipe_id": cake, "ingredient_name": "EGGS", "quantity": 4}, http.StatusOK), &added)
+		if added.IngredientID != eggs || added.Similar != nil {
+			t.Errorf("EGGS = %+v", added)
+		}
+
+		var lines []RecipeIngredientDetail
+		target := "/api/recipes/1/ingredients?recipe_id=" + strconv.Itoa(cake)
+		decode(t, call(t, th.recipes.GetRecipeIngredients, "GET", target, nil, http.StatusOK), &lines)
+		if len(lines) != 2 || lines[0].Name != "Eggs" || lines[0].Quantity != 4 || lines[1].IsTracked {
+			t.Fatalf("lines = %+v", lines)
+		}
+		call(t, th.recipes.GetRecipeIngredients, "GET", "/api/recipes/ingredients", nil, http.StatusBadRequest)
+		call(t, th.recipes.GetRecipeIngredients, "GET", "/api/recipes/ingredients?recipe_id=x", nil, http.StatusBadRequest)
+
+		call(t, th.recipes.UpdateIngredientQuantity, "PATCH", "/api/recipes/1/ingredients/1",
+			map[string]interface{}{"recipe_id": cake, "ingredient_id": eggs, "quantity": 5}, http.StatusOK)
+		call(t, th.recipes.UpdateIngredientQuantity, "PATCH", "/api/recipes/1/ingredients/1",
+			map[string]interface{}{"recipe_id": cake, "ingredient_id": eggs, "quantity": -5}, http.StatusBadRequest)
+		call(t, th.recipes.UpdateIngredientQuantity, "PATCH", "/api/recipes/1/ingredients/99",
+			map[string]interface{}{"recipe_id": cake, "ingredient_id": 99, "quantity": 5}, http.StatusNotFound)
+
+		call(t, th.recipes.RemoveRecipeIngredient, "DELETE", "/api/recipes/1/ingredients/1",
+			map[string]interface{}{"recipe_id": cake, "ingredient_id": eggs}, http.StatusOK)
+		decode(t, call(t, th.recipes.GetRecipeIngredients, "GET", target, nil, http.StatusOK), &lines)
+		if len(lines) != 1 || lines[0].IngredientID != eggWhites {
+			t.Errorf("lines after remove = %+v", lines)
+		}
+	})
+}
+
+func TestMealPlan(t *testing.T) {
+	eachStore(t, func(t *testing.T, th testHandlers) {
+		soup := th.addRecipe(t, "Soup", 4)
+
+		w := call(t, th.mealPlans.ScheduleMeal, "POST", "/api/meal-plans",
+			map[string]interface{}{"date": "tomorrow", "meal_type": "Supper", "servings": -1}, http.StatusBadRequest)
+		var apiErr APIError
+		decode(t, w, &apiErr)
+		if len(apiErr.Fields) != 3 {
+			t.Errorf("fields = %v", apiErr.Fields)
+		}
+		call(t, th.mealPlans.ScheduleMeal, "POST", "/api/meal-plans",
+			map[string]interface{}{"date": date(1), "meal_type": "Lunch", "leftovers": true}, http.StatusBadRequest)
+		call(t, th.mealPlans.ScheduleMeal, "POST", "/api/meal-plans",
+			map[string]interface{}{"date": date(1), "meal_type": "Lunch", "recipe_id": 99}, http.StatusUnprocessableEntity)
+
+		dinner := th.schedule(t, map[string]interface{}{"date": date(1), "meal_type": "Dinner", "recipe_id": soup, "guest_count": 2})
+		lunch := th.schedule(t, map[string]interface{}{"date": date(1), "meal_type": "Lunch"})
+
+		var plan []models.MealPlan
+		decode(t, call(t, th.mealPlans.GetMealPlan, "GET", "/api/meal-plans", nil, http.StatusOK), &plan)
+		if len(plan) != 2 || plan[0].ID != dinner || plan[1].ID != lunch {
+			t.Fatalf("plan = %+v", plan)
+		}
+		if plan[0].RecipeName != "Soup" || plan[0].PlannedServings == nil || *plan[0].PlannedServings != 2 {
+			t.Errorf("dinner = %+v", plan[0])
+		}
+
+		call(t, th.mealPlans.DeleteMealPlan, "DELETE", "/api/meal-plans/1", map[string]int{"id": lunch}, http.StatusOK)
+		decode(t, call(t, th.mealPlans.GetMealPlan, "GET", "/api/meal-plans", nil, http.StatusOK), &plan)
+		if len(plan) != 1 {
+			t.Errorf("plan after delete = %+v", plan)
+		}
+	})
+}
+
+// The memory store has no substitutes or sub-recipes; cooking with those is
+// tested on SQLite by TestSQLiteCookRules in package main.
+func TestCookMeal(t *testing.T) {
+	eachStore(t, func(t *testing.T, th testHandlers) {
+		rice := th.addIngredient(t, "Rice", 1000, true)
+		salt := th.addIngredient(t, "Salt", 50, false)
+		// Two servings a batch
+		bowl := th.addRecipe(t, "Rice bowl", 2,
+			models.RecipeIngredient{IngredientID: rice, Quantity: 200}, models.RecipeIngredient{IngredientID: salt, Quantity: 5})
+		noRecipe := th.schedule(t, map[string]interface{}{"date": date(0), "meal_type": "Lunch"})
+		// Three servings take two batches
+		dinner := th.schedule(t, map[string]interface{}{"date": date(0), "meal_type": "Dinner", "recipe_id": bowl, "servings": 3})
+
+		call(t, th.mealPlans.CookMeal, "POST", "/api/meal-plans/99/cook", map[string]int{"id": 99}, http.StatusNotFound)
+		call(t, th.mealPlans.CookMeal, "POST", "/api/meal-plans/1/cook", map[string]int{"id": noRecipe}, http.StatusBadRequest)
+		call(t, th.mealPlans.CookMeal, "POST", "/api/meal-plans/1/cook", map[string]interface{}{"id": dinner, "batches": -1}, http.StatusBadRequest)
+
+		var cooked struct {
+			Status   string               `json:"status"`
+			Leftover *models.PreparedFood `json:"leftover"`
+		}
+		decode(t, call(t, th.mealPlans.CookMeal, "POST", "/api/meal-plans/1/cook",
+			map[string]interface{}{"id": dinner, "leftover_days": 2}, http.StatusOK), &cooked)
+		if s := th.stock(t, rice); s != 600 {
+			t.Errorf("rice = %v, want 600 after two batches", s)
+		}
+		if s := th.stock(t, salt); s != 50 {
+			t.Errorf("untracked salt = %v, want 50", s)
+		}
+		if !th.meal(t, dinner).IsCooked {
+			t.Error("meal not marked cooked")
+		}
+		// Four servings made, three eaten
+		if cooked.Leftover == nil || cooked.Leftover.Servings != 1 || cooked.Leftover.Kind != "leftover" {
+			t.Fatalf("leftover = %+v", cooked.Leftover)
+		}
+		if want := today().AddDate(0, 0, 2); !cooked.Leftover.ExpiryDate.Equal(want) {
+			t.Errorf("leftover expires %v, want %v", cooked.Leftover.ExpiryDate, want)
+		}
+
+		// Cooking twice changes nothing
+		call(t, th.mealPlans.CookMeal, "POST", "/api/meal-plans/1/cook", map[string]int{"id": dinner}, http.StatusConflict)
+		if s := th.stock(t, rice); s != 600 {
+			t.Errorf("rice = %v after cooking twice, want 600", s)
+		}
+	})
+}
+
+func TestCookMealLeftovers(t *testing.T) {
+	eachStore(t, func(t *testing.T, th testHandlers) {
+		rice := th.addIngredient(t, "Rice", 1000, true)
+		bowl := th.addRecipe(t, "Rice bowl", 4, models.RecipeIngredient{IngredientID: rice, Quantity: 100})
+
+		dinner := th.schedule(t, map[string]interface{}{"date": date(0), "meal_type": "Dinner", "recipe_id": bowl})
+		lunch := th.schedule(t, map[string]interface{}{"date": date(1), "meal_type": "Lunch", "recipe_id": bowl, "leftovers": true, "servings": 2})
+		secondLunch := th.schedule(t, map[string]interface{}{"date": date(2), "meal_type": "Lunch", "recipe_id": bowl, "leftovers": true})
+
+		// Eating one of four servings keeps three
+		call(t, th.mealPlans.CookMeal, "POST", "/api/meal-plans/1/cook", map[string]interface{}{"id": dinner, "servings_eaten": 1}, http.StatusOK)
+
+		var used struct {
+			Used float64 `json:"leftover_servings_used"`
+		}
+		decode(t, call(t, th.mealPlans.CookMeal, "POST", "/api/meal-plans/1/cook", map[string]int{"id": lunch}, http.StatusOK), &used)
+		if used.Used != 2 {
+			t.Errorf("used %v leftover servings, want 2", used.Used)
+		}
+		if s := th.stock(t, rice); s != 900 {
+			t.Errorf("rice = %v, eating leftovers must not use stock", s)
+		}
+		decode(t, call(t, th.mealPlans.CookMeal, "POST", "/api/meal-plans/1/cook", map[string]int{"id": secondLunch}, http.StatusOK), &used)
+		if used.Used != 1 {
+			t.Errorf("used %v leftover servings, want the last 1", used.Used)
+		}
+
+		// Nothing is left: the meal fails and stays uncooked
+		third := th.schedule(t, map[string]interface{}{"date": date(3), "meal_type": "Lunch", "recipe_id": bowl, "leftovers": true})
+		call(t, th.mealPlans.CookMeal, "POST", "/api/meal-plans/1/cook", map[string]int{"id": third}, http.StatusConflict)
+		if th.meal(t, third).IsCooked {
+			t.Error("failed cook left the meal marked cooked")
+		}
+	})
+}
+
+// Items covered by a substitute are tested by TestSQLiteCookRules in package main.
+func TestShoppingList(t *testing.T) {
+	eachStore(t, func(t *testing.T, th testHandlers) {
+		milk := th.addIngredient(t, "Milk", 4, true)
+		th.addIngredient(t, "Salt", 0, false)
+		th.addIngredient(t, "Eggs", 1, true)
+		th.addIngredient(t, "Flour", 10, true)
+		pancakes := th.addRecipe(t, "Pancakes", 2, models.RecipeIngredient{IngredientID: milk, Quantity: 2})
+
+		var list []ShoppingItem
+		decode(t, call(t, th.shopping.GetShoppingList, "GET", "/api/shopping-list", nil, http.StatusOK), &list)
+		if len(list) != 1 || list[0].Name != "Eggs" {
+			t.Fatalf("list = %+v", list)
+		}
+		lower := &ShoppingListHandler{Shopping: th.store, LowStock: 1}
+		decode(t, call(t, lower.GetShoppingList, "GET", "/api/shopping-list", nil, http.StatusOK), &list)
+		if len(list) != 0 {
+			t.Fatalf("list below 1 = %+v", list)
+		}
+
+		// Upcoming meals count, past and cooked ones do not
+		th.schedule(t, map[string]interface{}{"date": date(1), "meal_type": "Breakfast", "recipe_id": pancakes})
+		th.schedule(t, map[string]interface{}{"date": date(-1), "meal_type": "Breakfast", "recipe_id": pancakes})
+		decode(t, call(t, th.shopping.GetShoppingList, "GET", "/api/shopping-list?include_covered=true", nil, http.StatusOK), &list)
+		if len(list) != 2 || list[0].Name != "Eggs" || list[1].Name != "Milk" || list[1].PlannedConsumption != 2 {
+			t.Fatalf("list = %+v", list)
+		}
+	})
+}
+
+func TestHealth(t *testing.T) {
+	db, err := database.Open("sqlite:" + filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &HealthHandler{DB: db}
+	call(t, h.Live, "GET", "/healthz", nil, http.StatusOK)
+	call(t, h.Ready, "GET", "/readyz", nil, http.StatusServiceUnavailable)
+
+	h.Serving.Store(true)
+	call(t, h.Ready, "GET", "/readyz", nil, http.StatusOK)
+
+	db.Close()
+	call(t, h.Live, "GET", "/healthz", nil, http.StatusOK)
+	var body map[string]string
+	decode(t, call(t, h.Ready, "GET", "/readyz", nil, http.StatusServiceUnavailable), &body)
+	if body["status"] != "unavailable" || body["reason"] != "database" || len(body) != 2 {
+		t.Errorf("database down = %v", body)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var logged bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logged, nil)))
+
+	h := RequestID(AccessLog(JSONErrors(http.NotFoundHandler())))
+	for _, sent := range []string{"abc-123", "bad id\n", ""} {
+		r := httptest.NewRequest("GET", "/api/nothing", nil)
+		r.Header.Set("X-Request-ID", sent)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		id := w.Header().Get("X-Request-ID")
+		if (sent == "abc-123") != (id == sent) || len(id) == 0 {
+			t.Errorf("sent %q, got X-Request-ID %q", sent, id)
+		}
+		var e APIError
+		decode(t, w, &e)
+		if e.RequestID != id || e.Code != "not_found" {
+			t.Errorf("error body = %+v", e)
+		}
+		if !strings.Contains(logged.String(), "request_id="+id+" method=GET path=/api/nothing status=404") {
+			t.Errorf("access log = %s", logged.String())
+		}
+	}
+}
+
+func TestInstrument(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/things/{id}", func(w http.ResponseWriter, r *http.Request) {
+		jsonError(w, "Thing not found", http.StatusNotFound)
+	})
+	mux.Handle("GET /metrics", metrics.Handler())
+	h := Instrument(mux, mux)
+	for _, target := range []string{"/api/things/1", "/api/things/2", "/elsewhere"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", target, nil))
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		`house_http_requests_total{method="GET",route="/api/things/{id}",status="404"} 2`,
+		`house_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("metrics lack %s", want)
+		}
+	}
+}
+
+func TestLineLink(t *testing.T) {
+	db := newTestDB(t)
+	household := &HouseholdHandler{DB: db}
+	line := &LineNotifyHandler{DB: db}
+	call(t, household.CreateMember, "POST", "/api/members", map[string]interface{}{"name": "Ann"}, http.StatusCreated)
+	call(t, household.CreateMember, "POST", "/api/members", map[string]interface{}{"name": "Ben"}, http.StatusCreated)
+
+	// Names link nobody
+	if reply := line.lineCommand(context.Background(), "U-mallory", "I am Ann"); reply != "" {
+		t.Errorf("I am Ann: %q", reply)
+	}
+	var link struct {
+		Code string `json:"code"`
+	}
+	decode(t, call(t, household.LinkLine, "POST", "/api/members/1/line-link", map[string]int{"id": 1}, http.StatusCreated), &link)
+	if reply := line.lineCommand(context.Background(), "U-mallory", "link ABCDEFGH"); !strings.Contains(reply, "unknown or has expired") {
+		t.Errorf("wrong code: %q", reply)
+	}
+	if reply := line.lineCommand(context.Background(), "U-ann", "link "+strings.ToLower(link.Code)); !strings.Contains(reply, "Hi Ann") {
+		t.Errorf("link: %q", reply)
+	}
+	// Codes are used once, and a linked member cannot get a new one
+	if reply := line.lineCommand(context.Background(), "U-mallory", "link "+link.Code); !strings.Contains(reply, "unknown or has expired") {
+		t.Errorf("code used twice: %q", reply)
+	}
+	call(t, household.LinkLine, "POST", "/api/members/1/line-link", map[string]int{"id": 1}, http.StatusConflict)
+
+	// One LINE account is one member
+	decode(t, call(t, household.LinkLine, "POST", "/api/members/2/line-link", map[string]int{"id": 2}, http.StatusCreated), &link)
+	if reply := line.lineCommand(context.Background(), "U-ann", "link "+link.Code); !strings.Contains(reply, "linked to Ann already") {
+		t.Errorf("second member: %q", reply)
+	}
+	db.Exec("UPDATE line_link_codes SET expires_at = $1", time.Now().Add(-time.Minute))
+	if reply := line.lineCommand(context.Background(), "U-ben", "link "+link.Code); !strings.Contains(reply, "unknown or has expired") {
+		t.Errorf("expired code: %q", reply)
+	}
+
+	var members []models.HouseholdMember
+	decode(t, call(t, household.GetMembers, "GET", "/api/members", nil, http.StatusOK), &members)
+	if !members[0].LineLinked || members[1].LineLinked {
+		t.Errorf("members = %+v", members)
+	}
+	call(t, household.UnlinkLine, "DELETE", "/api/members/1/line-link", map[string]int{"id": 1}, http.StatusOK)
+	call(t, household.LinkLine, "POST", "/api/members/1/line-link", map[string]int{"id": 1}, http.StatusCreated)
+	call(t, household.LinkLine, "POST", "/api/members/9/line-link", map[string]int{"id": 9}, http.StatusNotFound)
+
+	// Database errors are logged, not sent to the chat
+	db.Close()
+	for _, text := range []string{"out for dinner", "link ABCDEFGH", "stock eggs"} {
+		if reply := line.lineCommand(context.Background(), "U-ann", text); reply != "Sorry, something went wrong. Please try again later." {
+			t.Errorf("%s with the database closed: %q", text, reply)
+		}
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestLineWebhook(t *testing.T) {
+	db := newTestDB(t)
+	line := &LineNotifyHandler{DB: db, ChannelSecret: "secret", AccessToken: "token"}
+	household := &HouseholdHandler{DB: db}
+	mealPlans := &MealPlanHandler{DB: db, MealPlans: NewSQLStore(db)}
+	call(t, household.CreateMember, "POST", "/api/members", map[string]interface{}{"name": "Ann"}, http.StatusCreated)
+	call(t, mealPlans.ScheduleMeal, "POST", "/api/meal-plans", map[string]interface{}{"date": date(0), "meal_type": "Dinner"}, http.StatusOK)
+
+	// Replies go to a stub of the LINE API
+	var replies []string
+	defer func(transport http.RoundTripper) { lineClient.Transport = transport }(lineClient.Transport)
+	lineClient.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		var body struct {
+			Messages []struct {
+				Text string `json:"text"`
+			} `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.URL.String() != "https://api.line.me/v2/bot/message/reply" || r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("LINE API called with %s %v", r.URL, r.Header)
+		}
+		replies = append(replies, body.Messages[0].Text)
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Header: http.Header{}}, nil
+	})
+
+	send := func(text, signature string, status int) {
+		t.Helper()
+		body := `{"events": [{"type": "message", "replyToken": "r1", "source": {"userId": "U-ann"},
+			"message": {"type": "text", "text": "` + text + `"}}]}`
+		if signature == "" {
+			mac := hmac.New(sha256.New, []byte("secret"))
+			mac.Write([]byte(body))
+			signature = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+		}
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/api/line/webhook", strings.NewReader(body))
+		r.Header.Set("X-Line-Signature", signature)
+		line.Webhook(w, r)
+		if w.Code != status {
+			t.Fatalf("%q: status %d, want %d: %s", text, w.Code, status, w.Body.String())
+		}
+	}
+
+	// Requests not signed with the channel secret change nothing
+	var link struct {
+		Code string `json:"code"`
+	}
+	decode(t, call(t, household.LinkLine, "POST", "/api/members/1/line-link", map[string]int{"id": 1}, http.StatusCreated), &link)
+	send("link "+link.Code, "bm90IHRoZSBzaWduYXR1cmU=", http.StatusUnauthorized)
+	send("link "+link.Code, "not base64", http.StatusUnauthorized)
+	if len(replies) != 0 {
+		t.Errorf("replies to unsigned requests: %q", replies)
+	}
+
+	send("link "+link.Code, "", http.StatusOK)
+	send("out for dinner", "", http.StatusOK)
+	send("what's for dinner?", "", http.StatusOK) // Not a command, no reply
+	if len(replies) != 2 || !strings.Contains(replies[0], "Hi Ann") || !strings.Contains(replies[1], "you're out for dinner") {
+		t.Errorf("replies = %q", replies)
+	}
+	var attendance struct {
+		Members []models.MealAttendee `json:"members"`
+	}
+	decode(t, call(t, mealPlans.GetAttendance, "GET", "/api/meal-plans/1/attendance?meal_plan_id=1", nil, http.StatusOK), &attendance)
+	if len(attendance.Members) != 1 || attendance.Members[0].Attending {
+		t.Errorf("attendance = %+v", attendance.Members)
+	}
+
+	line.ChannelSecret = ""
+	send("help", "", http.StatusServiceUnavailable)
+}
+
+// Today is the date in the configured time zone, not on the database's clock,
+// which is UTC for SQLite.
+func TestTodayInTimeZone(t *testing.T) {
+	zone := "Etc/GMT+12" // UTC-12 is still yesterday before noon UTC
+	if time.Now().UTC().Hour() >= 12 {
+		zone = "Etc/GMT-14" // UTC+14 is tomorrow already
+	}
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer func(local *time.Location) { time.Local = local }(time.Local)
+	time.Local = loc
+
+	db := newTestDB(t)
+	store := NewSQLStore(db)
+	th := testHandlers{
+		store:     store,
+		inventory: &InventoryHandler{DB: db, Ingredients: store},
+		recipes:   &RecipeHandler{DB: db, Recipes: store, Ingredients: store},
+		mealPlans: &MealPlanHandler{DB: db, MealPlans: store},
+		shopping:  &ShoppingListHandler{Shopping: store},
+	}
+	call(t, th.inventory.AddIngredient, "POST", "/api/ingredients", map[string]interface{}{
+		"name": "Milk", "current_stock": 5, "is_tracked": true, "expiry_date": date(0) + "T00:00:00Z"}, http.StatusCreated)
+	var alerts []models.ExpiryAlert
+	decode(t, call(t, th.inventory.GetExpiring, "GET", "/api/ingredients/expiring?days=0", nil, http.StatusOK), &alerts)
+	if len(alerts) != 1 || alerts[0].DaysLeft != 0 {
+		t.Errorf("expiring today = %+v", alerts)
+	}
+
+	cereal := th.addRecipe(t, "Cereal", 1, models.RecipeIngredient{IngredientID: 1, Quantity: 4})
+	meal := th.schedule(t, map[string]interface{}{"date": date(0), "meal_type": "Breakfast", "recipe_id": cereal})
+	var list []ShoppingItem
+	decode(t, call(t, th.shopping.GetShoppingList, "GET", "/api/shopping-list", nil, http.StatusOK), &list)
+	if len(list) != 1 || list[0].PlannedConsumption != 4 {
+		t.Errorf("shopping list with today's meal = %+v", list)
+	}
+
+	var cooked CookResult
+	decode(t, call(t, th.mealPlans.CookMeal, "POST", "/api/meal-plans/cook",
+		map[string]interface{}{"id": meal, "servings_eaten": 0}, http.StatusOK), &cooked)
+	if cooked.Leftover == nil || !cooked.Leftover.PreparedOn.Equal(today()) {
+		t.Errorf("leftover = %+v", cooked.Leftover)
+	}
+}
+
+func TestScheduleDietConflict(t *testing.T) {
+	db := newTestDB(t)
+	mealPlans := &MealPlanHandler{DB: db, MealPlans: NewSQLStore(db)}
+	for _, stmt := range []string{
+		"INSERT INTO household_members (name) VALUES ('Aki')",
+		"INSERT INTO member_restrictions (member_id, restriction, strict) VALUES (1, 'peanut', TRUE)",
+		"INSERT INTO ingredients (name) VALUES ('Peanut butter')",
+		"INSERT INTO ingredient_flags (ingredient_id, flag) VALUES (1, 'peanut')",
+		"INSERT INTO recipes (name) VALUES ('Satay')",
+		"INSERT INTO recipe_ingredients (recipe_id, ingredient_id, quantity) VALUES (1, 1, 50)",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	meal := map[string]interface{}{"date": date(1), "meal_type": "Dinner", "recipe_id": 1}
+	var conflict struct {
+		Code    string                `json:"code"`
+		Details []models.DietConflict `json:"details"`
+	}
+	decode(t, call(t, mealPlans.ScheduleMeal, "POST", "/api/meal-plans", meal, http.StatusConflict), &conflict)
+	if conflict.Code != "diet_conflict" || len(conflict.Details) != 1 ||
+		conflict.Details[0].MemberName != "Aki" || !conflict.Details[0].Blocking {
+		t.Errorf("conflict = %+v", conflict)
+	}
+
+	meal["force"] = true
+	var created struct {
+		ID       int                   `json:"id"`
+		Warnings []models.DietConflict `json:"warnings"`
+	}
+	decode(t, call(t, mealPlans.ScheduleMeal, "POST", "/api/meal-plans", meal, http.StatusOK), &created)
+	if created.ID == 0 || len(created.Warnings) != 1 {
+		t.Errorf("forced = %+v", created)
+	}
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// HealthHandler answers the liveness and readiness probes of Docker, systemd
+// or a load balancer.
+type HealthHandler struct {
+	DB      *sql.DB
+	Serving atomic.Bool // Set once the schema is applied, cleared when shutting down
+}
+
+// Live answers 200 while the process can serve requests at all.
+func (h *HealthHandler) Live(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// Ready answers 200 when requests can be served: the schema is applied and the
+// database answers. Otherwise it is a 503 saying which of the two is missing.
+func (h *HealthHandler) Ready(w http.ResponseWriter, r *http.Request) {
+	if !h.Serving.Load() {
+		jsonError(w, "Not ready: starting or shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+	if err := h.DB.PingContext(ctx); err != nil {
+		// Probes may be public, so the cause is only logged
+		Logger(ctx).ErrorContext(ctx, "Readiness check failed", "error", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"status": "unavailable", "reason": "database"})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
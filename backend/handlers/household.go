@@ -0,0 +1,538 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kano-Chien/house_management/backend/models"
+	"github.com/lib/pq"
+)
+
+type HouseholdHandler struct {
+	DB *sql.DB
+}
+
+// ingredientFlags are the allergens and diet-relevant contents an ingredient can be flagged with.
+var ingredientFlags = map[string]bool{
+	"peanut": true, "tree_nut": true, "dairy": true, "egg": true, "gluten": true, "soy": true,
+	"fish": true, "shellfish": true, "sesame": true,
+	"meat": true, "pork": true, "beef": true, "alcohol": true, "honey": true,
+}
+
+// diets lists the ingredient flags each diet rules out.
+var diets = map[string][]string{
+	"vegetarian":   {"meat", "pork", "beef", "fish", "shellfish"},
+	"vegan":        {"meat", "pork", "beef", "fish", "shellfish", "dairy", "egg", "honey"},
+	"pescatarian":  {"meat", "pork", "beef"},
+	"halal":        {"pork", "alcohol"},
+	"no_pork":      {"pork"},
+	"no_beef":      {"beef"},
+	"gluten_free":  {"gluten"},
+	"dairy_free":   {"dairy"},
+	"alcohol_free": {"alcohol"},
+}
+
+// validRestriction normalizes a restriction name and reports whether it is a known flag or diet.
+func validRestriction(name string) (string, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	return name, isDiet(name) || ingredientFlags[name]
+}
+
+// loadRecipeFlags returns, per recipe and flag, the names of the ingredients carrying
+// that flag, components included. recipeID 0 loads every recipe.
+func loadRecipeFlags(q queryer, recipeID int) (map[int]map[string][]string, error) {
+	rows, err := q.Query(`
+		SELECT e.recipe_id, f.flag, i.name
+		FROM recipe_ingredients_expanded e
+		JOIN ingredient_flags f ON f.ingredient_id = e.ingredient_id
+		JOIN ingredients i ON e.ingredient_id = i.id
+		WHERE $1 = 0 OR e.recipe_id = $1
+		ORDER BY i.name
+	`, recipeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	flags := map[int]map[string][]string{}
+	for rows.Next() {
+		var id int
+		var flag, name string
+		if err := rows.Scan(&id, &flag, &name); err != nil {
+			return nil, err
+		}
+		if flags[id] == nil {
+			flags[id] = map[string][]string{}
+		}
+		flags[id][flag] = append(flags[id][flag], name)
+	}
+	return flags, rows.Err()
+}
+
+// loadMembers returns household members with their restrictions. A nil memberIDs
+// loads everyone except guests, who are only included when listed.
+func loadMembers(q queryer, memberIDs []int) ([]models.HouseholdMember, error) {
+	if memberIDs == nil {
+		return queryMembers(q, "WHERE NOT COALESCE(m.is_guest, FALSE)")
+	}
+	return queryMembers(q, "WHERE m.id = ANY($1)", pq.Array(memberIDs))
+}
+
+func queryMembers(q queryer, where string, args ...interface{}) ([]models.HouseholdMember, error) {
+	rows, err := q.Query(`
+		SELECT m.id, m.name, COALESCE(m.is_guest, FALSE), m.line_user_id IS NOT NULL, r.restriction, COALESCE(r.strict, TRUE)
+		FROM household_members m
+		LEFT JOIN member_restrictions r ON r.member_id = m.id
+		`+where+`
+		ORDER BY m.name, r.restriction
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []models.HouseholdMember
+	for rows.Next() {
+		var m models.HouseholdMember
+		var restriction sql.NullString
+		var strict bool
+		if err := rows.Scan(&m.ID, &m.Name, &m.IsGuest, &m.LineLinked, &restriction, &strict); err != nil {
+			return nil, err
+		}
+		if n := len(members); n == 0 || members[n-1].ID != m.ID {
+			m.Restrictions = []models.Restriction{}
+			members = append(members, m)
+		}
+		if restriction.Valid {
+			last := &members[len(members)-1]
+			last.Restrictions = append(last.Restrictions, models.Restriction{Name: restriction.String, Strict: &strict})
+		}
+	}
+	return members, rows.Err()
+}
+
+// dietConflicts checks the flags of one recipe against the members' restrictions.
+func dietConflicts(flags map[string][]string, members []models.HouseholdMember) []models.DietConflict {
+	var conflicts []models.DietConflict
+	for _, m := range members {
+		for _, r := range m.Restrictions {
+			forbidden, ok := diets[r.Name]
+			if !ok {
+				forbidden = []string{r.Name}
+			}
+			seen := map[string]bool{}
+			var names []string
+			for _, flag := range forbidden {
+				for _, name := range flags[flag] {
+					if !seen[name] {
+						seen[name] = true
+						names = append(names, name)
+					}
+				}
+			}
+			if len(names) > 0 {
+				sort.Strings(names)
+				conflicts = append(conflicts, models.DietConflict{
+					MemberID: m.ID, MemberName: m.Name, Restriction: r.Name,
+					Ingredients: names, Blocking: r.Strict != nil && *r.Strict,
+				})
+			}
+		}
+	}
+	return conflicts
+}
+
+// checkRecipeForMembers returns the conflicts between a recipe and the people eating it.
+func checkRecipeForMembers(q queryer, recipeID int, memberIDs []int) ([]models.DietConflict, error) {
+	members, err := loadMembers(q, memberIDs)
+	if err != nil {
+		return nil, err
+	}
+	flags, err := loadRecipeFlags(q, recipeID)
+	if err != nil {
+		return nil, err
+	}
+	return dietConflicts(flags[recipeID], members), nil
+}
+
+// memberIDsParam reads ?member_ids=1,2 for the people eating; nil means the household.
+func memberIDsParam(r *http.Request) ([]int, error) {
+	var ids []int
+	for _, v := range queryList(r, "member_ids") {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid member_ids")
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// attachConflicts sets the diet conflicts of each recipe for the given people.
+func attachConflicts(q queryer, recipes []models.Recipe, memberIDs []int) error {
+	members, err := loadMembers(q, memberIDs)
+	if err != nil {
+		return err
+	}
+	flags, err := loadRecipeFlags(q, 0)
+	if err != nil {
+		return err
+	}
+	for i := range recipes {
+		recipes[i].Conflicts = dietConflicts(flags[recipes[i].ID], members)
+	}
+	return nil
+}
+
+// loadIngredientFlags returns the flags of ingredients, keyed by ingredient ID.
+// ingredientID 0 loads every ingredient.
+func loadIngredientFlags(q queryer, ingredientID int) (map[int][]string, error) {
+	rows, err := q.Query("SELECT ingredient_id, flag FROM ingredient_flags WHERE $1 = 0 OR ingredient_id = $1 ORDER BY flag", ingredientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	flags := map[int][]string{}
+	for rows.Next() {
+		var id int
+		var flag string
+		if err := rows.Scan(&id, &flag); err != nil {
+			return nil, err
+		}
+		flags[id] = append(flags[id], flag)
+	}
+	return flags, rows.Err()
+}
+
+// GetDietaryOptions lists the known ingredient flags and diets with what they rule out.
+func (h *HouseholdHandler) GetDietaryOptions(w http.ResponseWriter, r *http.Request) {
+	flags := make([]string, 0, len(ingredientFlags))
+	for f := range ingredientFlags {
+		flags = append(flags, f)
+	}
+	sort.Strings(flags)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"flags": flags, "diets": diets})
+}
+
+func (h *HouseholdHandler) GetMembers(w http.ResponseWriter, r *http.Request) {
+	members, err := queryMembers(h.DB, "")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if members == nil {
+		members = []models.HouseholdMember{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
+
+// saveRestrictions replaces the restrictions of a member.
+func saveRestrictions(tx *sql.Tx, memberID int, restrictions []models.Restriction) error {
+	if _, err := tx.Exec("DELETE FROM member_restrictions WHERE member_id = $1", memberID); err != nil {
+		return err
+	}
+	for _, r := range restrictions {
+		strict := !isDiet(r.Name)
+		if r.Strict != nil {
+			strict = *r.Strict
+		}
+		_, err := tx.Exec(
+			"INSERT INTO member_restrictions (member_id, restriction, strict) VALUES ($1, $2, $3) ON CONFLICT (member_id, restriction) DO UPDATE SET strict = EXCLUDED.strict",
+			memberID, r.Name, strict,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isDiet(name string) bool {
+	_, ok := diets[name]
+	return ok
+}
+
+// decodeMember reads a member and validates its restrictions.
+func decodeMember(r *http.Request) (models.HouseholdMember, error) {
+	var m models.HouseholdMember
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		return m, newAPIError(http.StatusBadRequest, err.Error())
+	}
+	var v validator
+	m.Name = strings.TrimSpace(m.Name)
+	v.check(m.Name != "", "name", "is required")
+	v.check(len(m.Name) <= 100, "name", "must be at most 100 characters")
+	for i := range m.Restrictions {
+		name, ok := validRestriction(m.Restrictions[i].Name)
+		v.check(ok, "restrictions", fmt.Sprintf("unknown restriction %q", m.Restrictions[i].Name))
+		m.Restrictions[i].Name = name
+	}
+	return m, v.err()
+}
+
+func (h *HouseholdHandler) CreateMember(w http.ResponseWriter, r *http.Request) {
+	m, err := decodeMember(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRow("INSERT INTO household_members (name, is_guest) VALUES ($1, $2) RETURNING id", m.Name, m.IsGuest).Scan(&m.ID); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := saveRestrictions(tx, m.ID, m.Restrictions); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]int{"id": m.ID})
+}
+
+// UpdateMember replaces a member's name, guest status and restrictions.
+func (h *HouseholdHandler) UpdateMember(w http.ResponseWriter, r *http.Request) {
+	m, err := decodeMember(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE household_members SET name = $1, is_guest = $2 WHERE id = $3", m.Name, m.IsGuest, m.ID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		jsonError(w, "Member not found", http.StatusNotFound)
+		return
+	}
+	if err := saveRestrictions(tx, m.ID, m.Restrictions); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "updated"})
+}
+
+func (h *HouseholdHandler) DeleteMember(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err := h.DB.Exec("DELETE FROM household_members WHERE id = $1", req.ID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+}
+
+// lineLinkTTL is how long a LINE link code can be used.
+const lineLinkTTL = 15 * time.Minute
+
+// lineCodeChars leaves out characters that are easy to mistake for others (0/O, 1/I).
+const lineCodeChars = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// LinkLine hands out a one-time code the member sends to the LINE bot as
+// "link <code>" to link their LINE account. A member who is linked already has
+// to be unlinked first.
+func (h *HouseholdHandler) LinkLine(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		writeError(w, err)
+		return
+	}
+	for i := range b {
+		b[i] = lineCodeChars[int(b[i])%len(lineCodeChars)]
+	}
+	code, expires := string(b), time.Now().Add(lineLinkTTL)
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	var linked sql.NullString
+	err = tx.QueryRow("SELECT line_user_id FROM household_members WHERE id = $1 FOR UPDATE", req.ID).Scan(&linked)
+	if err == sql.ErrNoRows {
+		jsonError(w, "Member not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(w, err)
+		return
+	}
+	if linked.Valid {
+		writeError(w, &APIError{Status: http.StatusConflict, Code: "already_linked", Message: "Member is linked to a LINE account already, unlink it first"})
+		return
+	}
+	// A new code replaces the member's previous one
+	_, err = tx.Exec(`
+		INSERT INTO line_link_codes (code, member_id, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (member_id) DO UPDATE SET code = EXCLUDED.code, expires_at = EXCLUDED.expires_at`,
+		code, req.ID, expires,
+	)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"code": code, "expires_at": expires})
+}
+
+// UnlinkLine removes the link to a member's LINE account and any unused code.
+func (h *HouseholdHandler) UnlinkLine(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE household_members SET line_user_id = NULL WHERE id = $1", req.ID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		jsonError(w, "Member not found", http.StatusNotFound)
+		return
+	}
+	if _, err := tx.Exec("DELETE FROM line_link_codes WHERE member_id = $1", req.ID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "unlinked"})
+}
+
+// SetIngredientFlags replaces the allergen and diet flags of an ingredient.
+func (h *InventoryHandler) SetIngredientFlags(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		IngredientID int      `json:"ingredient_id"`
+		Flags        []string `json:"flags"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for i, f := range req.Flags {
+		req.Flags[i] = strings.ToLower(strings.TrimSpace(f))
+		if !ingredientFlags[req.Flags[i]] {
+			jsonError(w, fmt.Sprintf("unknown flag %q", f), http.StatusBadRequest)
+			return
+		}
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM ingredients WHERE id = $1)", req.IngredientID).Scan(&exists); err != nil {
+		writeError(w, err)
+		return
+	}
+	if !exists {
+		jsonError(w, "Ingredient not found", http.StatusNotFound)
+		return
+	}
+
+	if _, err := tx.Exec("DELETE FROM ingredient_flags WHERE ingredient_id = $1", req.IngredientID); err != nil {
+		writeError(w, err)
+		return
+	}
+	for _, f := range req.Flags {
+		_, err := tx.Exec("INSERT INTO ingredient_flags (ingredient_id, flag) VALUES ($1, $2) ON CONFLICT DO NOTHING", req.IngredientID, f)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "updated"})
+}
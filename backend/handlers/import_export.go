@@ -0,0 +1,815 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bulk import/export of ingredients, recipes and meal plans as CSV or JSON.
+// Exports use names instead of IDs so a file can be imported into another database.
+
+type ImportRowResult struct {
+	Row    int    `json:"row"` // 1-based position in the file, header excluded
+	Name   string `json:"name"`
+	Action string `json:"action"` // create, update, skip, error
+	ID     int    `json:"id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type ImportReport struct {
+	DryRun  bool              `json:"dry_run"`
+	Created int               `json:"created"`
+	Updated int               `json:"updated"`
+	Skipped int               `json:"skipped"`
+	Failed  int               `json:"failed"`
+	Rows    []ImportRowResult `json:"rows"`
+}
+
+func (rep *ImportReport) fail(row int, name string, err error) {
+	rep.Failed++
+	msg := err.Error()
+	// Validation failures and constraint violations are described like the API does
+	e, ok := err.(*APIError)
+	if !ok {
+		e = dbAPIError(err)
+	}
+	if e != nil {
+		msg = e.Message
+		fields := make([]string, 0, len(e.Fields))
+		for field := range e.Fields {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			msg += fmt.Sprintf(" (%s %s)", field, e.Fields[field])
+		}
+	}
+	rep.Rows = append(rep.Rows, ImportRowResult{Row: row, Name: name, Action: "error", Error: msg})
+}
+
+// importRow runs one row inside a savepoint so a failing row does not abort the
+// rest of the import. Only errors that leave the transaction unusable are returned.
+func (rep *ImportReport) importRow(tx *sql.Tx, row int, name string, fn func() (action string, id int, err error)) error {
+	if _, err := tx.Exec("SAVEPOINT import_row"); err != nil {
+		return err
+	}
+
+	action, id, err := fn()
+	if err != nil {
+		if _, err := tx.Exec("ROLLBACK TO SAVEPOINT import_row"); err != nil {
+			return err
+		}
+		rep.fail(row, name, err)
+		return nil
+	}
+	if _, err := tx.Exec("RELEASE SAVEPOINT import_row"); err != nil {
+		return err
+	}
+
+	switch action {
+	case "create":
+		rep.Created++
+	case "update":
+		rep.Updated++
+	case "skip":
+		rep.Skipped++
+	}
+	rep.Rows = append(rep.Rows, ImportRowResult{Row: row, Name: name, Action: action, ID: id})
+	return nil
+}
+
+// finishImport commits the import unless it is a dry run and writes the report.
+func finishImport(w http.ResponseWriter, tx *sql.Tx, rep *ImportReport) {
+	if !rep.DryRun {
+		if err := tx.Commit(); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rep)
+}
+
+// readImport returns the uploaded document and its format. The document is either
+// the raw request body or a multipart "file" field; the format comes from ?format=,
+// the file extension or the Content-Type, defaulting to JSON.
+func readImport(r *http.Request) ([]byte, string, error) {
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	contentType := r.Header.Get("Content-Type")
+
+	var data []byte
+	if strings.HasPrefix(contentType, "multipart/form-data") {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			return nil, "", err
+		}
+		defer file.Close()
+		if data, err = io.ReadAll(file); err != nil {
+			return nil, "", err
+		}
+		if format == "" && strings.HasSuffix(strings.ToLower(header.Filename), ".csv") {
+			format = "csv"
+		}
+	} else {
+		var err error
+		if data, err = io.ReadAll(r.Body); err != nil {
+			return nil, "", err
+		}
+		if format == "" && strings.Contains(contentType, "csv") {
+			format = "csv"
+		}
+	}
+
+	if format == "" {
+		format = "json"
+	}
+	if format != "csv" && format != "json" {
+		return nil, "", fmt.Errorf("unsupported format %q, use csv or json", format)
+	}
+	return data, format, nil
+}
+
+// readCSV parses a CSV document with a header row into one map per row,
+// keyed by the lower-cased column name.
+func readCSV(data []byte) ([]map[string]string, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("CSV file is empty")
+	}
+
+	header := records[0]
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff")))
+	}
+
+	var rows []map[string]string
+	for _, record := range records[1:] {
+		row := make(map[string]string, len(header))
+		for i, col := range header {
+			if i < len(record) {
+				row[col] = strings.TrimSpace(record[i])
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// writeExport writes rows as a CSV attachment or the records as a JSON attachment.
+func writeExport(w http.ResponseWriter, r *http.Request, name string, header []string, rows [][]string, records interface{}) {
+	stamp := time.Now().Format("20060102")
+	if strings.ToLower(r.URL.Query().Get("format")) == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.csv"`, name, stamp))
+		cw := csv.NewWriter(w)
+		cw.Write(header)
+		cw.WriteAll(rows)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.json"`, name, stamp))
+	json.NewEncoder(w).Encode(records)
+}
+
+func optionalFloat(s string) (*float64, error) {
+	if s == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number %q", s)
+	}
+	return &f, nil
+}
+
+func optionalBool(s string) (*bool, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(strings.ToLower(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid boolean %q", s)
+	}
+	return &b, nil
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func formatDate(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+// ---- Ingredients ----
+
+// ingredientRecord is one ingredient in an import or export file.
+// Missing fields keep their current value when an existing ingredient is updated.
+type ingredientRecord struct {
+	Name         string   `json:"name"`
+	CurrentStock *float64 `json:"current_stock,omitempty"`
+	Unit         *string  `json:"unit,omitempty"`
+	ExpiryDate   *string  `json:"expiry_date,omitempty"` // YYYY-MM-DD
+	Price        *float64 `json:"price,omitempty"`
+	Category     *string  `json:"category,omitempty"`
+	IsTracked    *bool    `json:"is_tracked,omitempty"`
+	Location     *string  `json:"location,omitempty"` // Location name
+}
+
+var ingredientColumns = []string{"name", "current_stock", "unit", "expiry_date", "price", "category", "is_tracked", "location"}
+
+func (h *InventoryHandler) ExportIngredients(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.Query(`
+		SELECT i.name, i.current_stock, COALESCE(i.unit, ''), i.expiry_date, COALESCE(i.price, 0),
+			COALESCE(i.category, 'food'), i.is_tracked, COALESCE(l.name, '')
+		FROM ingredients i
+		LEFT JOIN locations l ON i.location_id = l.id
+		ORDER BY i.name
+	`)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	records := []ingredientRecord{}
+	var csvRows [][]string
+	for rows.Next() {
+		var name, unit, category, location string
+		var stock, price float64
+		var expiry *time.Time
+		var isTracked bool
+		if err := rows.Scan(&name, &stock, &unit, &expiry, &price, &category, &isTracked, &location); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		expiryStr := formatDate(expiry)
+		records = append(records, ingredientRecord{
+			Name: name, CurrentStock: &stock, Unit: &unit, ExpiryDate: optionalString(expiryStr),
+			Price: &price, Category: &category, IsTracked: &isTracked, Location: optionalString(location),
+		})
+		csvRows = append(csvRows, []string{
+			name, formatFloat(stock), unit, expiryStr, formatFloat(price), category, strconv.FormatBool(isTracked), location,
+		})
+	}
+
+	writeExport(w, r, "ingredients", ingredientColumns, csvRows, records)
+}
+
+func (h *InventoryHandler) ImportIngredients(w http.ResponseWriter, r *http.Request) {
+	data, format, err := readImport(r)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rep := &ImportReport{DryRun: r.URL.Query().Get("dry_run") == "true"}
+	var records []ingredientRecord
+	parseErrs := map[int]error{}
+	if format == "json" {
+		if err := json.Unmarshal(data, &records); err != nil {
+			jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		rows, err := readCSV(data)
+		if err != nil {
+			jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for n, row := range rows {
+			rec, err := parseIngredientRow(row)
+			if err != nil {
+				parseErrs[n] = err
+			}
+			records = append(records, rec)
+		}
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	for n, rec := range records {
+		if parseErrs[n] != nil {
+			rep.fail(n+1, rec.Name, parseErrs[n])
+			continue
+		}
+		rec := rec
+		err := rep.importRow(tx, n+1, rec.Name, func() (string, int, error) {
+			return importIngredient(tx, rec)
+		})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	finishImport(w, tx, rep)
+}
+
+func parseIngredientRow(row map[string]string) (ingredientRecord, error) {
+	rec := ingredientRecord{
+		Name:       row["name"],
+		Unit:       optionalString(row["unit"]),
+		ExpiryDate: optionalString(row["expiry_date"]),
+		Category:   optionalString(row["category"]),
+		Location:   optionalString(row["location"]),
+	}
+	var err error
+	if rec.CurrentStock, err = optionalFloat(row["current_stock"]); err != nil {
+		return rec, fmt.Errorf("current_stock: %v", err)
+	}
+	if rec.Price, err = optionalFloat(row["price"]); err != nil {
+		return rec, fmt.Errorf("price: %v", err)
+	}
+	if rec.IsTracked, err = optionalBool(row["is_tracked"]); err != nil {
+		return rec, fmt.Errorf("is_tracked: %v", err)
+	}
+	return rec, nil
+}
+
+// importIngredient creates the ingredient or updates the existing one with the same name.
+func importIngredient(tx *sql.Tx, rec ingredientRecord) (string, int, error) {
+	// Missing values take the defaults on create and keep the current ones on update
+	stock, price, category, isTracked := 0.0, 0.0, "food", true
+	if rec.CurrentStock != nil {
+		stock = *rec.CurrentStock
+	}
+	if rec.Price != nil {
+		price = *rec.Price
+	}
+	if rec.Category != nil {
+		category = *rec.Category
+	}
+	if rec.IsTracked != nil {
+		isTracked = *rec.IsTracked
+	}
+	var v validator
+	validateIngredient(&v, rec.Name, stock, price, category)
+	if err := v.err(); err != nil {
+		return "", 0, err
+	}
+
+	var expiry *time.Time
+	if rec.ExpiryDate != nil {
+		t, err := time.Parse("2006-01-02", *rec.ExpiryDate)
+		if err != nil {
+			return "", 0, fmt.Errorf("invalid expiry_date %q, use YYYY-MM-DD", *rec.ExpiryDate)
+		}
+		expiry = &t
+	}
+
+	var locationID *int
+	if rec.Location != nil {
+		var id int
+		err := tx.QueryRow("SELECT id FROM locations WHERE LOWER(name) = LOWER($1)", *rec.Location).Scan(&id)
+		if err == sql.ErrNoRows {
+			return "", 0, fmt.Errorf("unknown location %q", *rec.Location)
+		} else if err != nil {
+			return "", 0, err
+		}
+		locationID = &id
+	}
+
+	var oldStock float64
+	id, err := findIngredientByName(tx, rec.Name)
+	if err == nil {
+		err = tx.QueryRow("SELECT current_stock FROM ingredients WHERE id = $1", id).Scan(&oldStock)
+	}
+	if err == sql.ErrNoRows {
+		err = tx.QueryRow(
+			"INSERT INTO ingredients (name, current_stock, unit, expiry_date, price, category, is_tracked, location_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id",
+			rec.Name, stock, rec.Unit, expiry, price, category, isTracked, locationID,
+		).Scan(&id)
+		if err != nil {
+			return "", 0, err
+		}
+		return "create", id, nil
+	} else if err != nil {
+		return "", 0, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE ingredients SET
+			current_stock = COALESCE($1, current_stock),
+			unit = COALESCE($2, unit),
+			expiry_date = COALESCE($3, expiry_date),
+			price = COALESCE($4, price),
+			category = COALESCE($5, category),
+			is_tracked = COALESCE($6, is_tracked),
+			location_id = COALESCE($7, location_id)
+		WHERE id = $8`,
+		rec.CurrentStock, rec.Unit, expiry, rec.Price, rec.Category, rec.IsTracked, locationID, id,
+	)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if rec.CurrentStock != nil && *rec.CurrentStock != oldStock {
+		_, err = tx.Exec(
+			"INSERT INTO inventory_history (ingredient_id, action, quantity_change, note) VALUES ($1, 'import', $2, 'bulk import')",
+			id, *rec.CurrentStock-oldStock,
+		)
+		if err != nil {
+			return "", 0, err
+		}
+	}
+	return "update", id, nil
+}
+
+// ---- Recipes ----
+
+type recipeRecord struct {
+	Name         string                   `json:"name"`
+	Instructions string                   `json:"instructions"`
+	Notes        string                   `json:"notes"`
+	Ingredients  []recipeIngredientRecord `json:"ingredients"`
+}
+
+type recipeIngredientRecord struct {
+	Name     string  `json:"name"`
+	Quantity float64 `json:"quantity"`
+	Unit     string  `json:"unit,omitempty"` // Only used when the ingredient has to be created
+}
+
+// Recipe CSV files have one row per recipe ingredient; recipe columns are read from
+// the first row of each recipe. A recipe without ingredients has empty ingredient columns.
+var recipeColumns = []string{"recipe", "instructions", "notes", "ingredient", "quantity", "unit"}
+
+func (h *RecipeHandler) ExportRecipes(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.Query(`
+		SELECT r.id, r.name, COALESCE(r.instructions, ''), COALESCE(r.notes, ''),
+			i.name, ri.quantity, COALESCE(i.unit, '')
+		FROM recipes r
+		LEFT JOIN recipe_ingredients ri ON ri.recipe_id = r.id
+		LEFT JOIN ingredients i ON ri.ingredient_id = i.id
+		ORDER BY r.name, r.id, i.name
+	`)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	records := []recipeRecord{}
+	var csvRows [][]string
+	lastID := 0
+	for rows.Next() {
+		var id int
+		var name, instructions, notes string
+		var ingName, unit sql.NullString
+		var quantity sql.NullFloat64
+		if err := rows.Scan(&id, &name, &instructions, &notes, &ingName, &quantity, &unit); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		if id != lastID {
+			records = append(records, recipeRecord{Name: name, Instructions: instructions, Notes: notes, Ingredients: []recipeIngredientRecord{}})
+			lastID = id
+		}
+		row := []string{name, instructions, notes, "", "", ""}
+		if ingName.Valid {
+			rec := &records[len(records)-1]
+			rec.Ingredients = append(rec.Ingredients, recipeIngredientRecord{Name: ingName.String, Quantity: quantity.Float64, Unit: unit.String})
+			row[3], row[4], row[5] = ingName.String, formatFloat(quantity.Float64), unit.String
+		}
+		csvRows = append(csvRows, row)
+	}
+
+	writeExport(w, r, "recipes", recipeColumns, csvRows, records)
+}
+
+func (h *RecipeHandler) ImportRecipes(w http.ResponseWriter, r *http.Request) {
+	data, format, err := readImport(r)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rep := &ImportReport{DryRun: r.URL.Query().Get("dry_run") == "true"}
+	var records []recipeRecord
+	parseErrs := map[int]error{}
+	if format == "json" {
+		if err := json.Unmarshal(data, &records); err != nil {
+			jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		rows, err := readCSV(data)
+		if err != nil {
+			jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		records, parseErrs = groupRecipeRows(rows)
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	for n, rec := range records {
+		if parseErrs[n] != nil {
+			rep.fail(n+1, rec.Name, parseErrs[n])
+			continue
+		}
+		if rec.Name == "" {
+			rep.fail(n+1, "", fmt.Errorf("name is required"))
+			continue
+		}
+		rec := rec
+		err := rep.importRow(tx, n+1, rec.Name, func() (string, int, error) {
+			return importRecipe(tx, rec)
+		})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	finishImport(w, tx, rep)
+}
+
+// groupRecipeRows folds per-ingredient CSV rows into recipes, in order of first appearance.
+func groupRecipeRows(rows []map[string]string) ([]recipeRecord, map[int]error) {
+	var records []recipeRecord
+	errs := map[int]error{}
+	index := map[string]int{}
+	for _, row := range rows {
+		key := strings.ToLower(row["recipe"])
+		n, ok := index[key]
+		if !ok {
+			n = len(records)
+			index[key] = n
+			records = append(records, recipeRecord{Name: row["recipe"], Instructions: row["instructions"], Notes: row["notes"]})
+		}
+		if row["ingredient"] == "" {
+			continue
+		}
+		qty, err := strconv.ParseFloat(row["quantity"], 64)
+		if err != nil && errs[n] == nil {
+			errs[n] = fmt.Errorf("ingredient %q: invalid quantity %q", row["ingredient"], row["quantity"])
+		}
+		records[n].Ingredients = append(records[n].Ingredients, recipeIngredientRecord{Name: row["ingredient"], Quantity: qty, Unit: row["unit"]})
+	}
+	return records, errs
+}
+
+// importRecipe creates the recipe or updates the existing one with the same name.
+// Listed ingredients are added or have their quantity replaced; others are kept.
+func importRecipe(tx *sql.Tx, rec recipeRecord) (string, int, error) {
+	action := "update"
+	var id int
+	err := tx.QueryRow("SELECT id FROM recipes WHERE LOWER(name) = LOWER($1) ORDER BY id LIMIT 1", rec.Name).Scan(&id)
+	if err == sql.ErrNoRows {
+		action = "create"
+		err = tx.QueryRow("INSERT INTO recipes (name, instructions, notes) VALUES ($1, $2, $3) RETURNING id", rec.Name, rec.Instructions, rec.Notes).Scan(&id)
+		if err != nil {
+			return "", 0, err
+		}
+	} else if err != nil {
+		return "", 0, err
+	} else {
+		_, err = tx.Exec(
+			"UPDATE recipes SET instructions = COALESCE(NULLIF($1, ''), instructions), notes = COALESCE(NULLIF($2, ''), notes) WHERE id = $3",
+			rec.Instructions, rec.Notes, id,
+		)
+		if err != nil {
+			return "", 0, err
+		}
+	}
+
+	for _, ing := range rec.Ingredients {
+		if ing.Name == "" {
+			return "", 0, fmt.Errorf("ingredient name is required")
+		}
+		if ing.Quantity <= 0 {
+			return "", 0, fmt.Errorf("ingredient %q: quantity must be positive", ing.Name)
+		}
+		ingredientID, _, err := findOrCreateIngredient(tx, ing.Name, ing.Unit, true)
+		if err != nil {
+			return "", 0, err
+		}
+		_, err = tx.Exec(
+			`INSERT INTO recipe_ingredients (recipe_id, ingredient_id, quantity)
+			 VALUES ($1, $2, $3)
+			 ON CONFLICT (recipe_id, ingredient_id)
+			 DO UPDATE SET quantity = EXCLUDED.quantity`,
+			id, ingredientID, ing.Quantity,
+		)
+		if err != nil {
+			return "", 0, err
+		}
+	}
+	return action, id, nil
+}
+
+// ---- Meal plans ----
+
+type mealPlanRecord struct {
+	Date        string   `json:"date"` // YYYY-MM-DD
+	MealType    string   `json:"meal_type"`
+	Recipe      string   `json:"recipe"` // Recipe name, empty for an unassigned slot
+	IsCooked    bool     `json:"is_cooked"`
+	Servings    *float64 `json:"servings"` // Null eats the whole batch
+	IsLeftovers bool     `json:"is_leftovers"`
+	GuestCount  int      `json:"guest_count"`
+}
+
+var mealPlanColumns = []string{"date", "meal_type", "recipe", "is_cooked", "servings", "is_leftovers", "guest_count"}
+
+func (h *MealPlanHandler) ExportMealPlan(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.Query(`
+		SELECT mp.date, mp.meal_type, COALESCE(r.name, ''), COALESCE(mp.is_cooked, FALSE),
+			mp.servings, COALESCE(mp.is_leftovers, FALSE), COALESCE(mp.guest_count, 0)
+		FROM meal_plan mp
+		LEFT JOIN recipes r ON mp.recipe_id = r.id
+		ORDER BY mp.date, mp.meal_type
+	`)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	records := []mealPlanRecord{}
+	var csvRows [][]string
+	for rows.Next() {
+		var date time.Time
+		var rec mealPlanRecord
+		if err := rows.Scan(&date, &rec.MealType, &rec.Recipe, &rec.IsCooked, &rec.Servings, &rec.IsLeftovers, &rec.GuestCount); err != nil {
+			writeError(w, err)
+			return
+		}
+		rec.Date = date.Format("2006-01-02")
+		records = append(records, rec)
+		servings := ""
+		if rec.Servings != nil {
+			servings = formatFloat(*rec.Servings)
+		}
+		csvRows = append(csvRows, []string{rec.Date, rec.MealType, rec.Recipe, strconv.FormatBool(rec.IsCooked),
+			servings, strconv.FormatBool(rec.IsLeftovers), strconv.Itoa(rec.GuestCount)})
+	}
+
+	writeExport(w, r, "mealplan", mealPlanColumns, csvRows, records)
+}
+
+func (h *MealPlanHandler) ImportMealPlan(w http.ResponseWriter, r *http.Request) {
+	data, format, err := readImport(r)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rep := &ImportReport{DryRun: r.URL.Query().Get("dry_run") == "true"}
+	var records []mealPlanRecord
+	parseErrs := map[int]error{}
+	if format == "json" {
+		if err := json.Unmarshal(data, &records); err != nil {
+			jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		rows, err := readCSV(data)
+		if err != nil {
+			jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for n, row := range rows {
+			rec := mealPlanRecord{Date: row["date"], MealType: row["meal_type"], Recipe: row["recipe"]}
+			isCooked, err := optionalBool(row["is_cooked"])
+			if err != nil {
+				parseErrs[n] = fmt.Errorf("is_cooked: %v", err)
+			} else if isCooked != nil {
+				rec.IsCooked = *isCooked
+			}
+			if rec.Servings, err = optionalFloat(row["servings"]); err != nil {
+				parseErrs[n] = fmt.Errorf("servings: %v", err)
+			}
+			isLeftovers, err := optionalBool(row["is_leftovers"])
+			if err != nil {
+				parseErrs[n] = fmt.Errorf("is_leftovers: %v", err)
+			} else if isLeftovers != nil {
+				rec.IsLeftovers = *isLeftovers
+			}
+			if g := row["guest_count"]; g != "" {
+				if rec.GuestCount, err = strconv.Atoi(g); err != nil {
+					parseErrs[n] = fmt.Errorf("guest_count: invalid number %q", g)
+				}
+			}
+			records = append(records, rec)
+		}
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	for n, rec := range records {
+		name := rec.Date + " " + rec.MealType
+		if parseErrs[n] != nil {
+			rep.fail(n+1, name, parseErrs[n])
+			continue
+		}
+		rec := rec
+		err := rep.importRow(tx, n+1, name, func() (string, int, error) {
+			return importMealPlan(tx, rec)
+		})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	finishImport(w, tx, rep)
+}
+
+// importMealPlan schedules the meal unless the same recipe is already planned for that slot.
+func importMealPlan(tx *sql.Tx, rec mealPlanRecord) (string, int, error) {
+	date, err := time.Parse("2006-01-02", rec.Date)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid date %q, use YYYY-MM-DD", rec.Date)
+	}
+	if !mealTypes[rec.MealType] {
+		return "", 0, fmt.Errorf("invalid meal_type %q, use Breakfast, Lunch or Dinner", rec.MealType)
+	}
+	// The checks of ScheduleMeal
+	var v validator
+	v.check(rec.Servings == nil || *rec.Servings > 0, "servings", "must be positive")
+	v.check(rec.GuestCount >= 0, "guest_count", "cannot be negative")
+	v.check(!rec.IsLeftovers || rec.Recipe != "", "recipe", "is required for leftovers")
+	if err := v.err(); err != nil {
+		return "", 0, err
+	}
+
+	var recipeID *int
+	if rec.Recipe != "" {
+		var id int
+		err := tx.QueryRow("SELECT id FROM recipes WHERE LOWER(name) = LOWER($1) ORDER BY id LIMIT 1", rec.Recipe).Scan(&id)
+		if err == sql.ErrNoRows {
+			return "", 0, fmt.Errorf("unknown recipe %q", rec.Recipe)
+		} else if err != nil {
+			return "", 0, err
+		}
+		recipeID = &id
+	}
+
+	var id int
+	err = tx.QueryRow(
+		"SELECT id FROM meal_plan WHERE date = $1 AND meal_type = $2 AND recipe_id IS NOT DISTINCT FROM $3",
+		date, rec.MealType, recipeID,
+	).Scan(&id)
+	if err == nil {
+		return "skip", id, nil
+	} else if err != sql.ErrNoRows {
+		return "", 0, err
+	}
+
+	err = tx.QueryRow(
+		`INSERT INTO meal_plan (date, meal_type, recipe_id, is_cooked, servings, is_leftovers, guest_count)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+		date, rec.MealType, recipeID, rec.IsCooked, rec.Servings, rec.IsLeftovers, rec.GuestCount,
+	).Scan(&id)
+	if err != nil {
+		return "", 0, err
+	}
+	return "create", id, nil
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestImportIngredientsValidation(t *testing.T) {
+	db := newTestDB(t)
+	h := &InventoryHandler{DB: db}
+	if _, err := db.Exec("INSERT INTO ingredients (name, current_stock, price) VALUES ('milk', 2, 1.5)"); err != nil {
+		t.Fatal(err)
+	}
+
+	csv := "name,current_stock,price,category\n" +
+		"eggs,12,0.3,food\n" +
+		"flour,-1,,\n" +
+		"soap,1,2,cleaning\n" +
+		",1,,\n" +
+		"milk,,-4,\n"
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/api/ingredients/import?format=csv", strings.NewReader(csv))
+	h.ImportIngredients(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+
+	var rep ImportReport
+	decode(t, w, &rep)
+	if rep.Created != 1 || rep.Failed != 4 {
+		t.Fatalf("report = %+v", rep)
+	}
+	want := []string{
+		"",
+		"Invalid input (current_stock cannot be negative)",
+		"Invalid input (category must be food or daily)",
+		"Invalid input (name is required)",
+		"Invalid input (price cannot be negative)",
+	}
+	for i, row := range rep.Rows {
+		if row.Row != i+1 || row.Error != want[i] {
+			t.Errorf("row %d = %+v, want error %q", i+1, row, want[i])
+		}
+	}
+
+	var price float64
+	db.QueryRow("SELECT price FROM ingredients WHERE name = 'milk'").Scan(&price)
+	if price != 1.5 {
+		t.Errorf("milk price = %v after a rejected update, want 1.5", price)
+	}
+}
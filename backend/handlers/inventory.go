@@ -3,70 +3,99 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/Kano-Chien/house_management/backend/models"
 )
 
 type InventoryHandler struct {
-	DB *sql.DB
+	DB           *sql.DB
+	Ingredients  IngredientStore
+	ExpiringDays int // Default window of GetExpiring, 3 when 0
+}
+
+// ingredientCategories are the inventory tabs: food and daily necessities.
+var ingredientCategories = map[string]bool{"food": true, "daily": true}
+
+// validateIngredient checks the fields users enter for an ingredient.
+func validateIngredient(v *validator, name string, stock, price float64, category string) {
+	v.check(strings.TrimSpace(name) != "", "name", "is required")
+	v.check(len(name) <= 255, "name", "must be at most 255 characters")
+	v.check(stock >= 0, "current_stock", "cannot be negative")
+	v.check(price >= 0, "price", "cannot be negative")
+	v.check(ingredientCategories[category], "category", "must be food or daily")
 }
 
 func (h *InventoryHandler) GetInventory(w http.ResponseWriter, r *http.Request) {
-	// Query includes calculation for planned consumption based on future meals
-	query := `
-		SELECT
-			i.id, i.name, i.current_stock, COALESCE(i.unit, '') as unit, i.expiry_date, COALESCE(i.price, 0) as price,
-			COALESCE(i.category, 'food') as category,
-			i.is_tracked,
-			COALESCE((
-				SELECT SUM(ri.quantity)
-				FROM recipe_ingredients ri
-				INNER JOIN meal_plan mp ON ri.recipe_id = mp.recipe_id
-				WHERE ri.ingredient_id = i.id
-			), 0) as planned_consumption
-		FROM ingredients i
-		GROUP BY i.id
-	`
-	rows, err := h.DB.Query(query)
+	// Optional filter: ?location_id=3 (or ?location_id=none for unassigned items)
+	var filter IngredientFilter
+	switch loc := r.URL.Query().Get("location_id"); loc {
+	case "":
+	case "none":
+		filter.NoLocation = true
+	default:
+		locationID, err := strconv.Atoi(loc)
+		if err != nil {
+			jsonError(w, "Invalid location_id", http.StatusBadRequest)
+			return
+		}
+		filter.LocationID = &locationID
+	}
+
+	// Includes the planned consumption of the meals in the plan
+	inventory, err := h.Ingredients.ListIngredients(filter)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
-	defer rows.Close()
 
-	var inventory []models.Ingredient
-	for rows.Next() {
-		var i models.Ingredient
-		// Use sql.NullFloat64 or similar if needed, but COALESCE handles nulls
-		if err := rows.Scan(&i.ID, &i.Name, &i.CurrentStock, &i.Unit, &i.ExpiryDate, &i.Price, &i.Category, &i.IsTracked, &i.PlannedConsumption); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		inventory = append(inventory, i)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(inventory)
+}
+
+// GetIngredient returns one ingredient (?id=) with its planned consumption, flags and aliases.
+func (h *InventoryHandler) GetIngredient(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		jsonError(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+
+	i, err := h.Ingredients.GetIngredient(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		jsonError(w, "Ingredient not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(w, err)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(inventory)
+	json.NewEncoder(w).Encode(i)
 }
 
 func (h *InventoryHandler) AddIngredient(w http.ResponseWriter, r *http.Request) {
 	var i models.Ingredient
 	if err := json.NewDecoder(r.Body).Decode(&i); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		jsonError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	if i.Category == "" {
 		i.Category = "food"
 	}
-	err := h.DB.QueryRow(
-		"INSERT INTO ingredients (name, current_stock, unit, expiry_date, price, category, is_tracked) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id",
-		i.Name, i.CurrentStock, i.Unit, i.ExpiryDate, i.Price, i.Category, i.IsTracked,
-	).Scan(&i.ID)
+	var v validator
+	validateIngredient(&v, i.Name, i.CurrentStock, i.Price, i.Category)
+	if v.failed(w) {
+		return
+	}
 
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := h.Ingredients.CreateIngredient(&i); err != nil {
+		writeError(w, err)
 		return
 	}
 
@@ -81,26 +110,29 @@ func (h *InventoryHandler) UpdateStock(w http.ResponseWriter, r *http.Request) {
 		NewStock float64 `json:"new_stock"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		jsonError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	result, err := h.DB.Exec("UPDATE ingredients SET current_stock = $1 WHERE id = $2", req.NewStock, req.ID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	var v validator
+	v.check(req.NewStock >= 0, "new_stock", "cannot be negative")
+	if v.failed(w) {
 		return
 	}
 
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		http.Error(w, "Ingredient not found", http.StatusNotFound)
+	err := h.Ingredients.SetStock(req.ID, req.NewStock)
+	if errors.Is(err, sql.ErrNoRows) {
+		jsonError(w, "Ingredient not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(w, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "updated"})
 }
 
+// EditIngredient updates the fields present in the body; the rest are kept.
 func (h *InventoryHandler) EditIngredient(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		ID        int     `json:"id"`
@@ -110,26 +142,45 @@ func (h *InventoryHandler) EditIngredient(w http.ResponseWriter, r *http.Request
 		Category  string  `json:"category"`
 		IsTracked bool    `json:"is_tracked"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	current, err := h.Ingredients.GetIngredient(req.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		jsonError(w, "Ingredient not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(w, err)
+		return
+	}
+	req.Name, req.Stock, req.Price, req.Category, req.IsTracked = current.Name, current.CurrentStock, current.Price, current.Category, current.IsTracked
+	// Decoding again over the stored values leaves omitted fields as they are
+	if err := json.Unmarshal(body, &req); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	if req.Category == "" {
 		req.Category = "food"
 	}
-
-	result, err := h.DB.Exec(
-		"UPDATE ingredients SET name = $1, current_stock = $2, price = $3, category = $4, is_tracked = $5 WHERE id = $6",
-		req.Name, req.Stock, req.Price, req.Category, req.IsTracked, req.ID,
-	)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	var v validator
+	validateIngredient(&v, req.Name, req.Stock, req.Price, req.Category)
+	if v.failed(w) {
 		return
 	}
 
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		http.Error(w, "Ingredient not found", http.StatusNotFound)
+	current.Name, current.CurrentStock, current.Price, current.Category, current.IsTracked = req.Name, req.Stock, req.Price, req.Category, req.IsTracked
+	err = h.Ingredients.UpdateIngredient(current)
+	if errors.Is(err, sql.ErrNoRows) {
+		jsonError(w, "Ingredient not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(w, err)
 		return
 	}
 
@@ -142,22 +193,179 @@ func (h *InventoryHandler) DeleteIngredient(w http.ResponseWriter, r *http.Reque
 		ID int `json:"id"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		jsonError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	result, err := h.DB.Exec("DELETE FROM ingredients WHERE id = $1", req.ID)
+	err := h.Ingredients.DeleteIngredient(req.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		jsonError(w, "Ingredient not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+}
+
+func (h *InventoryHandler) MoveIngredient(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID         int    `json:"id"`
+		LocationID *int   `json:"location_id"` // null moves the item out of any location
+		Note       string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	var fromLocationID sql.NullInt64
+	var stock float64
+	err = tx.QueryRow("SELECT location_id, current_stock FROM ingredients WHERE id = $1 FOR UPDATE", req.ID).Scan(&fromLocationID, &stock)
+	if err == sql.ErrNoRows {
+		jsonError(w, "Ingredient not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if req.LocationID != nil {
+		var exists bool
+		if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM locations WHERE id = $1)", *req.LocationID).Scan(&exists); err != nil {
+			writeError(w, err)
+			return
+		}
+		if !exists {
+			jsonError(w, "Location not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	if _, err := tx.Exec("UPDATE ingredients SET location_id = $1 WHERE id = $2", req.LocationID, req.ID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Moving does not change the stock level, record the quantity that was moved
+	_, err = tx.Exec(
+		"INSERT INTO inventory_history (ingredient_id, action, quantity_change, from_location_id, to_location_id, note) VALUES ($1, 'move', $2, $3, $4, $5)",
+		req.ID, stock, fromLocationID, req.LocationID, req.Note,
+	)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		http.Error(w, "Ingredient not found", http.StatusNotFound)
+	if err := tx.Commit(); err != nil {
+		writeError(w, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+	json.NewEncoder(w).Encode(map[string]string{"status": "moved"})
+}
+
+func (h *InventoryHandler) GetHistory(w http.ResponseWriter, r *http.Request) {
+	// Optional filter: ?ingredient_id=5
+	where := ""
+	var args []interface{}
+	if ing := r.URL.Query().Get("ingredient_id"); ing != "" {
+		ingredientID, err := strconv.Atoi(ing)
+		if err != nil {
+			jsonError(w, "Invalid ingredient_id", http.StatusBadRequest)
+			return
+		}
+		where = "WHERE h.ingredient_id = $1"
+		args = append(args, ingredientID)
+	}
+
+	rows, err := h.DB.Query(`
+		SELECT h.id, h.ingredient_id, i.name, h.action, COALESCE(h.quantity_change, 0),
+			h.from_location_id, COALESCE(fl.name, ''), h.to_location_id, COALESCE(tl.name, ''),
+			COALESCE(h.note, ''), h.created_at
+		FROM inventory_history h
+		JOIN ingredients i ON h.ingredient_id = i.id
+		LEFT JOIN locations fl ON h.from_location_id = fl.id
+		LEFT JOIN locations tl ON h.to_location_id = tl.id
+		`+where+`
+		ORDER BY h.created_at DESC, h.id DESC
+	`, args...)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	var history []models.InventoryHistory
+	for rows.Next() {
+		var e models.InventoryHistory
+		if err := rows.Scan(&e.ID, &e.IngredientID, &e.IngredientName, &e.Action, &e.QuantityChange,
+			&e.FromLocationID, &e.FromLocationName, &e.ToLocationID, &e.ToLocationName,
+			&e.Note, &e.CreatedAt); err != nil {
+			writeError(w, err)
+			return
+		}
+		history = append(history, e)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
+
+// GetExpiring lists ingredients and prepared food, leftovers included, that
+// expire within ?days= days (default h.ExpiringDays), expired ones first.
+func (h *InventoryHandler) GetExpiring(w http.ResponseWriter, r *http.Request) {
+	days := h.ExpiringDays
+	if days == 0 {
+		days = 3
+	}
+	if v := r.URL.Query().Get("days"); v != "" {
+		d, err := strconv.Atoi(v)
+		if err != nil || d < 0 {
+			jsonError(w, "Invalid days", http.StatusBadRequest)
+			return
+		}
+		days = d
+	}
+
+	// Today is the server's, not the database's: see today
+	rows, err := h.DB.Query(`
+		SELECT 'ingredient', id, name, current_stock, COALESCE(unit, ''), expiry_date, expiry_date - $1::DATE
+		FROM ingredients
+		WHERE expiry_date IS NOT NULL AND current_stock > 0 AND expiry_date <= $2::DATE
+		UNION ALL
+		SELECT p.kind, p.id, r.name, p.servings, 'servings', p.expiry_date, p.expiry_date - $1::DATE
+		FROM prepared_foods p
+		JOIN recipes r ON p.recipe_id = r.id
+		WHERE p.expiry_date IS NOT NULL AND p.servings > 0 AND p.expiry_date <= $2::DATE
+		ORDER BY 6, 3
+	`, today(), today().AddDate(0, 0, days))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	alerts := []models.ExpiryAlert{}
+	for rows.Next() {
+		var a models.ExpiryAlert
+		if err := rows.Scan(&a.Kind, &a.ID, &a.Name, &a.Quantity, &a.Unit, &a.ExpiryDate, &a.DaysLeft); err != nil {
+			writeError(w, err)
+			return
+		}
+		alerts = append(alerts, a)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(alerts)
 }
@@ -2,18 +2,23 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
-	"os"
 	"strings"
+	"time"
+
+	"github.com/Kano-Chien/house_management/backend/metrics"
 )
 
 type LineNotifyHandler struct {
-	DB *sql.DB
+	DB            *sql.DB
+	ChannelSecret string // Verifies webhook requests
+	AccessToken   string // Authorizes calls to the LINE Messaging API
 }
 
 func (h *LineNotifyHandler) SendShoppingList(w http.ResponseWriter, r *http.Request) {
@@ -25,7 +30,7 @@ func (h *LineNotifyHandler) SendShoppingList(w http.ResponseWriter, r *http.Requ
 	var items []RequestItem
 
 	if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		jsonError(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
@@ -44,48 +49,76 @@ func (h *LineNotifyHandler) SendShoppingList(w http.ResponseWriter, r *http.Requ
 	}
 
 	// 3. Send via LINE Messaging API (Broadcast Message)
-	token := os.Getenv("LINE_CHANNEL_ACCESS_TOKEN")
-	// userID is not needed for broadcast
-	// userID := os.Getenv("LINE_USER_ID")
+	token := h.AccessToken
 
 	if token == "" {
-		http.Error(w, "LINE credentials not configured", http.StatusInternalServerError)
+		jsonError(w, "LINE credentials not configured", http.StatusServiceUnavailable)
 		return
 	}
 
-	lineBody := map[string]interface{}{
+	// Use broadcast instead of push to avoid User ID issues
+	err := postLine(r.Context(), token, "broadcast", map[string]interface{}{
 		"messages": []map[string]string{
 			{"type": "text", "text": sb.String()},
 		},
+	})
+	var apiErr *lineAPIError
+	if errors.As(err, &apiErr) {
+		jsonError(w, apiErr.Error(), http.StatusBadGateway)
+		return
+	} else if err != nil {
+		jsonError(w, "Failed to send LINE message: "+err.Error(), http.StatusBadGateway)
+		return
 	}
 
-	bodyBytes, _ := json.Marshal(lineBody)
-	// Use broadcast instead of push to avoid User ID issues
-	req2, err := http.NewRequest("POST", "https://api.line.me/v2/bot/message/broadcast", bytes.NewBuffer(bodyBytes))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "sent", "message": "Shopping list sent to LINE!"})
+}
+
+var lineClient = &http.Client{Timeout: 10 * time.Second}
+
+// lineAPIError is an answer of the LINE Messaging API other than 200.
+type lineAPIError struct {
+	Status int
+	Body   string
+}
+
+func (e *lineAPIError) Error() string {
+	return fmt.Sprintf("LINE API error (%d): %s", e.Status, e.Body)
+}
+
+// postLine sends payload to a LINE Messaging API endpoint such as "broadcast"
+// and logs the outcome with the request ID of ctx and LINE's own request ID.
+func postLine(ctx context.Context, token, endpoint string, payload interface{}) error {
+	body, err := json.Marshal(payload)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return err
 	}
-	req2.Header.Set("Content-Type", "application/json")
-	req2.Header.Set("Authorization", "Bearer "+token)
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.line.me/v2/bot/message/"+endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
 
-	client := &http.Client{}
-	resp, err := client.Do(req2)
+	log := Logger(ctx).With("endpoint", endpoint)
+	start := time.Now()
+	resp, err := lineClient.Do(req)
 	if err != nil {
-		http.Error(w, "Failed to send LINE message: "+err.Error(), http.StatusInternalServerError)
-		return
+		metrics.LineCalls.WithLabelValues(endpoint, "error").Inc()
+		log.Error("LINE API call failed", "error", err, "latency", time.Since(start))
+		return err
 	}
 	defer resp.Body.Close()
-
 	respBody, _ := io.ReadAll(resp.Body)
-	requestID := resp.Header.Get("x-line-request-id")
-	log.Printf("[LINE API] RequestID: %s | Status: %d | Body: %s", requestID, resp.StatusCode, string(respBody))
 
-	if resp.StatusCode != 200 {
-		http.Error(w, fmt.Sprintf("LINE API error (%d): %s", resp.StatusCode, string(respBody)), http.StatusInternalServerError)
-		return
+	log = log.With("status", resp.StatusCode, "line_request_id", resp.Header.Get("X-Line-Request-Id"), "latency", time.Since(start))
+	if resp.StatusCode != http.StatusOK {
+		metrics.LineCalls.WithLabelValues(endpoint, "rejected").Inc()
+		log.Warn("LINE API call rejected", "body", string(respBody))
+		return &lineAPIError{Status: resp.StatusCode, Body: string(respBody)}
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "sent", "message": "Shopping list sent to LINE!"})
+	metrics.LineCalls.WithLabelValues(endpoint, "ok").Inc()
+	log.Info("LINE API call")
+	return nil
 }
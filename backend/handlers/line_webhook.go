@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	// "out for dinner", "out lunch tomorrow", "back for dinner 2026-10-20"
+	lineAttendanceCommand = regexp.MustCompile(`(?i)^(out|in|back)\s+(?:for\s+)?(breakfast|lunch|dinner)(?:\s+(today|tomorrow|\d{4}-\d{2}-\d{2}))?$`)
+	// "link K7PX2MQA" links the LINE account to the member the app gave the code to
+	lineLinkCommand = regexp.MustCompile(`(?i)^link\s+([a-z0-9]{8})$`)
+	// "stock eggs" looks an ingredient up by name or alias
+	lineStockCommand = regexp.MustCompile(`(?i)^stock\s+(.+?)\??$`)
+)
+
+const lineHelp = "Commands:\n• link <code from the app>\n• out for dinner [today|tomorrow|YYYY-MM-DD]\n• back for lunch [today|tomorrow|YYYY-MM-DD]\n• stock <ingredient>"
+
+type lineEvent struct {
+	Type       string `json:"type"`
+	ReplyToken string `json:"replyToken"`
+	Source     struct {
+		UserID string `json:"userId"`
+	} `json:"source"`
+	Message struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"message"`
+}
+
+// Webhook receives LINE Messaging API events and answers text commands, so
+// members can say they are out for a meal from the chat.
+func (h *LineNotifyHandler) Webhook(w http.ResponseWriter, r *http.Request) {
+	secret, token := h.ChannelSecret, h.AccessToken
+	if secret == "" || token == "" {
+		jsonError(w, "LINE credentials not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	signature, err := base64.StdEncoding.DecodeString(r.Header.Get("X-Line-Signature"))
+	if err != nil || !hmac.Equal(signature, mac.Sum(nil)) {
+		jsonError(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var payload struct {
+		Events []lineEvent `json:"events"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, ev := range payload.Events {
+		if ev.Type != "message" || ev.Message.Type != "text" || ev.ReplyToken == "" {
+			continue
+		}
+		reply := h.lineCommand(r.Context(), ev.Source.UserID, strings.TrimSpace(ev.Message.Text))
+		if reply == "" {
+			continue
+		}
+		// Failures are logged by postLine
+		postLine(r.Context(), token, "reply", map[string]interface{}{
+			"replyToken": ev.ReplyToken,
+			"messages":   []map[string]string{{"type": "text", "text": reply}},
+		})
+	}
+
+	// LINE only needs a 200
+	w.WriteHeader(http.StatusOK)
+}
+
+// lineFailed logs why a chat command failed and returns the reply for it, which
+// like a 500 response does not tell the cause.
+func lineFailed(ctx context.Context, err error) string {
+	Logger(ctx).ErrorContext(ctx, "LINE command failed", "error", err)
+	return "Sorry, something went wrong. Please try again later."
+}
+
+// lineCommand runs one chat command and returns the reply, empty for messages
+// that are not commands.
+func (h *LineNotifyHandler) lineCommand(ctx context.Context, userID, text string) string {
+	if m := lineLinkCommand.FindStringSubmatch(text); m != nil {
+		return h.lineLink(ctx, userID, strings.ToUpper(m[1]))
+	}
+
+	if m := lineStockCommand.FindStringSubmatch(text); m != nil {
+		return h.lineStock(ctx, strings.TrimSpace(m[1]))
+	}
+
+	m := lineAttendanceCommand.FindStringSubmatch(text)
+	if m == nil {
+		if strings.EqualFold(text, "help") {
+			return lineHelp
+		}
+		return ""
+	}
+
+	var memberID int
+	var name string
+	err := h.DB.QueryRow("SELECT id, name FROM household_members WHERE line_user_id = $1", userID).Scan(&memberID, &name)
+	if err == sql.ErrNoRows {
+		return "I don't know who you are yet. Get a link code for yourself in the app and send \"link <code>\" first."
+	} else if err != nil {
+		return lineFailed(ctx, err)
+	}
+
+	attending := !strings.EqualFold(m[1], "out")
+	mealType := strings.ToUpper(m[2][:1]) + strings.ToLower(m[2][1:])
+	date := time.Now()
+	switch day := strings.ToLower(m[3]); day {
+	case "", "today":
+	case "tomorrow":
+		date = date.AddDate(0, 0, 1)
+	default:
+		if date, err = time.Parse("2006-01-02", day); err != nil {
+			return "Dates look like 2026-10-20."
+		}
+	}
+
+	n, err := markAttendance(h.DB, memberID, date, mealType, attending)
+	if err != nil {
+		return lineFailed(ctx, err)
+	}
+	day := date.Format("Mon 2006-01-02")
+	if n == 0 {
+		return fmt.Sprintf("No %s is planned on %s.", strings.ToLower(mealType), day)
+	}
+	if attending {
+		return fmt.Sprintf("Got it %s, you're in for %s on %s.", name, strings.ToLower(mealType), day)
+	}
+	return fmt.Sprintf("Got it %s, you're out for %s on %s.", name, strings.ToLower(mealType), day)
+}
+
+// lineLink links the LINE account userID to the member a link code was made
+// for, see HouseholdHandler.LinkLine. Neither the member nor the LINE account
+// may be linked already.
+func (h *LineNotifyHandler) lineLink(ctx context.Context, userID, code string) string {
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return lineFailed(ctx, err)
+	}
+	defer tx.Rollback()
+
+	var memberID int
+	var name string
+	err = tx.QueryRow(`
+		SELECT m.id, m.name FROM line_link_codes c
+		JOIN household_members m ON m.id = c.member_id
+		WHERE c.code = $1 AND c.expires_at > $2`,
+		code, time.Now(),
+	).Scan(&memberID, &name)
+	if err == sql.ErrNoRows {
+		return "That code is unknown or has expired. Get a new one in the app."
+	} else if err != nil {
+		return lineFailed(ctx, err)
+	}
+
+	var current string
+	err = tx.QueryRow("SELECT name FROM household_members WHERE line_user_id = $1", userID).Scan(&current)
+	if err == nil {
+		return fmt.Sprintf("Your LINE account is linked to %s already.", current)
+	} else if err != sql.ErrNoRows {
+		return lineFailed(ctx, err)
+	}
+
+	result, err := tx.Exec("UPDATE household_members SET line_user_id = $1 WHERE id = $2 AND line_user_id IS NULL", userID, memberID)
+	if err != nil {
+		return lineFailed(ctx, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Sprintf("%s is linked to another LINE account already.", name)
+	}
+	if _, err := tx.Exec("DELETE FROM line_link_codes WHERE member_id = $1", memberID); err != nil {
+		return lineFailed(ctx, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return lineFailed(ctx, err)
+	}
+	return fmt.Sprintf("Hi %s, your LINE account is now linked.", name)
+}
+
+// lineStock replies with the stock of an ingredient, or similar names when there is no match.
+func (h *LineNotifyHandler) lineStock(ctx context.Context, name string) string {
+	id, err := findIngredientByName(h.DB, name)
+	if err == sql.ErrNoRows {
+		similar, err := suggestIngredients(h.DB, name, 3, 0)
+		if err != nil {
+			return lineFailed(ctx, err)
+		}
+		if len(similar) == 0 {
+			return fmt.Sprintf("There is no ingredient called %s.", name)
+		}
+		names := make([]string, len(similar))
+		for i, m := range similar {
+			names[i] = m.Name
+		}
+		return fmt.Sprintf("There is no ingredient called %s. Did you mean %s?", name, strings.Join(names, ", "))
+	} else if err != nil {
+		return lineFailed(ctx, err)
+	}
+
+	var found, unit string
+	var stock float64
+	err = h.DB.QueryRow("SELECT name, current_stock, COALESCE(unit, '') FROM ingredients WHERE id = $1", id).Scan(&found, &stock, &unit)
+	if err != nil {
+		return lineFailed(ctx, err)
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s: %g %s", found, stock, unit))
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kano-Chien/house_management/backend/models"
+)
+
+type LocationHandler struct {
+	DB *sql.DB
+}
+
+func (h *LocationHandler) GetLocations(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.Query(`
+		SELECT l.id, l.name, COALESCE(l.description, '') as description, COUNT(i.id) as item_count
+		FROM locations l
+		LEFT JOIN ingredients i ON i.location_id = l.id
+		GROUP BY l.id
+		ORDER BY l.name
+	`)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	var locations []models.Location
+	for rows.Next() {
+		var l models.Location
+		if err := rows.Scan(&l.ID, &l.Name, &l.Description, &l.ItemCount); err != nil {
+			writeError(w, err)
+			return
+		}
+		locations = append(locations, l)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(locations)
+}
+
+func (h *LocationHandler) CreateLocation(w http.ResponseWriter, r *http.Request) {
+	var l models.Location
+	if err := json.NewDecoder(r.Body).Decode(&l); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var v validator
+	v.check(strings.TrimSpace(l.Name) != "", "name", "is required")
+	v.check(len(l.Name) <= 100, "name", "must be at most 100 characters")
+	if v.failed(w) {
+		return
+	}
+
+	err := h.DB.QueryRow(
+		"INSERT INTO locations (name, description) VALUES ($1, $2) RETURNING id",
+		l.Name, l.Description,
+	).Scan(&l.ID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(l)
+}
+
+func (h *LocationHandler) UpdateLocation(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID          int    `json:"id"`
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var v validator
+	v.check(strings.TrimSpace(req.Name) != "", "name", "is required")
+	v.check(len(req.Name) <= 100, "name", "must be at most 100 characters")
+	if v.failed(w) {
+		return
+	}
+
+	result, err := h.DB.Exec("UPDATE locations SET name = $1, description = $2 WHERE id = $3", req.Name, req.Description, req.ID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		jsonError(w, "Location not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "updated"})
+}
+
+func (h *LocationHandler) DeleteLocation(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Items stored here become unassigned (ON DELETE SET NULL)
+	result, err := h.DB.Exec("DELETE FROM locations WHERE id = $1", req.ID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		jsonError(w, "Location not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+}
+
+// GetLocationStock lists every item grouped by where it is stored, in the order
+// you would walk past them while counting. ?location_id= limits it to one location.
+func (h *LocationHandler) GetLocationStock(w http.ResponseWriter, r *http.Request) {
+	type StockItem struct {
+		IngredientID int        `json:"ingredient_id"`
+		Name         string     `json:"name"`
+		CurrentStock float64    `json:"current_stock"`
+		Unit         string     `json:"unit"`
+		ExpiryDate   *time.Time `json:"expiry_date,omitempty"`
+		Category     string     `json:"category"`
+	}
+	type LocationStock struct {
+		LocationID   *int        `json:"location_id"`
+		LocationName string      `json:"location_name"`
+		Items        []StockItem `json:"items"`
+	}
+
+	where := ""
+	var args []interface{}
+	if loc := r.URL.Query().Get("location_id"); loc != "" {
+		locationID, err := strconv.Atoi(loc)
+		if err != nil {
+			jsonError(w, "Invalid location_id", http.StatusBadRequest)
+			return
+		}
+		where = "WHERE i.location_id = $1"
+		args = append(args, locationID)
+	}
+
+	rows, err := h.DB.Query(`
+		SELECT i.location_id, COALESCE(l.name, 'Unassigned'), i.id, i.name, i.current_stock,
+			COALESCE(i.unit, ''), i.expiry_date, COALESCE(i.category, 'food')
+		FROM ingredients i
+		LEFT JOIN locations l ON i.location_id = l.id
+		`+where+`
+		ORDER BY l.name NULLS LAST, l.id, i.name
+	`, args...)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	var result []LocationStock
+	for rows.Next() {
+		var locationID *int
+		var locationName string
+		var item StockItem
+		if err := rows.Scan(&locationID, &locationName, &item.IngredientID, &item.Name, &item.CurrentStock,
+			&item.Unit, &item.ExpiryDate, &item.Category); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		// Rows are ordered by location, so a new group starts whenever the location
+		// changes. Unassigned items are a group of their own, even next to a
+		// location that happens to be called "Unassigned".
+		if len(result) == 0 || !sameLocation(result[len(result)-1].LocationID, locationID) {
+			result = append(result, LocationStock{LocationID: locationID, LocationName: locationName})
+		}
+		result[len(result)-1].Items = append(result[len(result)-1].Items, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// sameLocation compares two location IDs, nil meaning unassigned.
+func sameLocation(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 
@@ -10,70 +11,88 @@ import (
 )
 
 type MealPlanHandler struct {
-	DB *sql.DB
+	DB           *sql.DB
+	MealPlans    MealPlanStore
+	LeftoverDays int // How long leftovers keep when cooking does not say, 3 when 0
 }
 
+// mealTypes are the values allowed by the meal_plan meal_type check.
+var mealTypes = map[string]bool{"Breakfast": true, "Lunch": true, "Dinner": true}
+
+// defaultLeftoverDays is how long leftovers keep when LeftoverDays is not set.
+const defaultLeftoverDays = 3
+
 func (h *MealPlanHandler) GetMealPlan(w http.ResponseWriter, r *http.Request) {
-	// Optional: Filter by date range query params ?start=...&end=...
-	rows, err := h.DB.Query(`
-		SELECT mp.id, mp.date, mp.meal_type, mp.recipe_id, r.name, COALESCE(mp.is_cooked, FALSE)
-		FROM meal_plan mp 
-		LEFT JOIN recipes r ON mp.recipe_id = r.id
-		ORDER BY mp.date, mp.meal_type
-	`)
+	plan, err := h.MealPlans.ListMealPlans()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
-	defer rows.Close()
-
-	var plan []models.MealPlan
-	for rows.Next() {
-		var mp models.MealPlan
-		var rName sql.NullString
-		if err := rows.Scan(&mp.ID, &mp.Date, &mp.MealType, &mp.RecipeID, &rName, &mp.IsCooked); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		if rName.Valid {
-			mp.RecipeName = rName.String
-		}
-		plan = append(plan, mp)
-	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(plan)
 }
 
 func (h *MealPlanHandler) ScheduleMeal(w http.ResponseWriter, r *http.Request) {
-	// Fix date parsing if JSON sends string, but let's assume standard ISO8601 handled by Go's JSON parser to time.Time if format matches
-	// Or use a custom struct for decoding
 	type Request struct {
-		Date     string `json:"date"` // YYYY-MM-DD
-		MealType string `json:"meal_type"`
-		RecipeID *int   `json:"recipe_id"`
+		Date       string   `json:"date"` // YYYY-MM-DD
+		MealType   string   `json:"meal_type"`
+		RecipeID   *int     `json:"recipe_id"`
+		Servings   *float64 `json:"servings"`    // Optional, servings eaten at this meal
+		Leftovers  bool     `json:"leftovers"`   // Eat leftovers of the recipe instead of cooking it
+		MemberIDs  []int    `json:"member_ids"`  // Who eats, default the household without guests
+		GuestCount int      `json:"guest_count"` // Guests who are not household members
+		Force      bool     `json:"force"`       // Schedule despite strict diet conflicts
 	}
 	var input Request
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		jsonError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	date, err := time.Parse("2006-01-02", input.Date)
-	if err != nil {
-		http.Error(w, "Invalid date format. Use YYYY-MM-DD", http.StatusBadRequest)
+	var v validator
+	v.check(err == nil, "date", "must be YYYY-MM-DD")
+	v.check(mealTypes[input.MealType], "meal_type", "must be Breakfast, Lunch or Dinner")
+	v.check(input.Servings == nil || *input.Servings > 0, "servings", "must be positive")
+	v.check(input.GuestCount >= 0, "guest_count", "cannot be negative")
+	v.check(!input.Leftovers || input.RecipeID != nil, "recipe_id", "is required for leftovers")
+	if v.failed(w) {
 		return
 	}
 
-	var id int
-	err = h.DB.QueryRow("INSERT INTO meal_plan (date, meal_type, recipe_id) VALUES ($1, $2, $3) RETURNING id", date, input.MealType, input.RecipeID).Scan(&id)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	// Check the recipe against the allergies and diets of the people eating
+	var conflicts []models.DietConflict
+	if input.RecipeID != nil {
+		if conflicts, err = h.MealPlans.DietConflicts(*input.RecipeID, input.MemberIDs); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	for _, c := range conflicts {
+		if c.Blocking && !input.Force {
+			writeError(w, &APIError{
+				Status:  http.StatusConflict,
+				Code:    "diet_conflict",
+				Message: "Recipe conflicts with a strict allergy or diet, resend with force to schedule anyway",
+				Details: conflicts,
+			})
+			return
+		}
+	}
+
+	// Listed members attend and everyone else is out
+	meal := models.MealPlan{
+		Date: date, MealType: input.MealType, RecipeID: input.RecipeID, Servings: input.Servings,
+		IsLeftovers: input.Leftovers, GuestCount: input.GuestCount,
+	}
+	if err := h.MealPlans.CreateMealPlan(&meal, input.MemberIDs); err != nil {
+		writeError(w, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]int{"id": id})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": meal.ID, "warnings": conflicts})
 }
 
 func (h *MealPlanHandler) DeleteMealPlan(w http.ResponseWriter, r *http.Request) {
@@ -81,97 +100,57 @@ func (h *MealPlanHandler) DeleteMealPlan(w http.ResponseWriter, r *http.Request)
 		ID int `json:"id"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		jsonError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	_, err := h.DB.Exec("DELETE FROM meal_plan WHERE id = $1", req.ID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := h.MealPlans.DeleteMealPlan(req.ID); err != nil {
+		writeError(w, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
 }
 
+// CookMeal marks a meal as cooked. A regular meal consumes its recipe's
+// ingredients and keeps whatever was not eaten as leftovers; a leftovers meal
+// consumes those servings instead.
 func (h *MealPlanHandler) CookMeal(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		ID int `json:"id"`
+		ID            int      `json:"id"`
+		Batches       float64  `json:"batches"`        // Default: enough for the planned servings, at least 1
+		ServingsEaten *float64 `json:"servings_eaten"` // Default: the planned servings, or the whole batch
+		LeftoverDays  int      `json:"leftover_days"`  // Default h.LeftoverDays
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		jsonError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	tx, err := h.DB.Begin()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if req.Batches < 0 || (req.ServingsEaten != nil && *req.ServingsEaten < 0) || req.LeftoverDays < 0 {
+		jsonError(w, "batches, servings_eaten and leftover_days must be positive", http.StatusBadRequest)
 		return
 	}
-	defer tx.Rollback()
-
-	// 1. Check current status and get recipe ID
-	var recipeID sql.NullInt64
-	var isCooked bool
-	err = tx.QueryRow("SELECT recipe_id, COALESCE(is_cooked, FALSE) FROM meal_plan WHERE id = $1 FOR UPDATE", req.ID).Scan(&recipeID, &isCooked)
-	if err == sql.ErrNoRows {
-		http.Error(w, "Meal plan not found", http.StatusNotFound)
-		return
-	} else if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	if req.LeftoverDays == 0 {
+		req.LeftoverDays = h.LeftoverDays
 	}
-
-	if isCooked {
-		http.Error(w, "Meal already cooked", http.StatusConflict)
-		return
+	if req.LeftoverDays == 0 {
+		req.LeftoverDays = defaultLeftoverDays
 	}
 
-	if !recipeID.Valid {
-		http.Error(w, "No recipe associated with this meal", http.StatusBadRequest)
+	res, err := h.MealPlans.CookMeal(CookRequest{
+		ID: req.ID, Batches: req.Batches, ServingsEaten: req.ServingsEaten, LeftoverDays: req.LeftoverDays,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		jsonError(w, "Meal plan not found", http.StatusNotFound)
 		return
-	}
-
-	// 2. Mark as cooked
-	_, err = tx.Exec("UPDATE meal_plan SET is_cooked = TRUE WHERE id = $1", req.ID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// 3. Decrement Inventory
-	// Only decrement for tracked ingredients
-	rows, err := tx.Query(`
-		SELECT ri.ingredient_id, ri.quantity 
-		FROM recipe_ingredients ri
-		JOIN ingredients i ON ri.ingredient_id = i.id
-		WHERE ri.recipe_id = $1 AND i.is_tracked = TRUE
-	`, recipeID.Int64)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	} else if err != nil {
+		writeError(w, err)
 		return
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var ingID int
-		var qty float64
-		if err := rows.Scan(&ingID, &qty); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		// Decrease stock, allowing negative
-		_, err = tx.Exec("UPDATE ingredients SET current_stock = current_stock - $1 WHERE id = $2", qty, ingID)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-	}
-
-	if err := tx.Commit(); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	w.Header().Set("Content-Type", "application/json")
+	if res.AteLeftovers {
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "cooked", "leftover_servings_used": res.LeftoverServingsUsed})
 		return
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "cooked"})
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "cooked", "leftover": res.Leftover, "substitutions": res.Substitutions})
 }
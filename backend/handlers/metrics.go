@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kano-Chien/house_management/backend/metrics"
+)
+
+// Instrument counts and times every request by the mux pattern that serves
+// it, so /api/recipes/1 and /api/recipes/2 are both /api/recipes/{id}.
+// Requests no pattern matches are counted under "unmatched".
+func Instrument(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		_, pattern := mux.Handler(r)
+		route := "unmatched"
+		if pattern != "" {
+			// The method is a label of its own
+			_, route, _ = strings.Cut(pattern, " ")
+		}
+		metrics.HTTPRequests.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Inc()
+		metrics.HTTPDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,476 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Kano-Chien/house_management/backend/database"
+	"github.com/Kano-Chien/house_management/backend/models"
+)
+
+// countUnits are stock units that count pieces, where the dataset's piece weight applies.
+var countUnits = map[string]bool{
+	"": true, "pcs": true, "piece": true, "pieces": true, "clove": true,
+	"個": true, "个": true, "顆": true, "颗": true, "粒": true, "本": true, "根": true, "瓣": true,
+}
+
+// loadRecipeNutrition sums the nutrition of one batch of a recipe, components included.
+func loadRecipeNutrition(q queryer, recipeID, servings int) (*models.RecipeNutrition, error) {
+	rows, err := q.Query(`
+		SELECT i.name, e.quantity, n.ingredient_id IS NOT NULL,
+			COALESCE(n.kcal, 0), COALESCE(n.protein, 0), COALESCE(n.fat, 0),
+			COALESCE(n.carbs, 0), COALESCE(n.fibre, 0), COALESCE(n.sodium, 0)
+		FROM recipe_ingredients_expanded e
+		JOIN ingredients i ON e.ingredient_id = i.id
+		LEFT JOIN ingredient_nutrition_per_unit n ON n.ingredient_id = e.ingredient_id
+		WHERE e.recipe_id = $1
+		ORDER BY i.name
+	`, recipeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if servings < 1 {
+		servings = 1
+	}
+	res := &models.RecipeNutrition{Servings: servings, Missing: []string{}}
+	for rows.Next() {
+		var name string
+		var quantity float64
+		var known bool
+		var n models.Nutrition
+		if err := rows.Scan(&name, &quantity, &known, &n.Kcal, &n.Protein, &n.Fat, &n.Carbs, &n.Fibre, &n.Sodium); err != nil {
+			return nil, err
+		}
+		if !known {
+			res.Missing = append(res.Missing, name)
+			continue
+		}
+		res.Total.Add(n, quantity)
+	}
+	res.PerServing.Add(res.Total, 1/float64(servings))
+	return res, rows.Err()
+}
+
+func saveNutrition(q queryer, n models.IngredientNutrition) error {
+	_, err := q.Exec(
+		`INSERT INTO ingredient_nutrition (ingredient_id, basis, grams_per_unit, kcal, protein, fat, carbs, fibre, sodium, source)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		 ON CONFLICT (ingredient_id) DO UPDATE SET
+			basis = EXCLUDED.basis, grams_per_unit = EXCLUDED.grams_per_unit,
+			kcal = EXCLUDED.kcal, protein = EXCLUDED.protein, fat = EXCLUDED.fat,
+			carbs = EXCLUDED.carbs, fibre = EXCLUDED.fibre, sodium = EXCLUDED.sodium,
+			source = EXCLUDED.source`,
+		n.IngredientID, n.Basis, n.GramsPerUnit, n.Kcal, n.Protein, n.Fat, n.Carbs, n.Fibre, n.Sodium, n.Source,
+	)
+	return err
+}
+
+func (h *InventoryHandler) GetNutrition(w http.ResponseWriter, r *http.Request) {
+	// Optional filter: ?ingredient_id=5
+	where := ""
+	var args []interface{}
+	if ing := r.URL.Query().Get("ingredient_id"); ing != "" {
+		ingredientID, err := strconv.Atoi(ing)
+		if err != nil {
+			jsonError(w, "Invalid ingredient_id", http.StatusBadRequest)
+			return
+		}
+		where = "WHERE n.ingredient_id = $1"
+		args = append(args, ingredientID)
+	}
+
+	rows, err := h.DB.Query(`
+		SELECT n.ingredient_id, i.name, COALESCE(i.unit, ''), n.basis, n.grams_per_unit, COALESCE(n.source, ''),
+			COALESCE(n.kcal, 0), COALESCE(n.protein, 0), COALESCE(n.fat, 0),
+			COALESCE(n.carbs, 0), COALESCE(n.fibre, 0), COALESCE(n.sodium, 0)
+		FROM ingredient_nutrition n
+		JOIN ingredients i ON n.ingredient_id = i.id
+		`+where+`
+		ORDER BY i.name
+	`, args...)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	list := []models.IngredientNutrition{}
+	for rows.Next() {
+		var n models.IngredientNutrition
+		if err := rows.Scan(&n.IngredientID, &n.Name, &n.Unit, &n.Basis, &n.GramsPerUnit, &n.Source,
+			&n.Kcal, &n.Protein, &n.Fat, &n.Carbs, &n.Fibre, &n.Sodium); err != nil {
+			writeError(w, err)
+			return
+		}
+		list = append(list, n)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func (h *InventoryHandler) SetNutrition(w http.ResponseWriter, r *http.Request) {
+	var n models.IngredientNutrition
+	if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if n.Basis == "" {
+		n.Basis = "100g"
+	}
+	if n.Basis != "100g" && n.Basis != "unit" {
+		jsonError(w, "basis must be 100g or unit", http.StatusBadRequest)
+		return
+	}
+	if n.GramsPerUnit != nil && *n.GramsPerUnit <= 0 {
+		jsonError(w, "grams_per_unit must be positive", http.StatusBadRequest)
+		return
+	}
+	if n.Kcal < 0 || n.Protein < 0 || n.Fat < 0 || n.Carbs < 0 || n.Fibre < 0 || n.Sodium < 0 {
+		jsonError(w, "Nutrition values cannot be negative", http.StatusBadRequest)
+		return
+	}
+	n.Source = "manual"
+
+	var exists bool
+	if err := h.DB.QueryRow("SELECT EXISTS (SELECT 1 FROM ingredients WHERE id = $1)", n.IngredientID).Scan(&exists); err != nil {
+		writeError(w, err)
+		return
+	}
+	if !exists {
+		jsonError(w, "Ingredient not found", http.StatusNotFound)
+		return
+	}
+
+	if err := saveNutrition(h.DB, n); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(n)
+}
+
+func (h *InventoryHandler) DeleteNutrition(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		IngredientID int `json:"ingredient_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err := h.DB.Exec("DELETE FROM ingredient_nutrition WHERE ingredient_id = $1", req.IngredientID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+}
+
+// nutritionEntry is one food of a nutrition dataset, per 100 g.
+type nutritionEntry struct {
+	Name          string   `json:"name"`
+	Aliases       []string `json:"aliases"`
+	GramsPerPiece *float64 `json:"grams_per_piece"`
+	models.Nutrition
+}
+
+func parseNutritionDataset(data []byte, format string) ([]nutritionEntry, error) {
+	var entries []nutritionEntry
+	if format == "json" {
+		err := json.Unmarshal(data, &entries)
+		return entries, err
+	}
+
+	rows, err := readCSV(data)
+	if err != nil {
+		return nil, err
+	}
+	for n, row := range rows {
+		e := nutritionEntry{Name: row["name"]}
+		for _, alias := range strings.Split(row["aliases"], "|") {
+			if alias = strings.TrimSpace(alias); alias != "" {
+				e.Aliases = append(e.Aliases, alias)
+			}
+		}
+		for col, dst := range map[string]*float64{
+			"kcal": &e.Kcal, "protein": &e.Protein, "fat": &e.Fat,
+			"carbs": &e.Carbs, "fibre": &e.Fibre, "sodium": &e.Sodium,
+		} {
+			v, err := optionalFloat(row[col])
+			if err != nil {
+				return nil, fmt.Errorf("row %d: invalid %s: %v", n+1, col, err)
+			}
+			if v != nil {
+				*dst = *v
+			}
+		}
+		if e.GramsPerPiece, err = optionalFloat(row["grams_per_piece"]); err != nil {
+			return nil, fmt.Errorf("row %d: invalid grams_per_piece: %v", n+1, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// matchNutrition finds the dataset entry for an ingredient name: an exact name or
+// alias first, otherwise the longest name or alias contained in it ("chicken
+// breast fillet" matches "chicken breast"). Latin terms must match whole words and
+// single CJK characters only match exactly, so "eggplant" is not an egg and "玉米"
+// is not rice.
+func matchNutrition(name string, entries []nutritionEntry) (nutritionEntry, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	words := " " + strings.Join(strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ") + " "
+
+	var best nutritionEntry
+	bestLen := 0
+	for _, e := range entries {
+		for _, term := range append([]string{e.Name}, e.Aliases...) {
+			term = strings.ToLower(term)
+			if term == "" {
+				continue
+			}
+			if term == name {
+				return e, true
+			}
+			n := len([]rune(term))
+			if n <= bestLen {
+				continue
+			}
+			ascii := strings.IndexFunc(term, func(r rune) bool { return r > unicode.MaxASCII }) < 0
+			if (ascii && strings.Contains(words, " "+term+" ")) || (!ascii && n >= 2 && strings.Contains(name, term)) {
+				best, bestLen = e, n
+			}
+		}
+	}
+	return best, bestLen > 0
+}
+
+// ImportNutrition fills in nutrition facts from a dataset, the bundled one unless a
+// CSV or JSON dataset is uploaded. Ingredients that already have nutrition keep it
+// unless ?overwrite=true; ?dry_run=true shows the matches without saving.
+func (h *InventoryHandler) ImportNutrition(w http.ResponseWriter, r *http.Request) {
+	data, format, err := readImport(r)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(data) == 0 {
+		data, format = database.NutritionCSV, "csv"
+	}
+	entries, err := parseNutritionDataset(data, format)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	overwrite := r.URL.Query().Get("overwrite") == "true"
+	rep := &ImportReport{DryRun: r.URL.Query().Get("dry_run") == "true"}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	type ingredient struct {
+		id           int
+		name, unit   string
+		hasNutrition bool
+		aliases      []string
+	}
+	aliases, err := loadIngredientAliases(tx, 0)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	rows, err := tx.Query(`
+		SELECT i.id, i.name, COALESCE(i.unit, ''), n.ingredient_id IS NOT NULL
+		FROM ingredients i
+		LEFT JOIN ingredient_nutrition n ON n.ingredient_id = i.id
+		WHERE COALESCE(i.category, 'food') = 'food'
+		ORDER BY i.name
+	`)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var ingredients []ingredient
+	for rows.Next() {
+		var ing ingredient
+		if err := rows.Scan(&ing.id, &ing.name, &ing.unit, &ing.hasNutrition); err != nil {
+			rows.Close()
+			writeError(w, err)
+			return
+		}
+		ing.aliases = aliases[ing.id]
+		ingredients = append(ingredients, ing)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	for n, ing := range ingredients {
+		// The name is tried first, then each alias
+		entry, ok := matchNutrition(ing.name, entries)
+		for _, alias := range ing.aliases {
+			if ok {
+				break
+			}
+			entry, ok = matchNutrition(alias, entries)
+		}
+		if !ok {
+			rep.Skipped++
+			rep.Rows = append(rep.Rows, ImportRowResult{Row: n + 1, Name: ing.name, Action: "skip", ID: ing.id, Error: "no match in dataset"})
+			continue
+		}
+		ing := ing
+		err := rep.importRow(tx, n+1, fmt.Sprintf("%s (%s)", ing.name, entry.Name), func() (string, int, error) {
+			if ing.hasNutrition && !overwrite {
+				return "skip", ing.id, nil
+			}
+			rec := models.IngredientNutrition{IngredientID: ing.id, Basis: "100g", Source: "dataset", Nutrition: entry.Nutrition}
+			if countUnits[strings.ToLower(ing.unit)] {
+				rec.GramsPerUnit = entry.GramsPerPiece
+			}
+			action := "create"
+			if ing.hasNutrition {
+				action = "update"
+			}
+			return action, ing.id, saveNutrition(tx, rec)
+		})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	finishImport(w, tx, rep)
+}
+
+// GetNutritionSummary totals the nutrition of planned meals per day between
+// ?from= and ?to= (YYYY-MM-DD, default the coming week). A meal counts its
+// planned servings, or the whole batch when there are none.
+func (h *MealPlanHandler) GetNutritionSummary(w http.ResponseWriter, r *http.Request) {
+	from := today()
+	to := from.AddDate(0, 0, 6)
+	var err error
+	if v := r.URL.Query().Get("from"); v != "" {
+		if from, err = time.Parse("2006-01-02", v); err != nil {
+			jsonError(w, "Invalid date format. Use YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		if to, err = time.Parse("2006-01-02", v); err != nil {
+			jsonError(w, "Invalid date format. Use YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+	}
+	if to.Before(from) {
+		jsonError(w, "to must not be before from", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := h.DB.Query(`
+		WITH per_batch AS (
+			SELECT e.recipe_id,
+				SUM(e.quantity * n.kcal) AS kcal, SUM(e.quantity * n.protein) AS protein,
+				SUM(e.quantity * n.fat) AS fat, SUM(e.quantity * n.carbs) AS carbs,
+				SUM(e.quantity * n.fibre) AS fibre, SUM(e.quantity * n.sodium) AS sodium
+			FROM recipe_ingredients_expanded e
+			JOIN ingredient_nutrition_per_unit n ON n.ingredient_id = e.ingredient_id
+			GROUP BY e.recipe_id
+		)
+		SELECT mp.date, COUNT(*),
+			COALESCE(SUM(b.kcal * f.batches), 0), COALESCE(SUM(b.protein * f.batches), 0),
+			COALESCE(SUM(b.fat * f.batches), 0), COALESCE(SUM(b.carbs * f.batches), 0),
+			COALESCE(SUM(b.fibre * f.batches), 0), COALESCE(SUM(b.sodium * f.batches), 0)
+		FROM meal_plan mp
+		JOIN (
+			SELECT s.meal_plan_id, COALESCE(s.servings / GREATEST(COALESCE(r.servings, 1), 1), 1) AS batches
+			FROM meal_plan_servings s
+			JOIN meal_plan m ON s.meal_plan_id = m.id
+			JOIN recipes r ON m.recipe_id = r.id
+		) f ON f.meal_plan_id = mp.id
+		LEFT JOIN per_batch b ON b.recipe_id = mp.recipe_id
+		WHERE mp.date BETWEEN $1 AND $2
+		GROUP BY mp.date
+		ORDER BY mp.date
+	`, from, to)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	days := []models.DayNutrition{}
+	var total models.Nutrition
+	for rows.Next() {
+		var d models.DayNutrition
+		var date time.Time
+		n := &d.Nutrition
+		if err := rows.Scan(&date, &d.Meals, &n.Kcal, &n.Protein, &n.Fat, &n.Carbs, &n.Fibre, &n.Sodium); err != nil {
+			writeError(w, err)
+			return
+		}
+		d.Date = date.Format("2006-01-02")
+		total.Add(d.Nutrition, 1)
+		days = append(days, d)
+	}
+	rows.Close()
+
+	var average models.Nutrition
+	if len(days) > 0 {
+		average.Add(total, 1/float64(len(days)))
+	}
+
+	// Recipes whose totals are understated because an ingredient has no nutrition data
+	incomplete := []string{}
+	rows, err = h.DB.Query(`
+		SELECT DISTINCT r.name
+		FROM meal_plan mp
+		JOIN recipes r ON mp.recipe_id = r.id
+		JOIN recipe_ingredients_expanded e ON e.recipe_id = mp.recipe_id
+		LEFT JOIN ingredient_nutrition_per_unit n ON n.ingredient_id = e.ingredient_id
+		WHERE mp.date BETWEEN $1 AND $2 AND n.ingredient_id IS NULL
+		ORDER BY r.name
+	`, from, to)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			writeError(w, err)
+			return
+		}
+		incomplete = append(incomplete, name)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"from":               from.Format("2006-01-02"),
+		"to":                 to.Format("2006-01-02"),
+		"days":               days,
+		"total":              total,
+		"daily_average":      average,
+		"incomplete_recipes": incomplete,
+	})
+}
@@ -3,33 +3,66 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/Kano-Chien/house_management/backend/models"
 )
 
 type RecipeHandler struct {
-	DB *sql.DB
+	DB          *sql.DB
+	Recipes     RecipeStore
+	Ingredients IngredientStore
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// findOrCreateIngredient looks an ingredient up by name or alias (case-insensitive)
+// and creates it with zero stock when it does not exist yet. unit is only used on create.
+func findOrCreateIngredient(q queryer, name, unit string, isTracked bool) (id int, created bool, err error) {
+	id, err = findIngredientByName(q, name)
+	if err == nil {
+		return id, false, nil
+	} else if err != sql.ErrNoRows {
+		return 0, false, err
+	}
+
+	err = q.QueryRow(
+		"INSERT INTO ingredients (name, current_stock, unit, price, is_tracked) VALUES ($1, 0, NULLIF($2, ''), NULL, $3) RETURNING id",
+		name, unit, isTracked,
+	).Scan(&id)
+	if err != nil {
+		return 0, false, err
+	}
+	return id, true, nil
 }
 
 func (h *RecipeHandler) GetRecipes(w http.ResponseWriter, r *http.Request) {
-	// Simple fetch for listing. Detailed fetch with ingredients could be separate or joined.
-	// For MVP, letting's just fetch basic info.
-	rows, err := h.DB.Query("SELECT id, name, instructions, COALESCE(notes, '') as notes FROM recipes")
+	// Basic info and tags for listing, the details come from GetRecipeDetail
+	recipes, err := h.Recipes.ListRecipes()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
-	defer rows.Close()
 
-	var recipes []models.Recipe
-	for rows.Next() {
-		var r models.Recipe
-		if err := rows.Scan(&r.ID, &r.Name, &r.Instructions, &r.Notes); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		recipes = append(recipes, r)
+	// Warn about recipes the people eating cannot have (?member_ids=, default the household)
+	memberIDs, err := memberIDsParam(r)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.Recipes.AttachConflicts(recipes, memberIDs); err != nil {
+		writeError(w, err)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -39,88 +72,52 @@ func (h *RecipeHandler) GetRecipes(w http.ResponseWriter, r *http.Request) {
 func (h *RecipeHandler) CreateRecipe(w http.ResponseWriter, r *http.Request) {
 	var req models.Recipe
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		jsonError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	tx, err := h.DB.Begin()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	if req.Servings <= 0 {
+		req.Servings = 1
 	}
-	defer tx.Rollback()
-
-	var recipeID int
-	err = tx.QueryRow("INSERT INTO recipes (name, instructions) VALUES ($1, $2) RETURNING id", req.Name, req.Instructions).Scan(&recipeID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	var v validator
+	v.check(strings.TrimSpace(req.Name) != "", "name", "is required")
+	v.check(len(req.Name) <= 255, "name", "must be at most 255 characters")
+	if v.failed(w) {
 		return
 	}
-
-	// Insert ingredients if provided
-	if len(req.Ingredients) > 0 {
-		stmt, err := tx.Prepare("INSERT INTO recipe_ingredients (recipe_id, ingredient_id, quantity) VALUES ($1, $2, $3)")
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	for i := range req.Steps {
+		if err := validateStep(&req.Steps[i]); err != nil {
+			jsonError(w, fmt.Sprintf("step %d: %v", i+1, err), http.StatusBadRequest)
 			return
 		}
-		defer stmt.Close()
-
-		for _, ing := range req.Ingredients {
-			if _, err := stmt.Exec(recipeID, ing.IngredientID, ing.Quantity); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-		}
 	}
 
-	if err := tx.Commit(); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	// Ingredients and steps are saved with the recipe
+	if err := h.Recipes.CreateRecipe(&req); err != nil {
+		writeError(w, err)
 		return
 	}
 
-	req.ID = recipeID
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(req)
 }
 
 func (h *RecipeHandler) GetRecipeIngredients(w http.ResponseWriter, r *http.Request) {
-	recipeID := r.URL.Query().Get("recipe_id")
-	if recipeID == "" {
-		http.Error(w, "recipe_id required", http.StatusBadRequest)
+	if r.URL.Query().Get("recipe_id") == "" {
+		jsonError(w, "recipe_id required", http.StatusBadRequest)
 		return
 	}
-
-	rows, err := h.DB.Query(`
-		SELECT ri.ingredient_id, i.name, ri.quantity, COALESCE(i.unit, '') as unit, COALESCE(i.price, 0) as price, i.is_tracked
-		FROM recipe_ingredients ri
-		JOIN ingredients i ON ri.ingredient_id = i.id
-		WHERE ri.recipe_id = $1
-	`, recipeID)
+	recipeID, err := strconv.Atoi(r.URL.Query().Get("recipe_id"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		jsonError(w, "Invalid recipe_id", http.StatusBadRequest)
 		return
 	}
-	defer rows.Close()
 
-	type IngredientDetail struct {
-		IngredientID int     `json:"ingredient_id"`
-		Name         string  `json:"name"`
-		Quantity     float64 `json:"quantity"`
-		Unit         string  `json:"unit"`
-		Price        float64 `json:"price"`
-		IsTracked    bool    `json:"is_tracked"`
-	}
-
-	var ingredients []IngredientDetail
-	for rows.Next() {
-		var ing IngredientDetail
-		if err := rows.Scan(&ing.IngredientID, &ing.Name, &ing.Quantity, &ing.Unit, &ing.Price, &ing.IsTracked); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		ingredients = append(ingredients, ing)
+	ingredients, err := h.Recipes.ListRecipeIngredients(recipeID)
+	if err != nil {
+		writeError(w, err)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -136,55 +133,53 @@ func (h *RecipeHandler) AddRecipeIngredient(w http.ResponseWriter, r *http.Reque
 		IsTracked      *bool   `json:"is_tracked"` // Optional, default true
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var v validator
+	v.check(req.RecipeID > 0, "recipe_id", "is required")
+	v.check(req.IngredientID > 0 || strings.TrimSpace(req.IngredientName) != "", "ingredient_name", "ingredient_id or ingredient_name is required")
+	v.check(req.Quantity > 0, "quantity", "must be positive")
+	if v.failed(w) {
 		return
 	}
 
 	// If no ingredient_id but a name is given, find or create the ingredient
+	var similar []models.IngredientMatch
 	if req.IngredientID == 0 && req.IngredientName != "" {
-		// First try to find existing ingredient by name
-		err := h.DB.QueryRow(
-			"SELECT id FROM ingredients WHERE LOWER(name) = LOWER($1)",
-			req.IngredientName,
-		).Scan(&req.IngredientID)
+		isTracked := true
+		if req.IsTracked != nil {
+			isTracked = *req.IsTracked
+		}
 
-		// If not found, create it
+		id, created, err := h.Ingredients.FindOrCreateIngredient(req.IngredientName, "", isTracked)
 		if err != nil {
-			isTracked := true
-			if req.IsTracked != nil {
-				isTracked = *req.IsTracked
-			}
+			writeError(w, err)
+			return
+		}
+		req.IngredientID = id
 
-			err = h.DB.QueryRow(
-				"INSERT INTO ingredients (name, current_stock, price, is_tracked) VALUES ($1, 0, NULL, $2) RETURNING id",
-				req.IngredientName, isTracked,
-			).Scan(&req.IngredientID)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+		// A new ingredient may be a duplicate under another spelling
+		if created {
+			if similar, err = h.Ingredients.SuggestIngredients(req.IngredientName, 5, id); err != nil {
+				writeError(w, err)
 				return
 			}
 		}
 	}
 
-	if req.IngredientID == 0 {
-		http.Error(w, "ingredient_id or ingredient_name required", http.StatusBadRequest)
+	if err := h.Recipes.SetRecipeIngredient(req.RecipeID, req.IngredientID, req.Quantity); err != nil {
+		writeError(w, err)
 		return
 	}
 
-	_, err := h.DB.Exec(
-		`INSERT INTO recipe_ingredients (recipe_id, ingredient_id, quantity) 
-		 VALUES ($1, $2, $3)
-		 ON CONFLICT (recipe_id, ingredient_id) 
-		 DO UPDATE SET quantity = EXCLUDED.quantity`,
-		req.RecipeID, req.IngredientID, req.Quantity,
-	)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	resp := map[string]interface{}{"status": "added", "ingredient_id": req.IngredientID}
+	if len(similar) > 0 {
+		resp["similar"] = similar
 	}
-
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "added"})
+	json.NewEncoder(w).Encode(resp)
 }
 
 func (h *RecipeHandler) RemoveRecipeIngredient(w http.ResponseWriter, r *http.Request) {
@@ -193,13 +188,12 @@ func (h *RecipeHandler) RemoveRecipeIngredient(w http.ResponseWriter, r *http.Re
 		IngredientID int `json:"ingredient_id"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		jsonError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	_, err := h.DB.Exec("DELETE FROM recipe_ingredients WHERE recipe_id = $1 AND ingredient_id = $2", req.RecipeID, req.IngredientID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := h.Recipes.RemoveRecipeIngredient(req.RecipeID, req.IngredientID); err != nil {
+		writeError(w, err)
 		return
 	}
 
@@ -212,13 +206,12 @@ func (h *RecipeHandler) DeleteRecipe(w http.ResponseWriter, r *http.Request) {
 		ID int `json:"id"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		jsonError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	_, err := h.DB.Exec("DELETE FROM recipes WHERE id = $1", req.ID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := h.Recipes.DeleteRecipe(req.ID); err != nil {
+		writeError(w, err)
 		return
 	}
 
@@ -226,29 +219,55 @@ func (h *RecipeHandler) DeleteRecipe(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
 }
 
+// UpdateRecipeName updates the name, notes and servings present in the body; the rest are kept.
 func (h *RecipeHandler) UpdateRecipeName(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		ID    int    `json:"id"`
-		Name  string `json:"name"`
-		Notes string `json:"notes"`
+		ID       int    `json:"id"`
+		Name     string `json:"name"`
+		Notes    string `json:"notes"`
+		Servings *int   `json:"servings"` // Optional, unchanged when omitted
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if req.Name == "" {
-		http.Error(w, "name is required", http.StatusBadRequest)
+	if err := json.Unmarshal(body, &req); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	result, err := h.DB.Exec("UPDATE recipes SET name = $1, notes = $2 WHERE id = $3", req.Name, req.Notes, req.ID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	recipe, err := h.Recipes.GetRecipe(req.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		jsonError(w, "Recipe not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(w, err)
+		return
+	}
+	req.Name, req.Notes = recipe.Name, recipe.Notes
+	// Decoding again over the stored values leaves omitted fields as they are
+	if err := json.Unmarshal(body, &req); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		http.Error(w, "Recipe not found", http.StatusNotFound)
+	var v validator
+	v.check(strings.TrimSpace(req.Name) != "", "name", "is required")
+	v.check(len(req.Name) <= 255, "name", "must be at most 255 characters")
+	v.check(req.Servings == nil || *req.Servings > 0, "servings", "must be positive")
+	if v.failed(w) {
+		return
+	}
+
+	recipe.Name, recipe.Notes = req.Name, req.Notes
+	if req.Servings != nil {
+		recipe.Servings = *req.Servings
+	}
+	err = h.Recipes.UpdateRecipe(recipe)
+	if errors.Is(err, sql.ErrNoRows) {
+		jsonError(w, "Recipe not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(w, err)
 		return
 	}
 
@@ -263,25 +282,20 @@ func (h *RecipeHandler) UpdateIngredientQuantity(w http.ResponseWriter, r *http.
 		Quantity     float64 `json:"quantity"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		jsonError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	if req.Quantity <= 0 {
-		http.Error(w, "quantity must be positive", http.StatusBadRequest)
+		jsonError(w, "quantity must be positive", http.StatusBadRequest)
 		return
 	}
 
-	result, err := h.DB.Exec(
-		"UPDATE recipe_ingredients SET quantity = $1 WHERE recipe_id = $2 AND ingredient_id = $3",
-		req.Quantity, req.RecipeID, req.IngredientID,
-	)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	err := h.Recipes.UpdateRecipeIngredient(req.RecipeID, req.IngredientID, req.Quantity)
+	if errors.Is(err, sql.ErrNoRows) {
+		jsonError(w, "Ingredient not found in recipe", http.StatusNotFound)
 		return
-	}
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		http.Error(w, "Ingredient not found in recipe", http.StatusNotFound)
+	} else if err != nil {
+		writeError(w, err)
 		return
 	}
 
@@ -0,0 +1,570 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Kano-Chien/house_management/backend/models"
+)
+
+// maxRecipeDepth matches the nesting cap of the recipe_ingredients_expanded view.
+const maxRecipeDepth = 10
+
+func loadRecipeComponents(q queryer, recipeID int) ([]models.RecipeComponent, error) {
+	rows, err := q.Query(`
+		SELECT rc.component_recipe_id, c.name, rc.quantity, rc.unit, COALESCE(c.servings, 1)
+		FROM recipe_components rc
+		JOIN recipes c ON rc.component_recipe_id = c.id
+		WHERE rc.recipe_id = $1
+		ORDER BY c.name
+	`, recipeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	components := []models.RecipeComponent{}
+	for rows.Next() {
+		var c models.RecipeComponent
+		if err := rows.Scan(&c.ComponentRecipeID, &c.Name, &c.Quantity, &c.Unit, &c.Servings); err != nil {
+			return nil, err
+		}
+		components = append(components, c)
+	}
+	return components, rows.Err()
+}
+
+// consumeRecipe takes `batches` batches of a recipe out of stock following
+// planRecipe and returns the substitutes that were used.
+func consumeRecipe(tx *sql.Tx, recipeID int, batches float64) ([]models.Substitution, error) {
+	plan := newCookPlan()
+	if err := planRecipe(newStockLedger(tx), plan, recipeID, batches, 0); err != nil {
+		return nil, err
+	}
+
+	// Decrease stock in ID order, allowing negative
+	ids := make([]int, 0, len(plan.uses))
+	for id := range plan.uses {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		if _, err := tx.Exec("UPDATE ingredients SET current_stock = current_stock - $1 WHERE id = $2", plan.uses[id], id); err != nil {
+			return nil, err
+		}
+	}
+	for componentID, servings := range plan.prepared {
+		if _, err := takePreparedServings(tx, componentID, servings); err != nil {
+			return nil, err
+		}
+	}
+	return plan.substitutions, nil
+}
+
+// stockLedger tracks what is left in stock while a cooking plan is made, so an
+// ingredient used by several components or as a substitute is not counted twice.
+type stockLedger struct {
+	q        queryer
+	stock    map[int]float64 // Ingredient ID -> stock left
+	prepared map[int]float64 // Recipe ID -> prepared servings left
+}
+
+func newStockLedger(q queryer) *stockLedger {
+	return &stockLedger{q: q, stock: map[int]float64{}, prepared: map[int]float64{}}
+}
+
+// available returns the stock left of an ingredient, recording the database value on first use.
+func (l *stockLedger) available(id int, stock float64) float64 {
+	if _, ok := l.stock[id]; !ok {
+		l.stock[id] = stock
+	}
+	return math.Max(l.stock[id], 0)
+}
+
+func (l *stockLedger) preparedServings(recipeID int) (float64, error) {
+	if v, ok := l.prepared[recipeID]; ok {
+		return v, nil
+	}
+	var v float64
+	err := l.q.QueryRow(`
+		SELECT COALESCE(SUM(servings), 0) FROM prepared_foods
+		WHERE recipe_id = $1 AND servings > 0 AND (expiry_date IS NULL OR expiry_date >= $2::DATE)
+	`, recipeID, today()).Scan(&v)
+	l.prepared[recipeID] = v
+	return v, err
+}
+
+// cookPlan is what cooking a recipe takes out of stock.
+type cookPlan struct {
+	uses          map[int]float64 // Ingredient ID -> quantity, may exceed stock
+	prepared      map[int]float64 // Component recipe ID -> prepared servings
+	missing       []models.MissingIngredient
+	substitutions []models.Substitution
+}
+
+func newCookPlan() *cookPlan {
+	return &cookPlan{uses: map[int]float64{}, prepared: map[int]float64{}}
+}
+
+func (p *cookPlan) use(l *stockLedger, id int, quantity float64) {
+	p.uses[id] += quantity
+	l.stock[id] -= quantity
+}
+
+// planRecipe plans cooking `batches` batches of a recipe. Tracked ingredients
+// come from stock; when one runs short its substitutes (recipe-specific rules
+// first) cover what they can and the rest is recorded as missing. Components
+// are taken from prepared food first and only the missing part is cooked from
+// their own ingredients.
+func planRecipe(l *stockLedger, plan *cookPlan, recipeID int, batches float64, depth int) error {
+	if depth > maxRecipeDepth {
+		return &APIError{
+			Status:  http.StatusUnprocessableEntity,
+			Code:    "nesting_too_deep",
+			Message: fmt.Sprintf("Sub-recipes are nested more than %d levels deep", maxRecipeDepth),
+		}
+	}
+
+	type line struct {
+		id          int
+		name, unit  string
+		need, stock float64
+	}
+	rows, err := l.q.Query(`
+		SELECT ri.ingredient_id, i.name, COALESCE(i.unit, ''), ri.quantity * $2, i.current_stock
+		FROM recipe_ingredients ri
+		JOIN ingredients i ON ri.ingredient_id = i.id
+		WHERE ri.recipe_id = $1 AND i.is_tracked = TRUE
+		ORDER BY i.name
+	`, recipeID, batches)
+	if err != nil {
+		return err
+	}
+	var lines []line
+	for rows.Next() {
+		var ln line
+		if err := rows.Scan(&ln.id, &ln.name, &ln.unit, &ln.need, &ln.stock); err != nil {
+			rows.Close()
+			return err
+		}
+		lines = append(lines, ln)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	subs, err := loadSubstitutes(l.q, recipeID)
+	if err != nil {
+		return err
+	}
+
+	for _, ln := range lines {
+		take := math.Min(l.available(ln.id, ln.stock), ln.need)
+		plan.use(l, ln.id, take)
+		short := ln.need - take
+		for _, s := range subs[ln.id] {
+			if short <= 1e-9 {
+				break
+			}
+			qty := math.Min(l.available(s.SubstituteID, s.stock), short*s.Ratio)
+			if qty <= 1e-9 {
+				continue
+			}
+			plan.use(l, s.SubstituteID, qty)
+			plan.substitutions = append(plan.substitutions, models.Substitution{
+				IngredientID: ln.id, IngredientName: ln.name,
+				SubstituteID: s.SubstituteID, SubstituteName: s.SubstituteName,
+				Replaced: qty / s.Ratio, Quantity: qty,
+			})
+			short -= qty / s.Ratio
+		}
+		if short > 1e-9 {
+			plan.use(l, ln.id, short)
+			plan.missing = append(plan.missing, models.MissingIngredient{IngredientID: ln.id, Name: ln.name, Quantity: short, Unit: ln.unit})
+		}
+	}
+
+	type component struct {
+		recipeID int
+		servings float64 // Servings needed
+		perBatch float64 // Servings one batch of the component makes
+	}
+	rows, err = l.q.Query(`
+		SELECT rc.component_recipe_id, rc.quantity, rc.unit, GREATEST(COALESCE(c.servings, 1), 1)
+		FROM recipe_components rc
+		JOIN recipes c ON rc.component_recipe_id = c.id
+		WHERE rc.recipe_id = $1
+	`, recipeID)
+	if err != nil {
+		return err
+	}
+	var components []component
+	for rows.Next() {
+		var c component
+		var quantity float64
+		var unit string
+		if err := rows.Scan(&c.recipeID, &quantity, &unit, &c.perBatch); err != nil {
+			rows.Close()
+			return err
+		}
+		c.servings = quantity * batches
+		if unit == "batch" {
+			c.servings *= c.perBatch
+		}
+		components = append(components, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, c := range components {
+		available, err := l.preparedServings(c.recipeID)
+		if err != nil {
+			return err
+		}
+		if taken := math.Min(available, c.servings); taken > 0 {
+			l.prepared[c.recipeID] -= taken
+			plan.prepared[c.recipeID] += taken
+			c.servings -= taken
+		}
+		if c.servings > 1e-9 {
+			if err := planRecipe(l, plan, c.recipeID, c.servings/c.perBatch, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// takePreparedServings consumes up to `servings` of prepared food for a recipe,
+// leftovers first and then soonest-expiring, and returns how much was taken.
+func takePreparedServings(tx *sql.Tx, recipeID int, servings float64) (float64, error) {
+	rows, err := tx.Query(`
+		SELECT id, servings FROM prepared_foods
+		WHERE recipe_id = $1 AND servings > 0 AND (expiry_date IS NULL OR expiry_date >= $2::DATE)
+		ORDER BY kind = 'leftover' DESC, expiry_date NULLS LAST, prepared_on, id
+		FOR UPDATE
+	`, recipeID, today())
+	if err != nil {
+		return 0, err
+	}
+	type lot struct {
+		id       int
+		servings float64
+	}
+	var lots []lot
+	for rows.Next() {
+		var l lot
+		if err := rows.Scan(&l.id, &l.servings); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		lots = append(lots, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	taken := 0.0
+	for _, l := range lots {
+		if taken >= servings {
+			break
+		}
+		take := math.Min(l.servings, servings-taken)
+		if take == l.servings {
+			_, err = tx.Exec("DELETE FROM prepared_foods WHERE id = $1", l.id)
+		} else {
+			_, err = tx.Exec("UPDATE prepared_foods SET servings = servings - $1 WHERE id = $2", take, l.id)
+		}
+		if err != nil {
+			return 0, err
+		}
+		taken += take
+	}
+	return taken, nil
+}
+
+func (h *RecipeHandler) GetRecipeComponents(w http.ResponseWriter, r *http.Request) {
+	recipeID, err := strconv.Atoi(r.URL.Query().Get("recipe_id"))
+	if err != nil {
+		jsonError(w, "recipe_id required", http.StatusBadRequest)
+		return
+	}
+
+	components, err := loadRecipeComponents(h.DB, recipeID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(components)
+}
+
+func (h *RecipeHandler) AddRecipeComponent(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RecipeID          int     `json:"recipe_id"`
+		ComponentRecipeID int     `json:"component_recipe_id"`
+		Quantity          float64 `json:"quantity"`
+		Unit              string  `json:"unit"` // serving (default) or batch
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Quantity <= 0 {
+		jsonError(w, "quantity must be positive", http.StatusBadRequest)
+		return
+	}
+	if req.Unit == "" {
+		req.Unit = "serving"
+	}
+	if req.Unit != "serving" && req.Unit != "batch" {
+		jsonError(w, "unit must be serving or batch", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// A recipe cannot contain itself, directly or through its components
+	var cycle bool
+	err = tx.QueryRow(`
+		WITH RECURSIVE reachable(id) AS (
+			SELECT $1::INTEGER
+			UNION
+			SELECT rc.component_recipe_id FROM recipe_components rc JOIN reachable ON rc.recipe_id = reachable.id
+		)
+		SELECT EXISTS (SELECT 1 FROM reachable WHERE id = $2::INTEGER)
+	`, req.ComponentRecipeID, req.RecipeID).Scan(&cycle)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if cycle {
+		jsonError(w, "Adding this component would make the recipe contain itself", http.StatusConflict)
+		return
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO recipe_components (recipe_id, component_recipe_id, quantity, unit)
+		 VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (recipe_id, component_recipe_id)
+		 DO UPDATE SET quantity = EXCLUDED.quantity, unit = EXCLUDED.unit`,
+		req.RecipeID, req.ComponentRecipeID, req.Quantity, req.Unit,
+	)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "added"})
+}
+
+func (h *RecipeHandler) RemoveRecipeComponent(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RecipeID          int `json:"recipe_id"`
+		ComponentRecipeID int `json:"component_recipe_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err := h.DB.Exec("DELETE FROM recipe_components WHERE recipe_id = $1 AND component_recipe_id = $2", req.RecipeID, req.ComponentRecipeID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "removed"})
+}
+
+// GetRecipeCost prices one batch of a recipe with its components expanded.
+func (h *RecipeHandler) GetRecipeCost(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		jsonError(w, "id required", http.StatusBadRequest)
+		return
+	}
+
+	var servings int
+	err = h.DB.QueryRow("SELECT COALESCE(servings, 1) FROM recipes WHERE id = $1", id).Scan(&servings)
+	if err == sql.ErrNoRows {
+		jsonError(w, "Recipe not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	rows, err := h.DB.Query(`
+		SELECT e.ingredient_id, i.name, e.quantity, COALESCE(i.unit, ''), COALESCE(i.price, 0)
+		FROM recipe_ingredients_expanded e
+		JOIN ingredients i ON e.ingredient_id = i.id
+		WHERE e.recipe_id = $1
+		ORDER BY i.name
+	`, id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	type CostLine struct {
+		IngredientID int     `json:"ingredient_id"`
+		Name         string  `json:"name"`
+		Quantity     float64 `json:"quantity"`
+		Unit         string  `json:"unit"`
+		Price        float64 `json:"price"`
+		Cost         float64 `json:"cost"`
+	}
+	lines := []CostLine{}
+	total := 0.0
+	for rows.Next() {
+		var l CostLine
+		if err := rows.Scan(&l.IngredientID, &l.Name, &l.Quantity, &l.Unit, &l.Price); err != nil {
+			writeError(w, err)
+			return
+		}
+		l.Cost = l.Price * l.Quantity
+		total += l.Cost
+		lines = append(lines, l)
+	}
+	if servings < 1 {
+		servings = 1
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"recipe_id":        id,
+		"servings":         servings,
+		"ingredients":      lines,
+		"total_cost":       total,
+		"cost_per_serving": total / float64(servings),
+	})
+}
+
+// PrepareRecipe cooks batches of a recipe in advance: its ingredients are
+// consumed now and the servings are kept as prepared food for later meals.
+func (h *RecipeHandler) PrepareRecipe(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RecipeID   int     `json:"recipe_id"`
+		Batches    float64 `json:"batches"`     // Default 1
+		ExpiryDate string  `json:"expiry_date"` // Optional, YYYY-MM-DD
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Batches == 0 {
+		req.Batches = 1
+	}
+	if req.Batches < 0 {
+		jsonError(w, "batches must be positive", http.StatusBadRequest)
+		return
+	}
+	var expiry *time.Time
+	if req.ExpiryDate != "" {
+		t, err := time.Parse("2006-01-02", req.ExpiryDate)
+		if err != nil {
+			jsonError(w, "Invalid date format. Use YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		expiry = &t
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	var servings int
+	err = tx.QueryRow("SELECT GREATEST(COALESCE(servings, 1), 1) FROM recipes WHERE id = $1", req.RecipeID).Scan(&servings)
+	if err == sql.ErrNoRows {
+		jsonError(w, "Recipe not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	substitutions, err := consumeRecipe(tx, req.RecipeID, req.Batches)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	food := models.PreparedFood{RecipeID: req.RecipeID, Kind: "batch", Servings: req.Batches * float64(servings), ExpiryDate: expiry}
+	err = tx.QueryRow(
+		"INSERT INTO prepared_foods (recipe_id, servings, expiry_date, prepared_on) VALUES ($1, $2, $3, $4) RETURNING id, prepared_on",
+		food.RecipeID, food.Servings, food.ExpiryDate, today(),
+	).Scan(&food.ID, &food.PreparedOn)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		models.PreparedFood
+		Substitutions []models.Substitution `json:"substitutions,omitempty"`
+	}{food, substitutions})
+}
+
+// GetPreparedFoods lists prepared food in stock, optionally filtered by ?kind=batch or ?kind=leftover.
+func (h *RecipeHandler) GetPreparedFoods(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.Query(`
+		SELECT p.id, p.recipe_id, r.name, p.kind, p.meal_plan_id, p.servings, p.prepared_on, p.expiry_date
+		FROM prepared_foods p
+		JOIN recipes r ON p.recipe_id = r.id
+		WHERE p.servings > 0 AND ($1 = '' OR p.kind = $1)
+		ORDER BY p.expiry_date NULLS LAST, p.prepared_on
+	`, r.URL.Query().Get("kind"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	var foods []models.PreparedFood
+	for rows.Next() {
+		var f models.PreparedFood
+		if err := rows.Scan(&f.ID, &f.RecipeID, &f.RecipeName, &f.Kind, &f.MealPlanID, &f.Servings, &f.PreparedOn, &f.ExpiryDate); err != nil {
+			writeError(w, err)
+			return
+		}
+		foods = append(foods, f)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(foods)
+}
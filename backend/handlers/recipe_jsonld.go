@@ -0,0 +1,302 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Kano-Chien/house_management/backend/models"
+	"github.com/Kano-Chien/house_management/backend/parser"
+)
+
+// Import of schema.org/Recipe data as embedded by most recipe websites.
+// Works on saved HTML files, nothing is fetched from the network.
+
+var ldJSONScript = regexp.MustCompile(`(?is)<script[^>]*type\s*=\s*["']?application/ld\+json["']?[^>]*>(.*?)</script>`)
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+var htmlLineBreak = regexp.MustCompile(`(?i)<br\s*/?>|</p>|</li>`)
+var yieldNumber = regexp.MustCompile(`\d+`)
+
+type UnmatchedLine struct {
+	Line   string `json:"line"`
+	Reason string `json:"reason"`
+}
+
+type RecipeImportResult struct {
+	Recipe    models.Recipe   `json:"recipe"`
+	Unmatched []UnmatchedLine `json:"unmatched"` // Ingredient lines that need manual review
+}
+
+// schemaRecipe holds the schema.org/Recipe properties we import. Most of them
+// come in several shapes in the wild, so they are decoded lazily.
+type schemaRecipe struct {
+	Name         string
+	Yield        string
+	Servings     int // First number in Yield, 0 if it has none
+	URL          string
+	Instructions []string
+	Ingredients  []string
+}
+
+func (h *RecipeHandler) ImportSchemaRecipe(w http.ResponseWriter, r *http.Request) {
+	var data []byte
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, ferr := r.FormFile("file")
+		if ferr != nil {
+			jsonError(w, ferr.Error(), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		data, err = io.ReadAll(file)
+	} else {
+		data, err = io.ReadAll(r.Body)
+	}
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sr, err := extractSchemaRecipe(data)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// A yield without a number, such as "one loaf", is kept as a note
+	var notes []string
+	if sr.Servings == 0 && sr.Yield != "" {
+		notes = append(notes, "Yield: "+sr.Yield)
+	}
+	if sr.URL != "" {
+		notes = append(notes, "Source: "+sr.URL)
+	}
+
+	servings := max(sr.Servings, 1)
+	result := RecipeImportResult{
+		Recipe: models.Recipe{
+			Name:         sr.Name,
+			Servings:     servings,
+			Instructions: numberSteps(sr.Instructions),
+			Notes:        strings.Join(notes, "\n"),
+			Ingredients:  []models.RecipeIngredient{},
+		},
+		Unmatched: []UnmatchedLine{},
+	}
+	err = tx.QueryRow(
+		"INSERT INTO recipes (name, instructions, notes, servings) VALUES ($1, $2, $3, $4) RETURNING id",
+		result.Recipe.Name, result.Recipe.Instructions, result.Recipe.Notes, servings,
+	).Scan(&result.Recipe.ID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	for i, text := range sr.Instructions {
+		step := models.RecipeStep{RecipeID: result.Recipe.ID, Position: i + 1, Text: text, TemperatureUnit: "C", IngredientIDs: []int{}}
+		if err := insertStep(tx, &step); err != nil {
+			writeError(w, err)
+			return
+		}
+		result.Recipe.Steps = append(result.Recipe.Steps, step)
+	}
+
+	for _, line := range sr.Ingredients {
+		ing, ok := parser.ParseIngredientLine(line)
+		if !ok {
+			result.Unmatched = append(result.Unmatched, UnmatchedLine{Line: line, Reason: "no quantity"})
+			continue
+		}
+
+		added, reason, err := addParsedIngredient(tx, result.Recipe.ID, ing, true)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if reason != "" {
+			result.Unmatched = append(result.Unmatched, UnmatchedLine{Line: line, Reason: reason})
+			continue
+		}
+		result.Recipe.Ingredients = append(result.Recipe.Ingredients, added)
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(result)
+}
+
+// extractSchemaRecipe accepts raw JSON-LD or an HTML page containing
+// <script type="application/ld+json"> blocks and returns the first Recipe found.
+func extractSchemaRecipe(data []byte) (*schemaRecipe, error) {
+	var blocks [][]byte
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		blocks = append(blocks, []byte(trimmed))
+	} else {
+		for _, m := range ldJSONScript.FindAllStringSubmatch(string(data), -1) {
+			block := strings.TrimSpace(m[1])
+			block = strings.TrimPrefix(block, "<!--")
+			block = strings.TrimSuffix(block, "-->")
+			block = strings.TrimPrefix(strings.TrimSpace(block), "//<![CDATA[")
+			block = strings.TrimSuffix(strings.TrimSpace(block), "//]]>")
+			blocks = append(blocks, []byte(block))
+		}
+		if len(blocks) == 0 {
+			return nil, fmt.Errorf("no application/ld+json script found in document")
+		}
+	}
+
+	for _, block := range blocks {
+		var doc interface{}
+		if err := json.Unmarshal(block, &doc); err != nil {
+			// Broken blocks are common on real pages, another one may hold the recipe
+			continue
+		}
+		if node := findRecipeNode(doc); node != nil {
+			sr := &schemaRecipe{
+				Name:         cleanText(stringValue(node["name"])),
+				Yield:        cleanText(stringValue(node["recipeYield"])),
+				URL:          stringValue(node["url"]),
+				Instructions: instructionSteps(node["recipeInstructions"]),
+			}
+			if n, err := strconv.Atoi(yieldNumber.FindString(sr.Yield)); err == nil && n > 0 {
+				sr.Servings = n
+			}
+			ingredients := node["recipeIngredient"]
+			if ingredients == nil {
+				ingredients = node["ingredients"] // Older schema.org name
+			}
+			for _, line := range stringList(ingredients) {
+				if line = cleanText(line); line != "" {
+					sr.Ingredients = append(sr.Ingredients, line)
+				}
+			}
+			if sr.Name == "" {
+				return nil, fmt.Errorf("recipe has no name")
+			}
+			return sr, nil
+		}
+	}
+	return nil, fmt.Errorf("no schema.org Recipe found")
+}
+
+// findRecipeNode walks objects, arrays and @graph lists looking for @type Recipe.
+func findRecipeNode(v interface{}) map[string]interface{} {
+	switch node := v.(type) {
+	case []interface{}:
+		for _, item := range node {
+			if found := findRecipeNode(item); found != nil {
+				return found
+			}
+		}
+	case map[string]interface{}:
+		for _, t := range stringList(node["@type"]) {
+			if t == "Recipe" || strings.HasSuffix(t, "/Recipe") {
+				return node
+			}
+		}
+		if graph, ok := node["@graph"]; ok {
+			return findRecipeNode(graph)
+		}
+	}
+	return nil
+}
+
+// instructionSteps flattens recipeInstructions, which may be a plain string,
+// a list of strings, HowToStep objects or HowToSection objects holding steps.
+func instructionSteps(v interface{}) []string {
+	var steps []string
+	switch node := v.(type) {
+	case string:
+		for _, line := range strings.Split(cleanBlock(node), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				steps = append(steps, line)
+			}
+		}
+	case []interface{}:
+		for _, item := range node {
+			steps = append(steps, instructionSteps(item)...)
+		}
+	case map[string]interface{}:
+		if items, ok := node["itemListElement"]; ok {
+			return instructionSteps(items)
+		}
+		if text := cleanText(stringValue(node["text"])); text != "" {
+			steps = append(steps, text)
+		} else if name := cleanText(stringValue(node["name"])); name != "" {
+			steps = append(steps, name)
+		}
+	}
+	return steps
+}
+
+func numberSteps(steps []string) string {
+	var sb strings.Builder
+	for i, step := range steps {
+		fmt.Fprintf(&sb, "%d. %s\n", i+1, step)
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// stringValue reads a property that may be a string, a number or a list of either.
+func stringValue(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case float64:
+		return formatFloat(val)
+	case []interface{}:
+		if len(val) > 0 {
+			return stringValue(val[0])
+		}
+	}
+	return ""
+}
+
+func stringList(v interface{}) []string {
+	switch val := v.(type) {
+	case string:
+		return []string{val}
+	case []interface{}:
+		var list []string
+		for _, item := range val {
+			if s := stringValue(item); s != "" {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// cleanText strips markup and entities and collapses whitespace.
+func cleanText(s string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(htmlTag.ReplaceAllString(s, " "))), " ")
+}
+
+// cleanBlock is cleanText for multi-line text, keeping line breaks.
+func cleanBlock(s string) string {
+	s = htmlLineBreak.ReplaceAllString(s, "\n")
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		lines = append(lines, cleanText(line))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testdata/recipe.html is a saved recipe page: a broken ld+json block first,
+// then the recipe inside an @graph with its steps grouped in HowToSections.
+func TestExtractSchemaRecipe(t *testing.T) {
+	page, err := os.ReadFile("testdata/recipe.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr, err := extractSchemaRecipe(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &schemaRecipe{
+		Name:     "Weeknight & Curry",
+		Yield:    "4",
+		Servings: 4,
+		URL:      "https://kitchen.example/curry/",
+		Instructions: []string{
+			"Rinse the rice.",
+			"Dice the chicken.",
+			"Brown the chicken with the curry powder.",
+			"Simmer for 20 minutes.",
+		},
+		Ingredients: []string{"2 1/2 cups rice", "200 g chicken thighs, diced", "1 tbsp curry powder", "salt to taste"},
+	}
+	if !reflect.DeepEqual(sr, want) {
+		t.Errorf("got %#v\nwant %#v", sr, want)
+	}
+
+	// Raw JSON-LD with the older property names and plain string instructions
+	sr, err = extractSchemaRecipe([]byte(`[{"@type": "http://schema.org/Recipe", "name": "Toast",
+		"recipeYield": "one slice", "ingredients": "1 slice bread", "recipeInstructions": "<p>Toast the bread.</p><p>Butter it.</p>"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sr.Name != "Toast" || sr.Servings != 0 || len(sr.Ingredients) != 1 || !reflect.DeepEqual(sr.Instructions, []string{"Toast the bread.", "Butter it."}) {
+		t.Errorf("raw JSON-LD: %+v", sr)
+	}
+
+	for _, doc := range []string{
+		"<html><body>No structured data</body></html>",
+		`<script type="application/ld+json">{"@type": "Article", "name": "News"}</script>`,
+		`{"@type": "Recipe", "recipeIngredient": ["1 egg"]}`,
+	} {
+		if sr, err := extractSchemaRecipe([]byte(doc)); err == nil {
+			t.Errorf("extractSchemaRecipe(%q) = %+v, want an error", doc, sr)
+		}
+	}
+}
+
+func TestImportSchemaRecipe(t *testing.T) {
+	db := newTestDB(t)
+	h := &RecipeHandler{DB: db}
+	// Chicken is counted in pieces, so the recipe's grams cannot be used
+	if _, err := db.Exec("INSERT INTO ingredients (name, unit) VALUES ('chicken thighs', 'pcs')"); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := os.ReadFile("testdata/recipe.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/api/recipes/import", strings.NewReader(string(page)))
+	r.Header.Set("Content-Type", "text/html")
+	h.ImportSchemaRecipe(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+
+	var result RecipeImportResult
+	decode(t, w, &result)
+	if result.Recipe.Servings != 4 || result.Recipe.Notes != "Source: https://kitchen.example/curry/" ||
+		len(result.Recipe.Steps) != 4 || len(result.Recipe.Ingredients) != 2 {
+		t.Errorf("recipe = %+v", result.Recipe)
+	}
+	if len(result.Unmatched) != 2 ||
+		result.Unmatched[0].Line != "200 g chicken thighs, diced" || !strings.HasPrefix(result.Unmatched[0].Reason, "unit mismatch") ||
+		result.Unmatched[1] != (UnmatchedLine{Line: "salt to taste", Reason: "no quantity"}) {
+		t.Errorf("unmatched = %+v", result.Unmatched)
+	}
+
+	var steps, servings int
+	db.QueryRow("SELECT COUNT(*) FROM recipe_steps WHERE recipe_id = $1", result.Recipe.ID).Scan(&steps)
+	db.QueryRow("SELECT servings FROM recipes WHERE id = $1", result.Recipe.ID).Scan(&servings)
+	if steps != 4 || servings != 4 {
+		t.Errorf("%d steps and %d servings saved, want 4 and 4", steps, servings)
+	}
+}
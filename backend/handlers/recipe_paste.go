@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Kano-Chien/house_management/backend/models"
+	"github.com/Kano-Chien/house_management/backend/parser"
+)
+
+// addParsedIngredient adds a parsed ingredient line to a recipe, creating the
+// ingredient when needed. A non-empty reason means the line was not added and
+// needs review. With accumulate, a repeated ingredient adds to the quantity
+// instead of replacing it.
+func addParsedIngredient(tx *sql.Tx, recipeID int, ing parser.Ingredient, accumulate bool) (models.RecipeIngredient, string, error) {
+	id, _, err := findOrCreateIngredient(tx, ing.Name, ing.Unit, true)
+	if err != nil {
+		return models.RecipeIngredient{}, "", err
+	}
+
+	// Stock is counted in the ingredient's own unit, so a different unit cannot be added as-is
+	// The name is the ingredient's own, which may differ from the line's when it matched an alias
+	var name, stockUnit string
+	if err := tx.QueryRow("SELECT name, COALESCE(unit, '') FROM ingredients WHERE id = $1", id).Scan(&name, &stockUnit); err != nil {
+		return models.RecipeIngredient{}, "", err
+	}
+	if stockUnit != "" && ing.Unit != "" && !strings.EqualFold(stockUnit, ing.Unit) {
+		return models.RecipeIngredient{}, fmt.Sprintf("unit mismatch: recipe uses %q, stock is counted in %q", ing.Unit, stockUnit), nil
+	}
+
+	conflict := "EXCLUDED.quantity"
+	if accumulate {
+		conflict = "recipe_ingredients.quantity + EXCLUDED.quantity"
+	}
+	var quantity float64
+	err = tx.QueryRow(
+		`INSERT INTO recipe_ingredients (recipe_id, ingredient_id, quantity)
+		 VALUES ($1, $2, $3)
+		 ON CONFLICT (recipe_id, ingredient_id)
+		 DO UPDATE SET quantity = `+conflict+`
+		 RETURNING quantity`,
+		recipeID, id, ing.Amount(),
+	).Scan(&quantity)
+	if err != nil {
+		return models.RecipeIngredient{}, "", err
+	}
+
+	return models.RecipeIngredient{IngredientID: id, Name: name, Quantity: quantity, Unit: stockUnit}, "", nil
+}
+
+// PasteIngredients adds a pasted block of ingredient lines to a recipe.
+// With dry_run the parsed lines are returned without saving anything.
+func (h *RecipeHandler) PasteIngredients(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RecipeID int    `json:"recipe_id"`
+		Text     string `json:"text"`
+		DryRun   bool   `json:"dry_run"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lines := parser.ParseIngredientLines(req.Text)
+	if req.DryRun {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"dry_run": true, "lines": lines})
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM recipes WHERE id = $1)", req.RecipeID).Scan(&exists); err != nil {
+		writeError(w, err)
+		return
+	}
+	if !exists {
+		jsonError(w, "Recipe not found", http.StatusNotFound)
+		return
+	}
+
+	added := []models.RecipeIngredient{}
+	unmatched := []UnmatchedLine{}
+	for _, line := range lines {
+		if !line.OK {
+			unmatched = append(unmatched, UnmatchedLine{Line: line.Text, Reason: "no quantity"})
+			continue
+		}
+
+		ing, reason, err := addParsedIngredient(tx, req.RecipeID, line.Ingredient, false)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if reason != "" {
+			unmatched = append(unmatched, UnmatchedLine{Line: line.Text, Reason: reason})
+			continue
+		}
+		added = append(added, ing)
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"added": added, "unmatched": unmatched})
+}
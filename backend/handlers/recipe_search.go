@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Kano-Chien/house_management/backend/database"
+	"github.com/Kano-Chien/house_management/backend/models"
+)
+
+type RecipeSearchResult struct {
+	models.Recipe
+	Rank float64 `json:"rank"`
+}
+
+// recipeDocument weighs the searchable text of a recipe: name first, then its
+// ingredients, then notes (stored as HTML by the editor), instructions and steps.
+// The 'simple' configuration is used because recipes mix English, Japanese and Chinese.
+const recipeDocument = `
+	setweight(to_tsvector('simple', r.name), 'A') ||
+	setweight(to_tsvector('simple', COALESCE((
+		SELECT string_agg(i.name, ' ')
+		FROM recipe_ingredients ri JOIN ingredients i ON ri.ingredient_id = i.id
+		WHERE ri.recipe_id = r.id
+	), '')), 'B') ||
+	setweight(to_tsvector('simple',
+		regexp_replace(COALESCE(r.notes, ''), '<[^>]*>', ' ', 'g') || ' ' ||
+		COALESCE(r.instructions, '') || ' ' ||
+		COALESCE((SELECT string_agg(s.text, ' ') FROM recipe_steps s WHERE s.recipe_id = r.id), '')
+	), 'C')`
+
+// sqliteRecipeDocument is the same text on SQLite, which has no tsvector and is
+// searched with LIKE instead.
+const sqliteRecipeDocument = `
+	r.name || ' ' ||
+	COALESCE((
+		SELECT group_concat(i.name, ' ')
+		FROM recipe_ingredients ri JOIN ingredients i ON ri.ingredient_id = i.id
+		WHERE ri.recipe_id = r.id
+	), '') || ' ' ||
+	COALESCE(r.notes, '') || ' ' || COALESCE(r.instructions, '') || ' ' ||
+	COALESCE((SELECT group_concat(s.text, ' ') FROM recipe_steps s WHERE s.recipe_id = r.id), '')`
+
+// queryList collects a repeatable, comma-separated query parameter.
+func queryList(r *http.Request, key string) []string {
+	var list []string
+	for _, v := range r.URL.Query()[key] {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+// SearchRecipes supports
+//
+//	?q=tomato soup        full-text search over name, ingredients, notes and instructions
+//	?include=egg,flour    recipes using every listed ingredient (name contains)
+//	?exclude=peanut       recipes using none of the listed ingredients
+//	?tag=quick&tag=Italian recipes carrying every listed tag
+//	?member_ids=1,4       people whose diets the results are checked against (default the household)
+//
+// Results are ordered by relevance when q is given, otherwise by name.
+func (h *RecipeHandler) SearchRecipes(w http.ResponseWriter, r *http.Request) {
+	var where []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	sqlite := database.IsSQLite(h.DB)
+	document := recipeDocument
+	if sqlite {
+		document = sqliteRecipeDocument
+	}
+
+	rank := "0"
+	if q := strings.TrimSpace(r.URL.Query().Get("q")); q != "" {
+		p := arg(q)
+		nameMatch := fmt.Sprintf("d.name ILIKE '%%' || %s || '%%'", p)
+		if sqlite {
+			// Every word has to appear somewhere; words in the name rank higher
+			var all, inName []string
+			for _, word := range strings.Fields(q) {
+				wp := arg(word)
+				all = append(all, fmt.Sprintf("d.document LIKE '%%' || %s || '%%'", wp))
+				inName = append(inName, fmt.Sprintf("CASE WHEN d.name LIKE '%%' || %s || '%%' THEN 0.1 ELSE 0 END", wp))
+			}
+			where = append(where, fmt.Sprintf("((%s) OR %s)", strings.Join(all, " AND "), nameMatch))
+			rank = fmt.Sprintf("CASE WHEN %s THEN 1 ELSE 0 END + %s", nameMatch, strings.Join(inName, " + "))
+		} else {
+			// The name substring match covers CJK text, which 'simple' does not split into words
+			where = append(where, fmt.Sprintf("(d.document @@ websearch_to_tsquery('simple', %s) OR %s)", p, nameMatch))
+			rank = fmt.Sprintf("ts_rank(d.document, websearch_to_tsquery('simple', %s)) + CASE WHEN %s THEN 1 ELSE 0 END", p, nameMatch)
+		}
+	}
+	// An ingredient matches by its name or any of its aliases
+	usesIngredient := func(name string) string {
+		p := arg(name)
+		return `EXISTS (
+			SELECT 1 FROM recipe_ingredients ri JOIN ingredients i ON ri.ingredient_id = i.id
+			WHERE ri.recipe_id = d.id AND (i.name ILIKE '%' || ` + p + ` || '%' OR EXISTS (
+				SELECT 1 FROM ingredient_aliases a
+				WHERE a.ingredient_id = i.id AND a.alias ILIKE '%' || ` + p + ` || '%')))`
+	}
+	for _, name := range queryList(r, "include") {
+		where = append(where, usesIngredient(name))
+	}
+	for _, name := range queryList(r, "exclude") {
+		where = append(where, "NOT "+usesIngredient(name))
+	}
+	for _, tag := range queryList(r, "tag") {
+		where = append(where, `EXISTS (
+			SELECT 1 FROM recipe_tags rt JOIN tags t ON rt.tag_id = t.id
+			WHERE rt.recipe_id = d.id AND LOWER(t.name) = LOWER(`+arg(tag)+`))`)
+	}
+
+	query := `
+		WITH d AS (
+			SELECT r.id, r.name, COALESCE(r.instructions, '') as instructions, COALESCE(r.notes, '') as notes,
+				` + document + ` as document
+			FROM recipes r
+		)
+		SELECT d.id, d.name, d.instructions, d.notes, ` + rank + ` as rank
+		FROM d`
+	if len(where) > 0 {
+		query += "\n\t\tWHERE " + strings.Join(where, "\n\t\tAND ")
+	}
+	query += "\n\t\tORDER BY rank DESC, d.name"
+
+	rows, err := h.DB.Query(query, args...)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	results := []RecipeSearchResult{}
+	for rows.Next() {
+		var res RecipeSearchResult
+		if err := rows.Scan(&res.ID, &res.Name, &res.Instructions, &res.Notes, &res.Rank); err != nil {
+			writeError(w, err)
+			return
+		}
+		results = append(results, res)
+	}
+
+	tags, err := loadRecipeTags(h.DB, 0)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	recipes := make([]models.Recipe, len(results))
+	for i := range results {
+		results[i].Tags = tags[results[i].ID]
+		recipes[i] = results[i].Recipe
+	}
+
+	memberIDs, err := memberIDsParam(r)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := attachConflicts(h.DB, recipes, memberIDs); err != nil {
+		writeError(w, err)
+		return
+	}
+	for i := range results {
+		results[i].Conflicts = recipes[i].Conflicts
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
@@ -0,0 +1,454 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Kano-Chien/house_management/backend/models"
+)
+
+// loadRecipeSteps returns the steps of a recipe in order, each with the recipe
+// ingredients it uses.
+func loadRecipeSteps(q queryer, recipeID int) ([]models.RecipeStep, error) {
+	rows, err := q.Query(`
+		SELECT id, recipe_id, position, text, duration_seconds, temperature, COALESCE(temperature_unit, 'C')
+		FROM recipe_steps
+		WHERE recipe_id = $1
+		ORDER BY position, id
+	`, recipeID)
+	if err != nil {
+		return nil, err
+	}
+
+	steps := []models.RecipeStep{}
+	index := map[int]int{}
+	for rows.Next() {
+		var s models.RecipeStep
+		if err := rows.Scan(&s.ID, &s.RecipeID, &s.Position, &s.Text, &s.DurationSeconds, &s.Temperature, &s.TemperatureUnit); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		s.IngredientIDs = []int{}
+		index[s.ID] = len(steps)
+		steps = append(steps, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = q.Query(`
+		SELECT si.step_id, ri.ingredient_id, i.name, ri.quantity, COALESCE(i.unit, '')
+		FROM recipe_step_ingredients si
+		JOIN recipe_steps s ON si.step_id = s.id
+		JOIN recipe_ingredients ri ON ri.recipe_id = si.recipe_id AND ri.ingredient_id = si.ingredient_id
+		JOIN ingredients i ON ri.ingredient_id = i.id
+		WHERE s.recipe_id = $1
+		ORDER BY i.name
+	`, recipeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var stepID int
+		var ing models.RecipeIngredient
+		if err := rows.Scan(&stepID, &ing.IngredientID, &ing.Name, &ing.Quantity, &ing.Unit); err != nil {
+			return nil, err
+		}
+		s := &steps[index[stepID]]
+		s.IngredientIDs = append(s.IngredientIDs, ing.IngredientID)
+		s.Ingredients = append(s.Ingredients, ing)
+	}
+	return steps, rows.Err()
+}
+
+// validateStep checks a step before it is written. The error message is meant for the client.
+func validateStep(s *models.RecipeStep) error {
+	if s.Text == "" {
+		return fmt.Errorf("text is required")
+	}
+	if s.DurationSeconds != nil && *s.DurationSeconds < 0 {
+		return fmt.Errorf("duration_seconds must not be negative")
+	}
+	if s.TemperatureUnit == "" {
+		s.TemperatureUnit = "C"
+	}
+	if s.TemperatureUnit != "C" && s.TemperatureUnit != "F" {
+		return fmt.Errorf("temperature_unit must be C or F")
+	}
+	return nil
+}
+
+// setStepIngredients replaces the ingredients referenced by a step. Every
+// ingredient must already be part of the recipe.
+func setStepIngredients(tx *sql.Tx, recipeID, stepID int, ingredientIDs []int) error {
+	if _, err := tx.Exec("DELETE FROM recipe_step_ingredients WHERE step_id = $1", stepID); err != nil {
+		return err
+	}
+	for _, ingredientID := range ingredientIDs {
+		var inRecipe bool
+		err := tx.QueryRow(
+			"SELECT EXISTS (SELECT 1 FROM recipe_ingredients WHERE recipe_id = $1 AND ingredient_id = $2)",
+			recipeID, ingredientID,
+		).Scan(&inRecipe)
+		if err != nil {
+			return err
+		}
+		if !inRecipe {
+			return errStepIngredient{ingredientID}
+		}
+		_, err = tx.Exec(
+			"INSERT INTO recipe_step_ingredients (step_id, recipe_id, ingredient_id) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
+			stepID, recipeID, ingredientID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type errStepIngredient struct{ ingredientID int }
+
+func (e errStepIngredient) Error() string {
+	return fmt.Sprintf("ingredient %d is not part of this recipe", e.ingredientID)
+}
+
+// insertStep adds a step at s.Position, shifting later steps down. A position of
+// 0 or past the end appends the step.
+func insertStep(tx *sql.Tx, s *models.RecipeStep) error {
+	var count int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM recipe_steps WHERE recipe_id = $1", s.RecipeID).Scan(&count); err != nil {
+		return err
+	}
+	if s.Position <= 0 || s.Position > count {
+		s.Position = count + 1
+	} else if _, err := tx.Exec("UPDATE recipe_steps SET position = position + 1 WHERE recipe_id = $1 AND position >= $2", s.RecipeID, s.Position); err != nil {
+		return err
+	}
+
+	err := tx.QueryRow(
+		"INSERT INTO recipe_steps (recipe_id, position, text, duration_seconds, temperature, temperature_unit) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		s.RecipeID, s.Position, s.Text, s.DurationSeconds, s.Temperature, s.TemperatureUnit,
+	).Scan(&s.ID)
+	if err != nil {
+		return err
+	}
+	return setStepIngredients(tx, s.RecipeID, s.ID, s.IngredientIDs)
+}
+
+// renumberSteps closes gaps left by a deleted step.
+func renumberSteps(tx *sql.Tx, recipeID int) error {
+	_, err := tx.Exec(`
+		UPDATE recipe_steps AS s SET position = n.position
+		FROM (
+			SELECT id, ROW_NUMBER() OVER (ORDER BY position, id) as position
+			FROM recipe_steps WHERE recipe_id = $1
+		) n
+		WHERE s.id = n.id
+	`, recipeID)
+	return err
+}
+
+func (h *RecipeHandler) GetRecipeDetail(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		jsonError(w, "id required", http.StatusBadRequest)
+		return
+	}
+
+	var recipe models.Recipe
+	err = h.DB.QueryRow(
+		"SELECT id, name, COALESCE(instructions, ''), COALESCE(notes, ''), COALESCE(servings, 1) FROM recipes WHERE id = $1", id,
+	).Scan(&recipe.ID, &recipe.Name, &recipe.Instructions, &recipe.Notes, &recipe.Servings)
+	if err == sql.ErrNoRows {
+		jsonError(w, "Recipe not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	rows, err := h.DB.Query(`
+		SELECT ri.ingredient_id, i.name, ri.quantity, COALESCE(i.unit, '') as unit
+		FROM recipe_ingredients ri
+		JOIN ingredients i ON ri.ingredient_id = i.id
+		WHERE ri.recipe_id = $1
+		ORDER BY i.name
+	`, id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	recipe.Ingredients = []models.RecipeIngredient{}
+	for rows.Next() {
+		var ing models.RecipeIngredient
+		if err := rows.Scan(&ing.IngredientID, &ing.Name, &ing.Quantity, &ing.Unit); err != nil {
+			writeError(w, err)
+			return
+		}
+		recipe.Ingredients = append(recipe.Ingredients, ing)
+	}
+
+	if recipe.Steps, err = loadRecipeSteps(h.DB, id); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	tags, err := loadRecipeTags(h.DB, id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	recipe.Tags = tags[id]
+
+	if recipe.Components, err = loadRecipeComponents(h.DB, id); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if recipe.Nutrition, err = loadRecipeNutrition(h.DB, id, recipe.Servings); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recipe)
+}
+
+func (h *RecipeHandler) GetRecipeSteps(w http.ResponseWriter, r *http.Request) {
+	recipeID, err := strconv.Atoi(r.URL.Query().Get("recipe_id"))
+	if err != nil {
+		jsonError(w, "recipe_id required", http.StatusBadRequest)
+		return
+	}
+
+	steps, err := loadRecipeSteps(h.DB, recipeID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(steps)
+}
+
+func (h *RecipeHandler) AddRecipeStep(w http.ResponseWriter, r *http.Request) {
+	var step models.RecipeStep
+	if err := json.NewDecoder(r.Body).Decode(&step); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateStep(&step); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Locking the recipe keeps concurrent inserts from handing out the same position
+	var recipeID int
+	err = tx.QueryRow("SELECT id FROM recipes WHERE id = $1 FOR UPDATE", step.RecipeID).Scan(&recipeID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "Recipe not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(w, err)
+		return
+	}
+	if step.IngredientIDs == nil {
+		step.IngredientIDs = []int{}
+	}
+
+	if err := insertStep(tx, &step); err != nil {
+		if _, ok := err.(errStepIngredient); ok {
+			jsonError(w, err.Error(), http.StatusBadRequest)
+		} else {
+			writeError(w, err)
+		}
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(step)
+}
+
+func (h *RecipeHandler) UpdateRecipeStep(w http.ResponseWriter, r *http.Request) {
+	var step models.RecipeStep
+	if err := json.NewDecoder(r.Body).Decode(&step); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateStep(&step); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Position is changed through reorder, not here. The recipe comes from the
+	// path; the action route may leave it out.
+	err = tx.QueryRow(
+		`UPDATE recipe_steps SET text = $1, duration_seconds = $2, temperature = $3, temperature_unit = $4
+		 WHERE id = $5 AND recipe_id = COALESCE(NULLIF($6, 0), recipe_id) RETURNING recipe_id, position`,
+		step.Text, step.DurationSeconds, step.Temperature, step.TemperatureUnit, step.ID, step.RecipeID,
+	).Scan(&step.RecipeID, &step.Position)
+	if err == sql.ErrNoRows {
+		jsonError(w, "Step not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if step.IngredientIDs != nil {
+		if err := setStepIngredients(tx, step.RecipeID, step.ID, step.IngredientIDs); err != nil {
+			if _, ok := err.(errStepIngredient); ok {
+				jsonError(w, err.Error(), http.StatusBadRequest)
+			} else {
+				writeError(w, err)
+			}
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "updated"})
+}
+
+func (h *RecipeHandler) DeleteRecipeStep(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID       int  `json:"id"`
+		RecipeID *int `json:"recipe_id"` // Set from the path; the action route may leave it out
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	var recipeID int
+	err = tx.QueryRow(
+		"DELETE FROM recipe_steps WHERE id = $1 AND recipe_id = COALESCE($2, recipe_id) RETURNING recipe_id",
+		req.ID, req.RecipeID,
+	).Scan(&recipeID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "Step not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := renumberSteps(tx, recipeID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+}
+
+// ReorderRecipeSteps takes every step ID of the recipe in the new order.
+func (h *RecipeHandler) ReorderRecipeSteps(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RecipeID int   `json:"recipe_id"`
+		StepIDs  []int `json:"step_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT id FROM recipe_steps WHERE recipe_id = $1 FOR UPDATE", req.RecipeID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	existing := map[int]bool{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			writeError(w, err)
+			return
+		}
+		existing[id] = true
+	}
+	rows.Close()
+
+	// Must be exactly the recipe's steps, each once
+	seen := map[int]bool{}
+	for _, id := range req.StepIDs {
+		if !existing[id] || seen[id] {
+			jsonError(w, fmt.Sprintf("step %d is not a step of this recipe or is listed twice", id), http.StatusBadRequest)
+			return
+		}
+		seen[id] = true
+	}
+	if len(seen) != len(existing) {
+		jsonError(w, "step_ids must list every step of the recipe", http.StatusBadRequest)
+		return
+	}
+
+	for i, id := range req.StepIDs {
+		if _, err := tx.Exec("UPDATE recipe_steps SET position = $1 WHERE id = $2", i+1, id); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "reordered"})
+}
@@ -1,52 +1,42 @@
 package handlers
 
 import (
-	"database/sql"
 	"encoding/json"
 	"net/http"
 )
 
 type ShoppingListHandler struct {
-	DB *sql.DB
+	Shopping ShoppingStore
+	LowStock float64 // Stock left after planned meals below which an item is listed, 3 when 0
 }
 
+// defaultLowStock is the shopping list threshold when LowStock is not set.
+const defaultLowStock = 3
+
 type ShoppingItem struct {
 	Name          string  `json:"name"`
 	CurrentStock  float64 `json:"current_stock"`
 	Unit          string  `json:"unit"`
 	EstimatedCost float64 `json:"estimated_cost"`
+	// Needed by upcoming meals that are not cooked yet, scaled by who attends
+	PlannedConsumption float64 `json:"planned_consumption"`
+	// Stocked substitute that can be used instead, set with ?include_covered=true
+	CoveredBy string `json:"covered_by,omitempty"`
 }
 
 func (h *ShoppingListHandler) GetShoppingList(w http.ResponseWriter, r *http.Request) {
-	// Show tracked items where stock is below threshold (3)
-	query := `
-		SELECT
-			i.name,
-			i.current_stock,
-			COALESCE(i.unit, '') as unit,
-			COALESCE(i.price, 0) as estimated_cost
-		FROM ingredients i
-		WHERE i.current_stock < 3
-		AND i.is_tracked = TRUE
-		ORDER BY i.current_stock ASC
-	`
+	// Items a stocked substitute can stand in for are left out unless ?include_covered=true
+	includeCovered := r.URL.Query().Get("include_covered") == "true"
 
-	rows, err := h.DB.Query(query)
+	threshold := h.LowStock
+	if threshold == 0 {
+		threshold = defaultLowStock
+	}
+	list, err := h.Shopping.ShoppingList(threshold, includeCovered)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
-	defer rows.Close()
-
-	var list []ShoppingItem
-	for rows.Next() {
-		var item ShoppingItem
-		if err := rows.Scan(&item.Name, &item.CurrentStock, &item.Unit, &item.EstimatedCost); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		list = append(list, item)
-	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(list)
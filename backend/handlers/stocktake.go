@@ -0,0 +1,341 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/Kano-Chien/house_management/backend/models"
+)
+
+type StocktakeHandler struct {
+	DB *sql.DB
+}
+
+// stocktakeScope restricts ingredients to the category/location a session was started for.
+// $1 is the session category, $2 the session location; NULL means "everything".
+const stocktakeScope = `($1::VARCHAR IS NULL OR COALESCE(i.category, 'food') = $1::VARCHAR)
+	AND ($2::INTEGER IS NULL OR i.location_id = $2::INTEGER)`
+
+func (h *StocktakeHandler) GetStocktakes(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.Query(`
+		SELECT s.id, s.status, s.category, s.location_id, COALESCE(s.reason, ''), s.started_at, s.committed_at,
+			COUNT(c.ingredient_id) as counted_items
+		FROM stocktakes s
+		LEFT JOIN stocktake_counts c ON c.stocktake_id = s.id
+		GROUP BY s.id
+		ORDER BY s.started_at DESC
+	`)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	var sessions []models.Stocktake
+	for rows.Next() {
+		var s models.Stocktake
+		if err := rows.Scan(&s.ID, &s.Status, &s.Category, &s.LocationID, &s.Reason, &s.StartedAt, &s.CommittedAt, &s.CountedItems); err != nil {
+			writeError(w, err)
+			return
+		}
+		sessions = append(sessions, s)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+func (h *StocktakeHandler) StartStocktake(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Category   *string `json:"category"`    // Optional, count only this category
+		LocationID *int    `json:"location_id"` // Optional, count only this location
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Category != nil && *req.Category == "" {
+		req.Category = nil
+	}
+
+	var s models.Stocktake
+	err := h.DB.QueryRow(
+		"INSERT INTO stocktakes (category, location_id) VALUES ($1, $2) RETURNING id, status, category, location_id, started_at",
+		req.Category, req.LocationID,
+	).Scan(&s.ID, &s.Status, &s.Category, &s.LocationID, &s.StartedAt)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(s)
+}
+
+// openStocktake loads the scope of a session and makes sure counts can still change.
+func openStocktake(tx *sql.Tx, id int) (category *string, locationID *int, err error) {
+	var state string
+	err = tx.QueryRow("SELECT status, category, location_id FROM stocktakes WHERE id = $1 FOR UPDATE", id).Scan(&state, &category, &locationID)
+	if err == sql.ErrNoRows {
+		return nil, nil, newAPIError(http.StatusNotFound, "Stocktake not found")
+	} else if err != nil {
+		return nil, nil, err
+	}
+	if state != "open" {
+		return nil, nil, newAPIError(http.StatusConflict, fmt.Sprintf("Stocktake already %s", state))
+	}
+	return category, locationID, nil
+}
+
+func (h *StocktakeHandler) SubmitCounts(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		StocktakeID int `json:"stocktake_id"`
+		Counts      []struct {
+			IngredientID    int     `json:"ingredient_id"`
+			CountedQuantity float64 `json:"counted_quantity"`
+		} `json:"counts"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var v validator
+	for i, c := range req.Counts {
+		v.check(c.CountedQuantity >= 0, fmt.Sprintf("counts[%d].counted_quantity", i), "cannot be negative")
+	}
+	if v.failed(w) {
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	category, locationID, err := openStocktake(tx, req.StocktakeID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	for _, c := range req.Counts {
+		var inScope bool
+		err := tx.QueryRow(
+			"SELECT EXISTS (SELECT 1 FROM ingredients i WHERE i.id = $3 AND "+stocktakeScope+")",
+			category, locationID, c.IngredientID,
+		).Scan(&inScope)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if !inScope {
+			jsonError(w, fmt.Sprintf("Ingredient %d is not part of this stocktake", c.IngredientID), http.StatusBadRequest)
+			return
+		}
+
+		_, err = tx.Exec(
+			`INSERT INTO stocktake_counts (stocktake_id, ingredient_id, counted_quantity)
+			 VALUES ($1, $2, $3)
+			 ON CONFLICT (stocktake_id, ingredient_id)
+			 DO UPDATE SET counted_quantity = EXCLUDED.counted_quantity`,
+			req.StocktakeID, c.IngredientID, c.CountedQuantity,
+		)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "counted"})
+}
+
+func (h *StocktakeHandler) GetVariance(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		jsonError(w, "id required", http.StatusBadRequest)
+		return
+	}
+
+	var category *string
+	var locationID *int
+	err := h.DB.QueryRow("SELECT category, location_id FROM stocktakes WHERE id = $1", id).Scan(&category, &locationID)
+	if err == sql.ErrNoRows {
+		jsonError(w, "Stocktake not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Every ingredient in scope is listed so uncounted items are easy to spot
+	rows, err := h.DB.Query(`
+		SELECT i.id, i.name, COALESCE(i.unit, ''), i.current_stock, c.counted_quantity, COALESCE(i.price, 0)
+		FROM ingredients i
+		LEFT JOIN stocktake_counts c ON c.ingredient_id = i.id AND c.stocktake_id = $3
+		WHERE `+stocktakeScope+`
+		ORDER BY i.name
+	`, category, locationID, id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	var lines []models.StocktakeLine
+	for rows.Next() {
+		var l models.StocktakeLine
+		var price float64
+		if err := rows.Scan(&l.IngredientID, &l.Name, &l.Unit, &l.SystemStock, &l.CountedQuantity, &price); err != nil {
+			writeError(w, err)
+			return
+		}
+		if l.CountedQuantity != nil {
+			l.Variance = *l.CountedQuantity - l.SystemStock
+			l.VarianceValue = l.Variance * price
+		}
+		lines = append(lines, l)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lines)
+}
+
+func (h *StocktakeHandler) CommitStocktake(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     int    `json:"id"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Reason == "" {
+		jsonError(w, "reason is required", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, _, err := openStocktake(tx, req.ID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Lock the counted ingredients so the variance is computed against the stock we overwrite
+	rows, err := tx.Query(`
+		SELECT c.ingredient_id, c.counted_quantity, i.current_stock
+		FROM stocktake_counts c
+		JOIN ingredients i ON c.ingredient_id = i.id
+		WHERE c.stocktake_id = $1
+		FOR UPDATE OF i
+	`, req.ID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	type correction struct {
+		ingredientID int
+		counted      float64
+		variance     float64
+	}
+	var corrections []correction
+	for rows.Next() {
+		var c correction
+		var system float64
+		if err := rows.Scan(&c.ingredientID, &c.counted, &system); err != nil {
+			rows.Close()
+			writeError(w, err)
+			return
+		}
+		c.variance = c.counted - system
+		if c.variance != 0 {
+			corrections = append(corrections, c)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	for _, c := range corrections {
+		if _, err := tx.Exec("UPDATE ingredients SET current_stock = $1 WHERE id = $2", c.counted, c.ingredientID); err != nil {
+			writeError(w, err)
+			return
+		}
+		_, err = tx.Exec(
+			"INSERT INTO inventory_history (ingredient_id, action, quantity_change, note) VALUES ($1, 'stocktake', $2, $3)",
+			c.ingredientID, c.variance, req.Reason,
+		)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	_, err = tx.Exec("UPDATE stocktakes SET status = 'committed', reason = $1, committed_at = NOW() WHERE id = $2", req.Reason, req.ID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "committed", "corrected": len(corrections)})
+}
+
+func (h *StocktakeHandler) CancelStocktake(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, _, err := openStocktake(tx, req.ID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if _, err := tx.Exec("UPDATE stocktakes SET status = 'cancelled' WHERE id = $1", req.ID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "cancelled"})
+}
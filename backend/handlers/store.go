@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Kano-Chien/house_management/backend/models"
+)
+
+// Stores return sql.ErrNoRows for a missing record and *APIError for requests
+// the data does not allow, so handlers can pass both on to writeError.
+
+// IngredientStore keeps ingredients and their stock.
+type IngredientStore interface {
+	// ListIngredients returns ingredients with planned consumption, flags and aliases.
+	ListIngredients(filter IngredientFilter) ([]models.Ingredient, error)
+	GetIngredient(id int) (models.Ingredient, error)
+	// CreateIngredient inserts i and sets its ID.
+	CreateIngredient(i *models.Ingredient) error
+	// UpdateIngredient saves the name, stock, price, category and tracking of i.
+	UpdateIngredient(i models.Ingredient) error
+	SetStock(id int, stock float64) error
+	DeleteIngredient(id int) error
+	// FindOrCreateIngredient looks an ingredient up by name or alias and creates
+	// it with zero stock when there is none. unit is only used on create.
+	FindOrCreateIngredient(name, unit string, isTracked bool) (id int, created bool, err error)
+	// SuggestIngredients returns ingredients whose name or an alias looks like
+	// name, best first. exclude is left out (0 for none).
+	SuggestIngredients(name string, limit, exclude int) ([]models.IngredientMatch, error)
+}
+
+// IngredientFilter narrows ListIngredients to a location.
+type IngredientFilter struct {
+	LocationID *int
+	NoLocation bool // Only items without a location
+}
+
+// RecipeStore keeps recipes and their ingredient lists.
+type RecipeStore interface {
+	// ListRecipes returns every recipe with its tags.
+	ListRecipes() ([]models.Recipe, error)
+	GetRecipe(id int) (models.Recipe, error)
+	// CreateRecipe inserts r with its ingredients and steps and sets the IDs.
+	CreateRecipe(r *models.Recipe) error
+	// UpdateRecipe saves the name, notes and servings of r; servings of 0 are kept.
+	UpdateRecipe(r models.Recipe) error
+	DeleteRecipe(id int) error
+	// AttachConflicts sets the diet conflicts of each recipe for the people
+	// eating, nil meaning the household.
+	AttachConflicts(recipes []models.Recipe, memberIDs []int) error
+
+	ListRecipeIngredients(recipeID int) ([]RecipeIngredientDetail, error)
+	// SetRecipeIngredient adds an ingredient to a recipe or changes its quantity.
+	SetRecipeIngredient(recipeID, ingredientID int, quantity float64) error
+	// UpdateRecipeIngredient changes the quantity of an ingredient already in the recipe.
+	UpdateRecipeIngredient(recipeID, ingredientID int, quantity float64) error
+	RemoveRecipeIngredient(recipeID, ingredientID int) error
+}
+
+// RecipeIngredientDetail is an ingredient line of a recipe as listed to the client.
+type RecipeIngredientDetail struct {
+	IngredientID int     `json:"ingredient_id"`
+	Name         string  `json:"name"`
+	Quantity     float64 `json:"quantity"`
+	Unit         string  `json:"unit"`
+	Price        float64 `json:"price"`
+	IsTracked    bool    `json:"is_tracked"`
+}
+
+// MealPlanStore keeps the meal plan and cooks planned meals.
+type MealPlanStore interface {
+	ListMealPlans() ([]models.MealPlan, error)
+	// DietConflicts returns the conflicts between a recipe and the people eating,
+	// nil meaning the household.
+	DietConflicts(recipeID int, memberIDs []int) ([]models.DietConflict, error)
+	// CreateMealPlan inserts m and sets its ID. Non-nil memberIDs attend and
+	// everyone else is out.
+	CreateMealPlan(m *models.MealPlan, memberIDs []int) error
+	DeleteMealPlan(id int) error
+	// CookMeal cooks a meal in one transaction: nothing changes when it fails.
+	CookMeal(req CookRequest) (CookResult, error)
+}
+
+type CookRequest struct {
+	ID            int
+	Batches       float64  // 0: enough for the planned servings, at least 1
+	ServingsEaten *float64 // nil: the planned servings, or the whole batch
+	LeftoverDays  int
+}
+
+type CookResult struct {
+	AteLeftovers         bool    // The meal was planned as leftovers
+	LeftoverServingsUsed float64 // Servings taken from leftovers when AteLeftovers
+	Leftover             *models.PreparedFood
+	Substitutions        []models.Substitution
+}
+
+// Errors CookMeal returns for meals that cannot be cooked.
+var (
+	errMealCooked   = newAPIError(http.StatusConflict, "Meal already cooked")
+	errMealNoRecipe = newAPIError(http.StatusBadRequest, "No recipe associated with this meal")
+	errNoLeftovers  = newAPIError(http.StatusConflict, "No leftovers of this recipe left")
+)
+
+// ShoppingStore works out what needs to be bought.
+type ShoppingStore interface {
+	// ShoppingList returns tracked items whose stock after upcoming meals is
+	// below threshold. Items a stocked substitute covers are only included
+	// when includeCovered is set.
+	ShoppingList(threshold float64, includeCovered bool) ([]ShoppingItem, error)
+}
+
+// Store is everything the store-backed handlers need.
+type Store interface {
+	IngredientStore
+	RecipeStore
+	MealPlanStore
+	ShoppingStore
+}
+
+// today is the date in the configured time zone (time.Local), as a UTC
+// midnight like the dates the database returns. Queries take it as a
+// parameter rather than using CURRENT_DATE, which follows the database's
+// clock and time zone.
+func today() time.Time {
+	y, m, d := time.Now().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,647 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Kano-Chien/house_management/backend/models"
+)
+
+// MemoryStore is a Store kept in memory so handlers can be tested without
+// PostgreSQL. It has no household, locations, tags, components or substitutes:
+// there are never diet conflicts and cooking only uses a recipe's own ingredients.
+type MemoryStore struct {
+	mu   sync.Mutex
+	data memoryData
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+type memoryData struct {
+	lastID      int // Shared by every table, like one sequence
+	ingredients map[int]models.Ingredient
+	recipes     map[int]models.Recipe // Ingredients and steps included
+	mealPlans   map[int]models.MealPlan
+	prepared    map[int]models.PreparedFood
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: memoryData{
+		ingredients: map[int]models.Ingredient{},
+		recipes:     map[int]models.Recipe{},
+		mealPlans:   map[int]models.MealPlan{},
+		prepared:    map[int]models.PreparedFood{},
+	}}
+}
+
+// clone copies the data so a transaction can work on it and be dropped on failure.
+func (d memoryData) clone() memoryData {
+	c := memoryData{
+		lastID:      d.lastID,
+		ingredients: make(map[int]models.Ingredient, len(d.ingredients)),
+		recipes:     make(map[int]models.Recipe, len(d.recipes)),
+		mealPlans:   make(map[int]models.MealPlan, len(d.mealPlans)),
+		prepared:    make(map[int]models.PreparedFood, len(d.prepared)),
+	}
+	for id, i := range d.ingredients {
+		i.Aliases = append([]string(nil), i.Aliases...)
+		c.ingredients[id] = i
+	}
+	for id, r := range d.recipes {
+		r.Ingredients = append([]models.RecipeIngredient(nil), r.Ingredients...)
+		r.Steps = append([]models.RecipeStep(nil), r.Steps...)
+		c.recipes[id] = r
+	}
+	for id, m := range d.mealPlans {
+		c.mealPlans[id] = m
+	}
+	for id, p := range d.prepared {
+		c.prepared[id] = p
+	}
+	return c
+}
+
+func (d *memoryData) nextID() int {
+	d.lastID++
+	return d.lastID
+}
+
+// unknownReference is the error PostgreSQL gives for a foreign key to nothing.
+func unknownReference(field string) error {
+	return &APIError{Status: http.StatusUnprocessableEntity, Code: "unknown_reference",
+		Message: "Refers to a record that does not exist", Fields: map[string]string{field: "does not exist"}}
+}
+
+// plannedServings follows the meal_plan_servings view for a household without members.
+func (d *memoryData) plannedServings(m models.MealPlan) *float64 {
+	if m.Servings != nil {
+		s := *m.Servings
+		return &s
+	}
+	if m.GuestCount > 0 {
+		s := float64(m.GuestCount)
+		return &s
+	}
+	return nil
+}
+
+func (d *memoryData) plannedBatches(m models.MealPlan) float64 {
+	if m.IsLeftovers {
+		return 0
+	}
+	servings := d.plannedServings(m)
+	if servings == nil || m.RecipeID == nil {
+		return 1
+	}
+	return math.Ceil(*servings / float64(max(d.recipes[*m.RecipeID].Servings, 1)))
+}
+
+// plannedUse sums the ingredients the meals accepted by include will use.
+func (d *memoryData) plannedUse(include func(models.MealPlan) bool) map[int]float64 {
+	use := map[int]float64{}
+	for _, m := range d.mealPlans {
+		if m.RecipeID == nil || !include(m) {
+			continue
+		}
+		batches := d.plannedBatches(m)
+		for _, ing := range d.recipes[*m.RecipeID].Ingredients {
+			use[ing.IngredientID] += ing.Quantity * batches
+		}
+	}
+	return use
+}
+
+func (s *MemoryStore) ListIngredients(filter IngredientFilter) ([]models.Ingredient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	planned := s.data.plannedUse(func(models.MealPlan) bool { return true })
+	var inventory []models.Ingredient
+	for _, id := range sortedKeys(s.data.ingredients) {
+		i := s.data.ingredients[id]
+		if filter.NoLocation && i.LocationID != nil ||
+			filter.LocationID != nil && (i.LocationID == nil || *i.LocationID != *filter.LocationID) {
+			continue
+		}
+		i.Aliases = append([]string(nil), i.Aliases...)
+		i.PlannedConsumption = planned[id]
+		inventory = append(inventory, i)
+	}
+	return inventory, nil
+}
+
+func (s *MemoryStore) GetIngredient(id int) (models.Ingredient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.data.ingredients[id]
+	if !ok {
+		return i, sql.ErrNoRows
+	}
+	i.Aliases = append([]string(nil), i.Aliases...)
+	i.PlannedConsumption = s.data.plannedUse(func(models.MealPlan) bool { return true })[id]
+	return i, nil
+}
+
+func (s *MemoryStore) CreateIngredient(i *models.Ingredient) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if i.LocationID != nil {
+		return unknownReference("location_id")
+	}
+	i.ID = s.data.nextID()
+	stored := *i
+	stored.LocationName, stored.Flags, stored.PlannedConsumption = "", nil, 0
+	stored.Aliases = append([]string(nil), i.Aliases...)
+	s.data.ingredients[i.ID] = stored
+	return nil
+}
+
+func (s *MemoryStore) UpdateIngredient(i models.Ingredient) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.data.ingredients[i.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	stored.Name, stored.CurrentStock, stored.Price, stored.Category, stored.IsTracked = i.Name, i.CurrentStock, i.Price, i.Category, i.IsTracked
+	s.data.ingredients[i.ID] = stored
+	return nil
+}
+
+func (s *MemoryStore) SetStock(id int, stock float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.data.ingredients[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	i.CurrentStock = stock
+	s.data.ingredients[id] = i
+	return nil
+}
+
+func (s *MemoryStore) DeleteIngredient(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.data.ingredients[id]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(s.data.ingredients, id)
+	// Recipe lines go with it
+	for rid, r := range s.data.recipes {
+		r.Ingredients = removeRecipeLine(r.Ingredients, id)
+		s.data.recipes[rid] = r
+	}
+	return nil
+}
+
+func (s *MemoryStore) FindOrCreateIngredient(name, unit string, isTracked bool) (int, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name = strings.TrimSpace(name)
+	aliasMatch := 0
+	for _, id := range sortedKeys(s.data.ingredients) {
+		i := s.data.ingredients[id]
+		if strings.EqualFold(i.Name, name) {
+			return id, false, nil
+		}
+		for _, alias := range i.Aliases {
+			if aliasMatch == 0 && strings.EqualFold(alias, name) {
+				aliasMatch = id
+			}
+		}
+	}
+	if aliasMatch != 0 {
+		return aliasMatch, false, nil
+	}
+
+	id := s.data.nextID()
+	s.data.ingredients[id] = models.Ingredient{ID: id, Name: name, Unit: unit, Category: "food", IsTracked: isTracked}
+	return id, true, nil
+}
+
+func (s *MemoryStore) SuggestIngredients(name string, limit, exclude int) ([]models.IngredientMatch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var candidates []models.IngredientMatch
+	for _, i := range s.data.ingredients {
+		candidates = append(candidates, models.IngredientMatch{ID: i.ID, Name: i.Name, MatchedOn: i.Name})
+		for _, alias := range i.Aliases {
+			candidates = append(candidates, models.IngredientMatch{ID: i.ID, Name: i.Name, MatchedOn: alias})
+		}
+	}
+	return rankIngredientMatches(name, candidates, limit, exclude), nil
+}
+
+func (s *MemoryStore) ListRecipes() ([]models.Recipe, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var recipes []models.Recipe
+	for _, id := range sortedKeys(s.data.recipes) {
+		r := s.data.recipes[id]
+		r.Ingredients, r.Steps = nil, nil
+		recipes = append(recipes, r)
+	}
+	return recipes, nil
+}
+
+func (s *MemoryStore) GetRecipe(id int) (models.Recipe, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.data.recipes[id]
+	if !ok {
+		return r, sql.ErrNoRows
+	}
+	r.Ingredients, r.Steps = nil, nil
+	return r, nil
+}
+
+func (s *MemoryStore) CreateRecipe(r *models.Recipe) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inRecipe := map[int]bool{}
+	for _, ing := range r.Ingredients {
+		if _, ok := s.data.ingredients[ing.IngredientID]; !ok {
+			return unknownReference("ingredient_id")
+		}
+		if inRecipe[ing.IngredientID] {
+			return &APIError{Status: http.StatusConflict, Code: "duplicate", Message: "Already exists"}
+		}
+		inRecipe[ing.IngredientID] = true
+	}
+	for i, step := range r.Steps {
+		for _, id := range step.IngredientIDs {
+			if !inRecipe[id] {
+				return newAPIError(http.StatusBadRequest, fmt.Sprintf("step %d: %v", i+1, errStepIngredient{id}))
+			}
+		}
+	}
+
+	r.ID = s.data.nextID()
+	stored := *r
+	stored.Ingredients = make([]models.RecipeIngredient, len(r.Ingredients))
+	for i, ing := range r.Ingredients {
+		stored.Ingredients[i] = models.RecipeIngredient{IngredientID: ing.IngredientID, Quantity: ing.Quantity}
+	}
+	stored.Steps = make([]models.RecipeStep, len(r.Steps))
+	for i := range r.Steps {
+		step := &r.Steps[i]
+		step.ID, step.RecipeID, step.Position = s.data.nextID(), r.ID, i+1
+		stored.Steps[i] = *step
+	}
+	stored.Tags, stored.Components, stored.Nutrition, stored.Conflicts = nil, nil, nil, nil
+	s.data.recipes[r.ID] = stored
+	return nil
+}
+
+func (s *MemoryStore) UpdateRecipe(r models.Recipe) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.data.recipes[r.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	stored.Name, stored.Notes = r.Name, r.Notes
+	if r.Servings != 0 {
+		stored.Servings = r.Servings
+	}
+	s.data.recipes[r.ID] = stored
+	return nil
+}
+
+func (s *MemoryStore) DeleteRecipe(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.data.recipes, id)
+	// Meals keep their slot without a recipe and prepared food goes with it
+	for mid, m := range s.data.mealPlans {
+		if m.RecipeID != nil && *m.RecipeID == id {
+			m.RecipeID = nil
+			s.data.mealPlans[mid] = m
+		}
+	}
+	for pid, p := range s.data.prepared {
+		if p.RecipeID == id {
+			delete(s.data.prepared, pid)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) AttachConflicts(recipes []models.Recipe, memberIDs []int) error {
+	// Nobody lives in the memory household, so nothing conflicts
+	return nil
+}
+
+func (s *MemoryStore) ListRecipeIngredients(recipeID int) ([]RecipeIngredientDetail, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ingredients []RecipeIngredientDetail
+	for _, ing := range s.data.recipes[recipeID].Ingredients {
+		i := s.data.ingredients[ing.IngredientID]
+		ingredients = append(ingredients, RecipeIngredientDetail{
+			IngredientID: i.ID, Name: i.Name, Quantity: ing.Quantity, Unit: i.Unit, Price: i.Price, IsTracked: i.IsTracked,
+		})
+	}
+	return ingredients, nil
+}
+
+func (s *MemoryStore) SetRecipeIngredient(recipeID, ingredientID int, quantity float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.data.recipes[recipeID]
+	if !ok {
+		return unknownReference("recipe_id")
+	}
+	if _, ok := s.data.ingredients[ingredientID]; !ok {
+		return unknownReference("ingredient_id")
+	}
+	lines := append([]models.RecipeIngredient(nil), r.Ingredients...)
+	for n := range lines {
+		if lines[n].IngredientID == ingredientID {
+			lines[n].Quantity = quantity
+			r.Ingredients = lines
+			s.data.recipes[recipeID] = r
+			return nil
+		}
+	}
+	r.Ingredients = append(lines, models.RecipeIngredient{IngredientID: ingredientID, Quantity: quantity})
+	s.data.recipes[recipeID] = r
+	return nil
+}
+
+func (s *MemoryStore) UpdateRecipeIngredient(recipeID, ingredientID int, quantity float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.data.recipes[recipeID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	lines := append([]models.RecipeIngredient(nil), r.Ingredients...)
+	for n := range lines {
+		if lines[n].IngredientID == ingredientID {
+			lines[n].Quantity = quantity
+			r.Ingredients = lines
+			s.data.recipes[recipeID] = r
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (s *MemoryStore) RemoveRecipeIngredient(recipeID, ingredientID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := s.data.recipes[recipeID]; ok {
+		r.Ingredients = removeRecipeLine(r.Ingredients, ingredientID)
+		s.data.recipes[recipeID] = r
+	}
+	return nil
+}
+
+// removeRecipeLine returns lines without the ingredient.
+func removeRecipeLine(lines []models.RecipeIngredient, ingredientID int) []models.RecipeIngredient {
+	kept := make([]models.RecipeIngredient, 0, len(lines))
+	for _, ing := range lines {
+		if ing.IngredientID != ingredientID {
+			kept = append(kept, ing)
+		}
+	}
+	return kept
+}
+
+func (s *MemoryStore) ListMealPlans() ([]models.MealPlan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var plan []models.MealPlan
+	for _, m := range s.data.mealPlans {
+		if m.RecipeID != nil {
+			m.RecipeName = s.data.recipes[*m.RecipeID].Name
+		}
+		m.PlannedServings = s.data.plannedServings(m)
+		plan = append(plan, m)
+	}
+	sort.Slice(plan, func(i, j int) bool {
+		if !plan[i].Date.Equal(plan[j].Date) {
+			return plan[i].Date.Before(plan[j].Date)
+		}
+		if plan[i].MealType != plan[j].MealType {
+			return plan[i].MealType < plan[j].MealType
+		}
+		return plan[i].ID < plan[j].ID
+	})
+	return plan, nil
+}
+
+func (s *MemoryStore) DietConflicts(recipeID int, memberIDs []int) ([]models.DietConflict, error) {
+	return nil, nil
+}
+
+func (s *MemoryStore) CreateMealPlan(m *models.MealPlan, memberIDs []int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if m.RecipeID != nil {
+		if _, ok := s.data.recipes[*m.RecipeID]; !ok {
+			return unknownReference("recipe_id")
+		}
+	}
+	if len(memberIDs) > 0 {
+		return unknownReference("member_id")
+	}
+	m.ID = s.data.nextID()
+	stored := *m
+	stored.RecipeName, stored.IsCooked, stored.PlannedServings = "", false, nil
+	s.data.mealPlans[m.ID] = stored
+	return nil
+}
+
+func (s *MemoryStore) DeleteMealPlan(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.data.mealPlans, id)
+	for pid, p := range s.data.prepared {
+		if p.MealPlanID != nil && *p.MealPlanID == id {
+			p.MealPlanID = nil
+			s.data.prepared[pid] = p
+		}
+	}
+	return nil
+}
+
+// CookMeal follows SQLStore.CookMeal on a copy of the data, which only
+// replaces the data when every step succeeded.
+func (s *MemoryStore) CookMeal(req CookRequest) (CookResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := s.data.clone()
+	res, err := tx.cookMeal(req)
+	if err != nil {
+		return res, err
+	}
+	s.data = tx
+	return res, nil
+}
+
+func (d *memoryData) cookMeal(req CookRequest) (CookResult, error) {
+	var res CookResult
+	m, ok := d.mealPlans[req.ID]
+	if !ok {
+		return res, sql.ErrNoRows
+	}
+	if m.IsCooked {
+		return res, errMealCooked
+	}
+	if m.RecipeID == nil {
+		return res, errMealNoRecipe
+	}
+	recipeID := *m.RecipeID
+	res.AteLeftovers = m.IsLeftovers
+
+	eaten := req.ServingsEaten
+	if eaten == nil {
+		eaten = d.plannedServings(m)
+	}
+	batches := req.Batches
+	if batches == 0 {
+		batches = math.Max(d.plannedBatches(m), 1)
+	}
+
+	m.IsCooked = true
+	d.mealPlans[req.ID] = m
+
+	if res.AteLeftovers {
+		want := math.Inf(1)
+		if eaten != nil {
+			want = *eaten
+		}
+		res.LeftoverServingsUsed = d.takePreparedServings(recipeID, want)
+		if res.LeftoverServingsUsed == 0 {
+			return res, errNoLeftovers
+		}
+		return res, nil
+	}
+
+	// Stock may go negative, like in PostgreSQL
+	recipe := d.recipes[recipeID]
+	for _, ing := range recipe.Ingredients {
+		i := d.ingredients[ing.IngredientID]
+		if !i.IsTracked {
+			continue
+		}
+		i.CurrentStock -= ing.Quantity * batches
+		d.ingredients[i.ID] = i
+	}
+
+	if made := batches * float64(max(recipe.Servings, 1)); eaten != nil && *eaten < made {
+		mealID := req.ID
+		expiry := today().AddDate(0, 0, req.LeftoverDays)
+		leftover := models.PreparedFood{
+			ID: d.nextID(), RecipeID: recipeID, Kind: "leftover", MealPlanID: &mealID,
+			Servings: made - *eaten, PreparedOn: today(), ExpiryDate: &expiry,
+		}
+		d.prepared[leftover.ID] = leftover
+		res.Leftover = &leftover
+	}
+	return res, nil
+}
+
+// takePreparedServings follows the PostgreSQL version: leftovers first, then
+// the soonest to expire.
+func (d *memoryData) takePreparedServings(recipeID int, servings float64) float64 {
+	var lots []models.PreparedFood
+	for _, p := range d.prepared {
+		if p.RecipeID == recipeID && p.Servings > 0 && (p.ExpiryDate == nil || !p.ExpiryDate.Before(today())) {
+			lots = append(lots, p)
+		}
+	}
+	sort.Slice(lots, func(i, j int) bool {
+		a, b := lots[i], lots[j]
+		if (a.Kind == "leftover") != (b.Kind == "leftover") {
+			return a.Kind == "leftover"
+		}
+		if (a.ExpiryDate == nil) != (b.ExpiryDate == nil) {
+			return b.ExpiryDate == nil
+		}
+		if a.ExpiryDate != nil && !a.ExpiryDate.Equal(*b.ExpiryDate) {
+			return a.ExpiryDate.Before(*b.ExpiryDate)
+		}
+		if !a.PreparedOn.Equal(b.PreparedOn) {
+			return a.PreparedOn.Before(b.PreparedOn)
+		}
+		return a.ID < b.ID
+	})
+
+	taken := 0.0
+	for _, p := range lots {
+		if taken >= servings {
+			break
+		}
+		take := math.Min(p.Servings, servings-taken)
+		if take == p.Servings {
+			delete(d.prepared, p.ID)
+		} else {
+			p.Servings -= take
+			d.prepared[p.ID] = p
+		}
+		taken += take
+	}
+	return taken
+}
+
+func (s *MemoryStore) ShoppingList(threshold float64, includeCovered bool) ([]ShoppingItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Upcoming meals that are not cooked yet; nothing is covered without substitutes
+	planned := s.data.plannedUse(func(m models.MealPlan) bool {
+		return !m.IsCooked && !m.Date.Before(today())
+	})
+	var list []ShoppingItem
+	for _, id := range sortedKeys(s.data.ingredients) {
+		i := s.data.ingredients[id]
+		if !i.IsTracked || i.CurrentStock-planned[id] >= threshold {
+			continue
+		}
+		list = append(list, ShoppingItem{
+			Name: i.Name, CurrentStock: i.CurrentStock, Unit: i.Unit, EstimatedCost: i.Price, PlannedConsumption: planned[id],
+		})
+	}
+	sort.SliceStable(list, func(a, b int) bool {
+		return list[a].CurrentStock-list[a].PlannedConsumption < list[b].CurrentStock-list[b].PlannedConsumption
+	})
+	return list, nil
+}
+
+func sortedKeys[V any](m map[int]V) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
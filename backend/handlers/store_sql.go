@@ -0,0 +1,496 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+
+	"github.com/Kano-Chien/house_management/backend/models"
+)
+
+// SQLStore is the Store used in production, on PostgreSQL or SQLite.
+type SQLStore struct {
+	DB *sql.DB
+}
+
+var _ Store = (*SQLStore)(nil)
+
+func NewSQLStore(db *sql.DB) *SQLStore {
+	return &SQLStore{DB: db}
+}
+
+// rowsAffectedOrNotFound turns an update or delete that matched nothing into sql.ErrNoRows.
+func rowsAffectedOrNotFound(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Planned consumption of an ingredient by every meal in the plan
+const plannedConsumption = `
+	COALESCE((
+		SELECT SUM(e.quantity * s.batches)
+		FROM recipe_ingredients_expanded e
+		INNER JOIN meal_plan mp ON e.recipe_id = mp.recipe_id
+		INNER JOIN meal_plan_servings s ON s.meal_plan_id = mp.id
+		WHERE e.ingredient_id = i.id
+	), 0)`
+
+func (s *SQLStore) ListIngredients(filter IngredientFilter) ([]models.Ingredient, error) {
+	where := ""
+	var args []interface{}
+	if filter.NoLocation {
+		where = "WHERE i.location_id IS NULL"
+	} else if filter.LocationID != nil {
+		where = "WHERE i.location_id = $1"
+		args = append(args, *filter.LocationID)
+	}
+
+	rows, err := s.DB.Query(`
+		SELECT
+			i.id, i.name, i.current_stock, COALESCE(i.unit, '') as unit, i.expiry_date, COALESCE(i.price, 0) as price,
+			COALESCE(i.category, 'food') as category,
+			COALESCE(i.is_tracked, TRUE),
+			i.location_id, COALESCE(l.name, '') as location_name,
+			`+plannedConsumption+` as planned_consumption
+		FROM ingredients i
+		LEFT JOIN locations l ON i.location_id = l.id
+		`+where+`
+		GROUP BY i.id, l.name
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var inventory []models.Ingredient
+	for rows.Next() {
+		var i models.Ingredient
+		if err := rows.Scan(&i.ID, &i.Name, &i.CurrentStock, &i.Unit, &i.ExpiryDate, &i.Price, &i.Category, &i.IsTracked, &i.LocationID, &i.LocationName, &i.PlannedConsumption); err != nil {
+			return nil, err
+		}
+		inventory = append(inventory, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	flags, err := loadIngredientFlags(s.DB, 0)
+	if err != nil {
+		return nil, err
+	}
+	aliases, err := loadIngredientAliases(s.DB, 0)
+	if err != nil {
+		return nil, err
+	}
+	for n := range inventory {
+		inventory[n].Flags = flags[inventory[n].ID]
+		inventory[n].Aliases = aliases[inventory[n].ID]
+	}
+	return inventory, nil
+}
+
+func (s *SQLStore) GetIngredient(id int) (models.Ingredient, error) {
+	var i models.Ingredient
+	err := s.DB.QueryRow(`
+		SELECT
+			i.id, i.name, i.current_stock, COALESCE(i.unit, ''), i.expiry_date, COALESCE(i.price, 0),
+			COALESCE(i.category, 'food'), COALESCE(i.is_tracked, TRUE), i.location_id, COALESCE(l.name, ''),
+			`+plannedConsumption+`
+		FROM ingredients i
+		LEFT JOIN locations l ON i.location_id = l.id
+		WHERE i.id = $1
+	`, id).Scan(&i.ID, &i.Name, &i.CurrentStock, &i.Unit, &i.ExpiryDate, &i.Price, &i.Category, &i.IsTracked, &i.LocationID, &i.LocationName, &i.PlannedConsumption)
+	if err != nil {
+		return i, err
+	}
+
+	flags, err := loadIngredientFlags(s.DB, i.ID)
+	if err != nil {
+		return i, err
+	}
+	aliases, err := loadIngredientAliases(s.DB, i.ID)
+	if err != nil {
+		return i, err
+	}
+	i.Flags, i.Aliases = flags[i.ID], aliases[i.ID]
+	return i, nil
+}
+
+func (s *SQLStore) CreateIngredient(i *models.Ingredient) error {
+	return s.DB.QueryRow(
+		"INSERT INTO ingredients (name, current_stock, unit, expiry_date, price, category, is_tracked, location_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id",
+		i.Name, i.CurrentStock, i.Unit, i.ExpiryDate, i.Price, i.Category, i.IsTracked, i.LocationID,
+	).Scan(&i.ID)
+}
+
+func (s *SQLStore) UpdateIngredient(i models.Ingredient) error {
+	return rowsAffectedOrNotFound(s.DB.Exec(
+		"UPDATE ingredients SET name = $1, current_stock = $2, price = $3, category = $4, is_tracked = $5 WHERE id = $6",
+		i.Name, i.CurrentStock, i.Price, i.Category, i.IsTracked, i.ID,
+	))
+}
+
+func (s *SQLStore) SetStock(id int, stock float64) error {
+	return rowsAffectedOrNotFound(s.DB.Exec("UPDATE ingredients SET current_stock = $1 WHERE id = $2", stock, id))
+}
+
+func (s *SQLStore) DeleteIngredient(id int) error {
+	return rowsAffectedOrNotFound(s.DB.Exec("DELETE FROM ingredients WHERE id = $1", id))
+}
+
+func (s *SQLStore) FindOrCreateIngredient(name, unit string, isTracked bool) (int, bool, error) {
+	return findOrCreateIngredient(s.DB, name, unit, isTracked)
+}
+
+func (s *SQLStore) SuggestIngredients(name string, limit, exclude int) ([]models.IngredientMatch, error) {
+	return suggestIngredients(s.DB, name, limit, exclude)
+}
+
+func (s *SQLStore) ListRecipes() ([]models.Recipe, error) {
+	rows, err := s.DB.Query("SELECT id, name, COALESCE(instructions, ''), COALESCE(notes, '') as notes, COALESCE(servings, 1) as servings FROM recipes")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recipes []models.Recipe
+	for rows.Next() {
+		var r models.Recipe
+		if err := rows.Scan(&r.ID, &r.Name, &r.Instructions, &r.Notes, &r.Servings); err != nil {
+			return nil, err
+		}
+		recipes = append(recipes, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	tags, err := loadRecipeTags(s.DB, 0)
+	if err != nil {
+		return nil, err
+	}
+	for i := range recipes {
+		recipes[i].Tags = tags[recipes[i].ID]
+	}
+	return recipes, nil
+}
+
+func (s *SQLStore) GetRecipe(id int) (models.Recipe, error) {
+	var r models.Recipe
+	err := s.DB.QueryRow(
+		"SELECT id, name, COALESCE(instructions, ''), COALESCE(notes, ''), COALESCE(servings, 1) FROM recipes WHERE id = $1", id,
+	).Scan(&r.ID, &r.Name, &r.Instructions, &r.Notes, &r.Servings)
+	return r, err
+}
+
+func (s *SQLStore) CreateRecipe(r *models.Recipe) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow("INSERT INTO recipes (name, instructions, notes, servings) VALUES ($1, $2, $3, $4) RETURNING id", r.Name, r.Instructions, r.Notes, r.Servings).Scan(&r.ID)
+	if err != nil {
+		return err
+	}
+
+	if len(r.Ingredients) > 0 {
+		stmt, err := tx.Prepare("INSERT INTO recipe_ingredients (recipe_id, ingredient_id, quantity) VALUES ($1, $2, $3)")
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, ing := range r.Ingredients {
+			if _, err := stmt.Exec(r.ID, ing.IngredientID, ing.Quantity); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Steps may reference the ingredients above
+	for i := range r.Steps {
+		step := &r.Steps[i]
+		step.RecipeID = r.ID
+		step.Position = i + 1
+		if err := insertStep(tx, step); err != nil {
+			if _, ok := err.(errStepIngredient); ok {
+				return newAPIError(http.StatusBadRequest, fmt.Sprintf("step %d: %v", i+1, err))
+			}
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLStore) UpdateRecipe(r models.Recipe) error {
+	return rowsAffectedOrNotFound(s.DB.Exec(
+		"UPDATE recipes SET name = $1, notes = $2, servings = COALESCE(NULLIF($3, 0), servings) WHERE id = $4",
+		r.Name, r.Notes, r.Servings, r.ID,
+	))
+}
+
+func (s *SQLStore) DeleteRecipe(id int) error {
+	_, err := s.DB.Exec("DELETE FROM recipes WHERE id = $1", id)
+	return err
+}
+
+func (s *SQLStore) AttachConflicts(recipes []models.Recipe, memberIDs []int) error {
+	return attachConflicts(s.DB, recipes, memberIDs)
+}
+
+func (s *SQLStore) ListRecipeIngredients(recipeID int) ([]RecipeIngredientDetail, error) {
+	rows, err := s.DB.Query(`
+		SELECT ri.ingredient_id, i.name, ri.quantity, COALESCE(i.unit, '') as unit, COALESCE(i.price, 0) as price, COALESCE(i.is_tracked, TRUE)
+		FROM recipe_ingredients ri
+		JOIN ingredients i ON ri.ingredient_id = i.id
+		WHERE ri.recipe_id = $1
+	`, recipeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ingredients []RecipeIngredientDetail
+	for rows.Next() {
+		var ing RecipeIngredientDetail
+		if err := rows.Scan(&ing.IngredientID, &ing.Name, &ing.Quantity, &ing.Unit, &ing.Price, &ing.IsTracked); err != nil {
+			return nil, err
+		}
+		ingredients = append(ingredients, ing)
+	}
+	return ingredients, rows.Err()
+}
+
+func (s *SQLStore) SetRecipeIngredient(recipeID, ingredientID int, quantity float64) error {
+	_, err := s.DB.Exec(
+		`INSERT INTO recipe_ingredients (recipe_id, ingredient_id, quantity)
+		 VALUES ($1, $2, $3)
+		 ON CONFLICT (recipe_id, ingredient_id)
+		 DO UPDATE SET quantity = EXCLUDED.quantity`,
+		recipeID, ingredientID, quantity,
+	)
+	return err
+}
+
+func (s *SQLStore) UpdateRecipeIngredient(recipeID, ingredientID int, quantity float64) error {
+	return rowsAffectedOrNotFound(s.DB.Exec(
+		"UPDATE recipe_ingredients SET quantity = $1 WHERE recipe_id = $2 AND ingredient_id = $3",
+		quantity, recipeID, ingredientID,
+	))
+}
+
+func (s *SQLStore) RemoveRecipeIngredient(recipeID, ingredientID int) error {
+	_, err := s.DB.Exec("DELETE FROM recipe_ingredients WHERE recipe_id = $1 AND ingredient_id = $2", recipeID, ingredientID)
+	return err
+}
+
+func (s *SQLStore) ListMealPlans() ([]models.MealPlan, error) {
+	rows, err := s.DB.Query(`
+		SELECT mp.id, mp.date, mp.meal_type, mp.recipe_id, COALESCE(r.name, ''), COALESCE(mp.is_cooked, FALSE),
+			mp.servings, COALESCE(mp.is_leftovers, FALSE), COALESCE(mp.guest_count, 0), s.servings
+		FROM meal_plan mp
+		LEFT JOIN recipes r ON mp.recipe_id = r.id
+		JOIN meal_plan_servings s ON s.meal_plan_id = mp.id
+		ORDER BY mp.date, mp.meal_type
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var plan []models.MealPlan
+	for rows.Next() {
+		var mp models.MealPlan
+		if err := rows.Scan(&mp.ID, &mp.Date, &mp.MealType, &mp.RecipeID, &mp.RecipeName, &mp.IsCooked, &mp.Servings, &mp.IsLeftovers, &mp.GuestCount, &mp.PlannedServings); err != nil {
+			return nil, err
+		}
+		plan = append(plan, mp)
+	}
+	return plan, rows.Err()
+}
+
+func (s *SQLStore) DietConflicts(recipeID int, memberIDs []int) ([]models.DietConflict, error) {
+	return checkRecipeForMembers(s.DB, recipeID, memberIDs)
+}
+
+func (s *SQLStore) CreateMealPlan(m *models.MealPlan, memberIDs []int) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(
+		"INSERT INTO meal_plan (date, meal_type, recipe_id, servings, is_leftovers, guest_count) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		m.Date, m.MealType, m.RecipeID, m.Servings, m.IsLeftovers, m.GuestCount,
+	).Scan(&m.ID)
+	if err != nil {
+		return err
+	}
+
+	if memberIDs != nil {
+		if err := setAttendees(tx, m.ID, memberIDs); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) DeleteMealPlan(id int) error {
+	_, err := s.DB.Exec("DELETE FROM meal_plan WHERE id = $1", id)
+	return err
+}
+
+func (s *SQLStore) CookMeal(req CookRequest) (CookResult, error) {
+	var res CookResult
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return res, err
+	}
+	defer tx.Rollback()
+
+	// 1. Check current status and get recipe ID
+	var recipeID sql.NullInt64
+	var isCooked bool
+	var planned sql.NullFloat64
+	var plannedBatches float64
+	err = tx.QueryRow(`
+		SELECT mp.recipe_id, COALESCE(mp.is_cooked, FALSE), s.servings, s.batches, COALESCE(mp.is_leftovers, FALSE)
+		FROM meal_plan mp
+		JOIN meal_plan_servings s ON s.meal_plan_id = mp.id
+		WHERE mp.id = $1
+		FOR UPDATE OF mp
+	`, req.ID).Scan(&recipeID, &isCooked, &planned, &plannedBatches, &res.AteLeftovers)
+	if err != nil {
+		return res, err
+	}
+	if isCooked {
+		return res, errMealCooked
+	}
+	if !recipeID.Valid {
+		return res, errMealNoRecipe
+	}
+
+	eaten := req.ServingsEaten
+	if eaten == nil && planned.Valid {
+		eaten = &planned.Float64
+	}
+	batches := req.Batches
+	if batches == 0 {
+		batches = math.Max(plannedBatches, 1)
+	}
+
+	// 2. Mark as cooked
+	if _, err := tx.Exec("UPDATE meal_plan SET is_cooked = TRUE WHERE id = $1", req.ID); err != nil {
+		return res, err
+	}
+
+	if res.AteLeftovers {
+		// 3a. Eat leftovers, all of them unless servings are given
+		want := math.Inf(1)
+		if eaten != nil {
+			want = *eaten
+		}
+		if res.LeftoverServingsUsed, err = takePreparedServings(tx, int(recipeID.Int64), want); err != nil {
+			return res, err
+		}
+		if res.LeftoverServingsUsed == 0 {
+			return res, errNoLeftovers
+		}
+		return res, tx.Commit()
+	}
+
+	// 3. Decrement Inventory
+	// Only decrement for tracked ingredients; sub-recipes come from prepared food or are expanded
+	// and substitutes stand in for ingredients that run short
+	if res.Substitutions, err = consumeRecipe(tx, int(recipeID.Int64), batches); err != nil {
+		return res, err
+	}
+
+	// 4. Keep what was not eaten as leftovers
+	var servings int
+	if err := tx.QueryRow("SELECT GREATEST(COALESCE(servings, 1), 1) FROM recipes WHERE id = $1", recipeID.Int64).Scan(&servings); err != nil {
+		return res, err
+	}
+	if made := batches * float64(servings); eaten != nil && *eaten < made {
+		mealID := req.ID
+		leftover := &models.PreparedFood{RecipeID: int(recipeID.Int64), Kind: "leftover", MealPlanID: &mealID, Servings: made - *eaten}
+		err = tx.QueryRow(
+			`INSERT INTO prepared_foods (recipe_id, kind, meal_plan_id, servings, prepared_on, expiry_date)
+			 VALUES ($1, 'leftover', $2, $3, $4, $5)
+			 RETURNING id, prepared_on, expiry_date`,
+			leftover.RecipeID, mealID, leftover.Servings, today(), today().AddDate(0, 0, req.LeftoverDays),
+		).Scan(&leftover.ID, &leftover.PreparedOn, &leftover.ExpiryDate)
+		if err != nil {
+			return res, err
+		}
+		res.Leftover = leftover
+	}
+
+	return res, tx.Commit()
+}
+
+func (s *SQLStore) ShoppingList(threshold float64, includeCovered bool) ([]ShoppingItem, error) {
+	// Show tracked items where stock left after upcoming meals is below threshold
+	rows, err := s.DB.Query(`
+		WITH planned AS (
+			SELECT e.ingredient_id, SUM(e.quantity * s.batches) AS planned
+			FROM recipe_ingredients_expanded e
+			JOIN meal_plan mp ON e.recipe_id = mp.recipe_id
+			JOIN meal_plan_servings s ON s.meal_plan_id = mp.id
+			WHERE mp.date >= $3::DATE AND NOT COALESCE(mp.is_cooked, FALSE)
+			GROUP BY e.ingredient_id
+		)
+		SELECT name, current_stock, unit, estimated_cost, planned, covered_by
+		FROM (
+			SELECT
+				i.name,
+				i.current_stock,
+				COALESCE(i.unit, '') as unit,
+				COALESCE(i.price, 0) as estimated_cost,
+				COALESCE(p.planned, 0) as planned,
+				-- A substitute covers the item when it can make up the shortfall
+				-- and still stay above the threshold after its own planned use
+				COALESCE((
+					SELECT sub.name
+					FROM ingredient_substitutes s
+					JOIN ingredients sub ON s.substitute_id = sub.id
+					LEFT JOIN planned sp ON sp.ingredient_id = sub.id
+					WHERE s.ingredient_id = i.id AND s.recipe_id IS NULL AND sub.is_tracked = TRUE
+					AND sub.current_stock - COALESCE(sp.planned, 0)
+						- GREATEST(COALESCE(p.planned, 0) - i.current_stock, 0) * s.ratio >= $2
+					ORDER BY s.id
+					LIMIT 1
+				), '') as covered_by
+			FROM ingredients i
+			LEFT JOIN planned p ON p.ingredient_id = i.id
+			WHERE i.current_stock - COALESCE(p.planned, 0) < $2
+			AND i.is_tracked = TRUE
+		) l
+		WHERE $1 OR l.covered_by = ''
+		ORDER BY l.current_stock - l.planned ASC
+	`, includeCovered, threshold, today())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []ShoppingItem
+	for rows.Next() {
+		var item ShoppingItem
+		if err := rows.Scan(&item.Name, &item.CurrentStock, &item.Unit, &item.EstimatedCost, &item.PlannedConsumption, &item.CoveredBy); err != nil {
+			return nil, err
+		}
+		list = append(list, item)
+	}
+	return list, rows.Err()
+}
@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/Kano-Chien/house_management/backend/models"
+)
+
+type substitute struct {
+	models.IngredientSubstitute
+	stock float64
+}
+
+// loadSubstitutes returns the tracked substitutes usable in a recipe, keyed by the
+// ingredient they replace, rules for this recipe before rules for every recipe.
+func loadSubstitutes(q queryer, recipeID int) (map[int][]substitute, error) {
+	rows, err := q.Query(`
+		SELECT s.id, s.ingredient_id, s.substitute_id, i.name, s.ratio, i.current_stock
+		FROM ingredient_substitutes s
+		JOIN ingredients i ON s.substitute_id = i.id
+		WHERE (s.recipe_id = $1 OR s.recipe_id IS NULL) AND i.is_tracked = TRUE
+		ORDER BY s.recipe_id IS NULL, s.id
+	`, recipeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subs := map[int][]substitute{}
+	for rows.Next() {
+		var s substitute
+		if err := rows.Scan(&s.ID, &s.IngredientID, &s.SubstituteID, &s.SubstituteName, &s.Ratio, &s.stock); err != nil {
+			return nil, err
+		}
+		subs[s.IngredientID] = append(subs[s.IngredientID], s)
+	}
+	return subs, rows.Err()
+}
+
+func (h *InventoryHandler) GetSubstitutes(w http.ResponseWriter, r *http.Request) {
+	// Optional filter: ?ingredient_id=5
+	where := ""
+	var args []interface{}
+	if ing := r.URL.Query().Get("ingredient_id"); ing != "" {
+		ingredientID, err := strconv.Atoi(ing)
+		if err != nil {
+			jsonError(w, "Invalid ingredient_id", http.StatusBadRequest)
+			return
+		}
+		where = "WHERE s.ingredient_id = $1"
+		args = append(args, ingredientID)
+	}
+
+	rows, err := h.DB.Query(`
+		SELECT s.id, s.ingredient_id, i.name, s.substitute_id, sub.name, s.ratio, s.recipe_id, COALESCE(r.name, ''), COALESCE(s.note, '')
+		FROM ingredient_substitutes s
+		JOIN ingredients i ON s.ingredient_id = i.id
+		JOIN ingredients sub ON s.substitute_id = sub.id
+		LEFT JOIN recipes r ON s.recipe_id = r.id
+		`+where+`
+		ORDER BY i.name, s.recipe_id NULLS FIRST, s.id
+	`, args...)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	subs := []models.IngredientSubstitute{}
+	for rows.Next() {
+		var s models.IngredientSubstitute
+		if err := rows.Scan(&s.ID, &s.IngredientID, &s.IngredientName, &s.SubstituteID, &s.SubstituteName, &s.Ratio, &s.RecipeID, &s.RecipeName, &s.Note); err != nil {
+			writeError(w, err)
+			return
+		}
+		subs = append(subs, s)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(subs)
+}
+
+// AddSubstitute creates a substitution rule, one per listed recipe or a single
+// rule for every recipe when recipe_ids is empty.
+func (h *InventoryHandler) AddSubstitute(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		IngredientID int     `json:"ingredient_id"`
+		SubstituteID int     `json:"substitute_id"`
+		Ratio        float64 `json:"ratio"` // Default 1
+		RecipeIDs    []int   `json:"recipe_ids"`
+		Note         string  `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Ratio == 0 {
+		req.Ratio = 1
+	}
+	var v validator
+	v.check(req.IngredientID > 0, "ingredient_id", "is required")
+	v.check(req.SubstituteID > 0, "substitute_id", "is required")
+	v.check(req.IngredientID != req.SubstituteID, "substitute_id", "cannot be the ingredient itself")
+	v.check(req.Ratio > 0, "ratio", "must be positive")
+	if v.failed(w) {
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	recipes := []*int{nil}
+	if len(req.RecipeIDs) > 0 {
+		recipes = recipes[:0]
+		for i := range req.RecipeIDs {
+			recipes = append(recipes, &req.RecipeIDs[i])
+		}
+	}
+	ids := []int{}
+	for _, recipeID := range recipes {
+		var id int
+		err := tx.QueryRow(
+			`INSERT INTO ingredient_substitutes (ingredient_id, substitute_id, ratio, recipe_id, note)
+			 VALUES ($1, $2, $3, $4, $5)
+			 ON CONFLICT (ingredient_id, substitute_id, COALESCE(recipe_id, 0))
+			 DO UPDATE SET ratio = EXCLUDED.ratio, note = EXCLUDED.note
+			 RETURNING id`,
+			req.IngredientID, req.SubstituteID, req.Ratio, recipeID, req.Note,
+		).Scan(&id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"ids": ids})
+}
+
+func (h *InventoryHandler) DeleteSubstitute(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err := h.DB.Exec("DELETE FROM ingredient_substitutes WHERE id = $1", req.ID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+}
+
+// recipeAvailability checks whether stock covers cooking a recipe, using
+// prepared food and substitutes the same way CookMeal does.
+func recipeAvailability(q queryer, recipeID int, name string, batches float64) (models.RecipeAvailability, error) {
+	plan := newCookPlan()
+	if err := planRecipe(newStockLedger(q), plan, recipeID, batches, 0); err != nil {
+		return models.RecipeAvailability{}, err
+	}
+
+	a := models.RecipeAvailability{
+		RecipeID: recipeID, Name: name, Batches: batches,
+		CanCook: len(plan.missing) == 0, Missing: []models.MissingIngredient{}, Substitutions: plan.substitutions,
+	}
+	// Components can be short of the same ingredient, list it once
+	seen := map[int]int{}
+	for _, m := range plan.missing {
+		if i, ok := seen[m.IngredientID]; ok {
+			a.Missing[i].Quantity += m.Quantity
+			continue
+		}
+		seen[m.IngredientID] = len(a.Missing)
+		a.Missing = append(a.Missing, m)
+	}
+	if a.Substitutions == nil {
+		a.Substitutions = []models.Substitution{}
+	}
+	return a, nil
+}
+
+// GetRecipeAvailability answers "can I cook this" for ?id= (or every recipe)
+// and ?batches= (default 1).
+func (h *RecipeHandler) GetRecipeAvailability(w http.ResponseWriter, r *http.Request) {
+	batches := 1.0
+	if v := r.URL.Query().Get("batches"); v != "" {
+		b, err := strconv.ParseFloat(v, 64)
+		if err != nil || b <= 0 {
+			jsonError(w, "Invalid batches", http.StatusBadRequest)
+			return
+		}
+		batches = b
+	}
+
+	query := "SELECT id, name FROM recipes ORDER BY name"
+	var args []interface{}
+	if v := r.URL.Query().Get("id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			jsonError(w, "Invalid id", http.StatusBadRequest)
+			return
+		}
+		query = "SELECT id, name FROM recipes WHERE id = $1"
+		args = append(args, id)
+	}
+
+	rows, err := h.DB.Query(query, args...)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	type recipe struct {
+		id   int
+		name string
+	}
+	var recipes []recipe
+	for rows.Next() {
+		var rc recipe
+		if err := rows.Scan(&rc.id, &rc.name); err != nil {
+			rows.Close()
+			writeError(w, err)
+			return
+		}
+		recipes = append(recipes, rc)
+	}
+	rows.Close()
+
+	results := []models.RecipeAvailability{}
+	for _, rc := range recipes {
+		a, err := recipeAvailability(h.DB, rc.id, rc.name, batches)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		results = append(results, a)
+	}
+	if len(args) > 0 && len(results) == 0 {
+		jsonError(w, "Recipe not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
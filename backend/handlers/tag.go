@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/Kano-Chien/house_management/backend/models"
+)
+
+type TagHandler struct {
+	DB *sql.DB
+}
+
+var tagKinds = map[string]bool{"cuisine": true, "course": true, "dietary": true, "other": true}
+
+// loadRecipeTags returns the tags of recipes, keyed by recipe ID. recipeID 0
+// loads every recipe.
+func loadRecipeTags(q queryer, recipeID int) (map[int][]models.Tag, error) {
+	rows, err := q.Query(`
+		SELECT rt.recipe_id, t.id, t.name, t.kind
+		FROM recipe_tags rt
+		JOIN tags t ON rt.tag_id = t.id
+		WHERE $1 = 0 OR rt.recipe_id = $1
+		ORDER BY t.kind, t.name
+	`, recipeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := map[int][]models.Tag{}
+	for rows.Next() {
+		var recipeID int
+		var t models.Tag
+		if err := rows.Scan(&recipeID, &t.ID, &t.Name, &t.Kind); err != nil {
+			return nil, err
+		}
+		tags[recipeID] = append(tags[recipeID], t)
+	}
+	return tags, rows.Err()
+}
+
+func (h *TagHandler) GetTags(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.Query(`
+		SELECT t.id, t.name, t.kind, COUNT(rt.recipe_id) as recipe_count
+		FROM tags t
+		LEFT JOIN recipe_tags rt ON rt.tag_id = t.id
+		GROUP BY t.id
+		ORDER BY t.kind, t.name
+	`)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	var tags []models.Tag
+	for rows.Next() {
+		var t models.Tag
+		if err := rows.Scan(&t.ID, &t.Name, &t.Kind, &t.RecipeCount); err != nil {
+			writeError(w, err)
+			return
+		}
+		tags = append(tags, t)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tags)
+}
+
+func (h *TagHandler) CreateTag(w http.ResponseWriter, r *http.Request) {
+	var t models.Tag
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if t.Name == "" {
+		jsonError(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	if t.Kind == "" {
+		t.Kind = "other"
+	}
+	if !tagKinds[t.Kind] {
+		jsonError(w, "kind must be cuisine, course, dietary or other", http.StatusBadRequest)
+		return
+	}
+
+	err := h.DB.QueryRow("INSERT INTO tags (name, kind) VALUES ($1, $2) RETURNING id", t.Name, t.Kind).Scan(&t.ID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(t)
+}
+
+func (h *TagHandler) DeleteTag(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.DB.Exec("DELETE FROM tags WHERE id = $1", req.ID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		jsonError(w, "Tag not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+}
+
+func (h *RecipeHandler) AddRecipeTag(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RecipeID int    `json:"recipe_id"`
+		TagID    int    `json:"tag_id"`
+		TagName  string `json:"tag_name"`
+		Kind     string `json:"kind"` // Only used when the tag has to be created
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// If no tag_id but a name is given, find or create the tag
+	if req.TagID == 0 && req.TagName != "" {
+		if req.Kind == "" {
+			req.Kind = "other"
+		}
+		if !tagKinds[req.Kind] {
+			jsonError(w, "kind must be cuisine, course, dietary or other", http.StatusBadRequest)
+			return
+		}
+		err := h.DB.QueryRow(
+			`INSERT INTO tags (name, kind) VALUES ($1, $2)
+			 ON CONFLICT (LOWER(name)) DO UPDATE SET name = tags.name
+			 RETURNING id`,
+			req.TagName, req.Kind,
+		).Scan(&req.TagID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	if req.TagID == 0 {
+		jsonError(w, "tag_id or tag_name required", http.StatusBadRequest)
+		return
+	}
+
+	_, err := h.DB.Exec(
+		"INSERT INTO recipe_tags (recipe_id, tag_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+		req.RecipeID, req.TagID,
+	)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "added", "tag_id": req.TagID})
+}
+
+func (h *RecipeHandler) RemoveRecipeTag(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RecipeID int `json:"recipe_id"`
+		TagID    int `json:"tag_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err := h.DB.Exec("DELETE FROM recipe_tags WHERE recipe_id = $1 AND tag_id = $2", req.RecipeID, req.TagID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "removed"})
+}
@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Kano-Chien/house_management/backend/models"
+)
+
+var wasteReasons = map[string]bool{"expired": true, "spoiled": true, "over-cooked": true, "other": true}
+
+// Discard throws away an ingredient or prepared food and logs it as waste.
+// Without a quantity everything that is left is discarded.
+func (h *InventoryHandler) Discard(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		IngredientID   int      `json:"ingredient_id"`
+		PreparedFoodID int      `json:"prepared_food_id"`
+		Quantity       *float64 `json:"quantity"` // Servings for prepared food
+		Reason         string   `json:"reason"`
+		Note           string   `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if (req.IngredientID == 0) == (req.PreparedFoodID == 0) {
+		jsonError(w, "ingredient_id or prepared_food_id required", http.StatusBadRequest)
+		return
+	}
+	if !wasteReasons[req.Reason] {
+		jsonError(w, "reason must be expired, spoiled, over-cooked or other", http.StatusBadRequest)
+		return
+	}
+	if req.Quantity != nil && *req.Quantity <= 0 {
+		jsonError(w, "quantity must be positive", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	entry := models.WasteEntry{Reason: req.Reason, Note: req.Note}
+	var stock, price float64
+	if req.IngredientID != 0 {
+		entry.IngredientID = &req.IngredientID
+		err = tx.QueryRow(
+			"SELECT name, current_stock, COALESCE(unit, ''), COALESCE(price, 0) FROM ingredients WHERE id = $1 FOR UPDATE",
+			req.IngredientID,
+		).Scan(&entry.Name, &stock, &entry.Unit, &price)
+		if err == sql.ErrNoRows {
+			jsonError(w, "Ingredient not found", http.StatusNotFound)
+			return
+		}
+	} else {
+		// Prepared food is valued at what its ingredients cost per serving today
+		var recipeID int
+		err = tx.QueryRow(`
+			SELECT p.recipe_id, r.name, p.servings,
+				COALESCE((
+					SELECT SUM(e.quantity * COALESCE(i.price, 0))
+					FROM recipe_ingredients_expanded e JOIN ingredients i ON e.ingredient_id = i.id
+					WHERE e.recipe_id = p.recipe_id
+				), 0) / GREATEST(COALESCE(r.servings, 1), 1)
+			FROM prepared_foods p
+			JOIN recipes r ON p.recipe_id = r.id
+			WHERE p.id = $1
+			FOR UPDATE OF p
+		`, req.PreparedFoodID).Scan(&recipeID, &entry.Name, &stock, &price)
+		if err == sql.ErrNoRows {
+			jsonError(w, "Prepared food not found", http.StatusNotFound)
+			return
+		}
+		entry.RecipeID = &recipeID
+		entry.Unit = "servings"
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	entry.Quantity = stock
+	if req.Quantity != nil {
+		entry.Quantity = *req.Quantity
+	}
+	if entry.Quantity <= 0 {
+		jsonError(w, "Nothing left to discard", http.StatusConflict)
+		return
+	}
+	if entry.Quantity > stock {
+		jsonError(w, "Cannot discard more than is in stock", http.StatusConflict)
+		return
+	}
+	entry.Value = entry.Quantity * price
+
+	if req.IngredientID != 0 {
+		_, err = tx.Exec("UPDATE ingredients SET current_stock = current_stock - $1 WHERE id = $2", entry.Quantity, req.IngredientID)
+		if err == nil {
+			_, err = tx.Exec(
+				"INSERT INTO inventory_history (ingredient_id, action, quantity_change, note) VALUES ($1, 'discard', $2, $3)",
+				req.IngredientID, -entry.Quantity, req.Reason,
+			)
+		}
+	} else if entry.Quantity == stock {
+		_, err = tx.Exec("DELETE FROM prepared_foods WHERE id = $1", req.PreparedFoodID)
+	} else {
+		_, err = tx.Exec("UPDATE prepared_foods SET servings = servings - $1 WHERE id = $2", entry.Quantity, req.PreparedFoodID)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	err = tx.QueryRow(
+		`INSERT INTO waste_log (ingredient_id, recipe_id, name, quantity, unit, value, reason, note)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id, discarded_at`,
+		entry.IngredientID, entry.RecipeID, entry.Name, entry.Quantity, entry.Unit, entry.Value, entry.Reason, entry.Note,
+	).Scan(&entry.ID, &entry.DiscardedAt)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(entry)
+}
+
+// wastePeriod reads ?from= and ?to= (YYYY-MM-DD, inclusive), defaulting to the last 12 months.
+func wastePeriod(r *http.Request) (from, to time.Time, err error) {
+	to = today()
+	from = to.AddDate(-1, 0, 0)
+	if v := r.URL.Query().Get("from"); v != "" {
+		if from, err = time.Parse("2006-01-02", v); err != nil {
+			return
+		}
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		if to, err = time.Parse("2006-01-02", v); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (h *InventoryHandler) GetWaste(w http.ResponseWriter, r *http.Request) {
+	from, to, err := wastePeriod(r)
+	if err != nil {
+		jsonError(w, "Invalid date format. Use YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := h.DB.Query(`
+		SELECT id, ingredient_id, recipe_id, name, quantity, COALESCE(unit, ''), COALESCE(value, 0), reason, COALESCE(note, ''), discarded_at
+		FROM waste_log
+		WHERE discarded_at >= $1 AND discarded_at < $2::DATE + 1
+		ORDER BY discarded_at DESC, id DESC
+	`, from, to)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	entries := []models.WasteEntry{}
+	for rows.Next() {
+		var e models.WasteEntry
+		if err := rows.Scan(&e.ID, &e.IngredientID, &e.RecipeID, &e.Name, &e.Quantity, &e.Unit, &e.Value, &e.Reason, &e.Note, &e.DiscardedAt); err != nil {
+			writeError(w, err)
+			return
+		}
+		entries = append(entries, e)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// GetWasteReport sums waste value per month and ranks the most wasted items
+// (by value, ?limit= default 10) over the ?from=&to= period.
+func (h *InventoryHandler) GetWasteReport(w http.ResponseWriter, r *http.Request) {
+	from, to, err := wastePeriod(r)
+	if err != nil {
+		jsonError(w, "Invalid date format. Use YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	limit := 10
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			jsonError(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	type MonthTotal struct {
+		Month    string             `json:"month"` // YYYY-MM
+		Value    float64            `json:"value"`
+		Count    int                `json:"count"`
+		ByReason map[string]float64 `json:"by_reason"`
+	}
+	type WastedItem struct {
+		Name     string  `json:"name"`
+		Quantity float64 `json:"quantity"`
+		Unit     string  `json:"unit"`
+		Value    float64 `json:"value"`
+		Times    int     `json:"times"`
+	}
+
+	rows, err := h.DB.Query(`
+		SELECT TO_CHAR(discarded_at, 'YYYY-MM') as month, reason, SUM(COALESCE(value, 0)), COUNT(*)
+		FROM waste_log
+		WHERE discarded_at >= $1 AND discarded_at < $2::DATE + 1
+		GROUP BY month, reason
+		ORDER BY month
+	`, from, to)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	months := []*MonthTotal{}
+	total := 0.0
+	for rows.Next() {
+		var month, reason string
+		var value float64
+		var count int
+		if err := rows.Scan(&month, &reason, &value, &count); err != nil {
+			writeError(w, err)
+			return
+		}
+		if len(months) == 0 || months[len(months)-1].Month != month {
+			months = append(months, &MonthTotal{Month: month, ByReason: map[string]float64{}})
+		}
+		m := months[len(months)-1]
+		m.Value += value
+		m.Count += count
+		m.ByReason[reason] = value
+		total += value
+	}
+	rows.Close()
+
+	rows, err = h.DB.Query(`
+		SELECT name, SUM(quantity), COALESCE(unit, ''), SUM(COALESCE(value, 0)) as total_value, COUNT(*)
+		FROM waste_log
+		WHERE discarded_at >= $1 AND discarded_at < $2::DATE + 1
+		GROUP BY COALESCE(ingredient_id, -recipe_id), name, unit
+		ORDER BY total_value DESC, SUM(quantity) DESC
+		LIMIT $3
+	`, from, to, limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	items := []WastedItem{}
+	for rows.Next() {
+		var it WastedItem
+		if err := rows.Scan(&it.Name, &it.Quantity, &it.Unit, &it.Value, &it.Times); err != nil {
+			writeError(w, err)
+			return
+		}
+		items = append(items, it)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"from":        from.Format("2006-01-02"),
+		"to":          to.Format("2006-01-02"),
+		"total_value": total,
+		"months":      months,
+		"most_wasted": items,
+	})
+}
@@ -1,205 +1,150 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"database/sql"
-	"fmt"
-	"log"
+	"errors"
+	"flag"
+	"log/slog"
 	"net/http"
 	"os"
-	"strings"
-
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Kano-Chien/house_management/backend/config"
+	"github.com/Kano-Chien/house_management/backend/database"
 	"github.com/Kano-Chien/house_management/backend/handlers"
-	_ "github.com/lib/pq"
+	"github.com/Kano-Chien/house_management/backend/metrics"
 )
 
+// Usage: server [flags] [command]. Without a command main runs the server;
+// "backup [-o file]" writes a backup archive and "restore [-replace] file"
+// loads one, see package backup. -h lists the flags, see package config.
 func main() {
-	loadEnv(".env")
-
-	// Database connection string
-	connStr := os.Getenv("DATABASE_URL")
-	if connStr == "" {
-		connStr = "user=house_user password=house_pass dbname=house_management sslmode=disable"
+	cfg, args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	} else if err != nil {
+		fatal("Invalid configuration", "error", err)
 	}
+	time.Local = cfg.Location
+	slog.SetDefault(newLogger(cfg))
+	slog.Info("Configuration loaded", "config", cfg)
 
-	db, err := sql.Open("postgres", connStr)
+	// A sqlite: URL keeps everything in one file, see database.Open
+	db, err := database.Open(cfg.DatabaseURL)
 	if err != nil {
-		log.Fatal(err)
+		fatal("Cannot open database", "error", err)
 	}
 	defer db.Close()
 
-	if err = db.Ping(); err != nil {
-		log.Fatal("Cannot connect to database:", err)
-	}
-	fmt.Println("Connected to the database successfully.")
-
-	// Execute Schema
-	schemaContent, err := os.ReadFile("database/schema.sql")
-	if err != nil {
-		log.Fatal("Error reading schema file:", err)
-	}
-	if _, err := db.Exec(string(schemaContent)); err != nil {
-		log.Fatal("Error executing schema:", err)
-	}
-	fmt.Println("Database schema applied successfully.")
-
-	// Initialize Handlers
-	inventoryHandler := &handlers.InventoryHandler{DB: db}
-	recipeHandler := &handlers.RecipeHandler{DB: db}
-	mealPlanHandler := &handlers.MealPlanHandler{DB: db}
-	shoppingListHandler := &handlers.ShoppingListHandler{DB: db}
-	lineNotifyHandler := &handlers.LineNotifyHandler{DB: db}
-
-	// Router setup - using path-only patterns with method checks
-	mux := http.NewServeMux()
-
-	mux.HandleFunc("/api/inventory", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case "GET":
-			inventoryHandler.GetInventory(w, r)
-		case "POST":
-			inventoryHandler.AddIngredient(w, r)
+	if len(args) > 0 {
+		migrate(db)
+		switch args[0] {
+		case "backup":
+			runBackup(db, args[1:])
+		case "restore":
+			runRestore(db, args[1:])
 		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			fatal("Unknown command, expected backup or restore", "command", args[0])
 		}
-	})
-
-	mux.HandleFunc("/api/inventory/stock", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == "PUT" {
-			inventoryHandler.UpdateStock(w, r)
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
-
-	mux.HandleFunc("/api/inventory/edit", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == "PUT" {
-			inventoryHandler.EditIngredient(w, r)
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
-
-	mux.HandleFunc("/api/inventory/delete", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == "POST" {
-			inventoryHandler.DeleteIngredient(w, r)
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
-
-	mux.HandleFunc("/api/recipes", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case "GET":
-			recipeHandler.GetRecipes(w, r)
-		case "POST":
-			recipeHandler.CreateRecipe(w, r)
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
-
-	mux.HandleFunc("/api/recipes/ingredients", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case "GET":
-			recipeHandler.GetRecipeIngredients(w, r)
-		case "POST":
-			recipeHandler.AddRecipeIngredient(w, r)
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
-
-	mux.HandleFunc("/api/recipes/ingredients/remove", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == "POST" {
-			recipeHandler.RemoveRecipeIngredient(w, r)
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
-
-	mux.HandleFunc("/api/recipes/delete", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == "POST" {
-			recipeHandler.DeleteRecipe(w, r)
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
-
-	mux.HandleFunc("/api/recipes/edit", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == "PUT" {
-			recipeHandler.UpdateRecipeName(w, r)
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
-
-	mux.HandleFunc("/api/recipes/ingredients/edit", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == "PUT" {
-			recipeHandler.UpdateIngredientQuantity(w, r)
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
-
-	mux.HandleFunc("/api/mealplan", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case "GET":
-			mealPlanHandler.GetMealPlan(w, r)
-		case "POST":
-			mealPlanHandler.ScheduleMeal(w, r)
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
+		return
+	}
 
-	mux.HandleFunc("/api/mealplan/delete", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == "POST" {
-			mealPlanHandler.DeleteMealPlan(w, r)
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
+	// The first SIGINT or SIGTERM shuts down gracefully, a second one kills
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Probes are answered while the schema is still being applied; /readyz
+	// only says yes once it is
+	health := &handlers.HealthHandler{DB: db}
+	mux := newRouter(db, handlers.NewSQLStore(db), *cfg)
+	mux.HandleFunc("GET /healthz", health.Live)
+	mux.HandleFunc("GET /readyz", health.Ready)
+	metrics.RegisterDB(db, cfg.LowStockThreshold)
+	mux.Handle("GET /metrics", metrics.Handler())
+
+	handler := enableCORS(cfg.CORSOrigins, handlers.JSONErrors(mux))
+	handler = handlers.RequestID(handlers.AccessLog(handlers.Instrument(mux, handler)))
+
+	srv := &http.Server{
+		Addr:              cfg.ListenAddr,
+		Handler:           handler,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       time.Minute, // Backup archives are uploaded in one request
+		WriteTimeout:      2 * time.Minute,
+		IdleTimeout:       2 * time.Minute,
+	}
+	served := make(chan error, 1)
+	go func() { served <- srv.ListenAndServe() }()
+	slog.Info("Server starting", "addr", cfg.ListenAddr)
 
-	mux.HandleFunc("/api/mealplan/cook", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == "POST" {
-			mealPlanHandler.CookMeal(w, r)
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
+	migrate(db)
+	health.Serving.Store(true)
 
-	mux.HandleFunc("/api/shopping-list", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == "GET" {
-			shoppingListHandler.GetShoppingList(w, r)
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
+	var jobs sync.WaitGroup
+	startBackups(ctx, &jobs, db, cfg)
 
-	// CORS Middleware
-	mux.HandleFunc("/api/line/send-shopping-list", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == "POST" {
-			lineNotifyHandler.SendShoppingList(w, r)
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
+	select {
+	case err := <-served:
+		fatal("Server failed", "error", err)
+	case <-ctx.Done():
+	}
+	stop()
+	slog.Info("Shutting down")
+
+	health.Serving.Store(false)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("Requests still running at shutdown", "timeout", cfg.ShutdownTimeout, "error", err)
+	}
+	jobs.Wait()
+	slog.Info("Server stopped")
+}
 
-	handler := enableCORS(mux)
+// migrate checks the database connection and applies the schema.
+func migrate(db *sql.DB) {
+	if err := db.Ping(); err != nil {
+		fatal("Cannot connect to database", "error", err)
+	}
+	if err := database.Migrate(db); err != nil {
+		fatal("Error executing schema", "error", err)
+	}
+	slog.Info("Database schema applied", "sqlite", database.IsSQLite(db))
+}
 
-	// Start Server
-	port := ":8080"
-	fmt.Printf("Server starting on port %s\n", port)
-	if err := http.ListenAndServe(port, handler); err != nil {
-		log.Fatal(err)
+func newLogger(cfg *config.Config) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: cfg.LogLevel}
+	if cfg.LogFormat == "json" {
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts))
 	}
+	return slog.New(slog.NewTextHandler(os.Stderr, opts))
+}
+
+// fatal logs an error and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
 
-func enableCORS(next http.Handler) http.Handler {
+// enableCORS lets the listed origins call the API from the browser; "*" lets any.
+func enableCORS(origins []string, next http.Handler) http.Handler {
+	anyOrigin := slices.Contains(origins, "*")
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		if anyOrigin {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			if origin := r.Header.Get("Origin"); slices.Contains(origins, origin) {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			w.Header().Add("Vary", "Origin")
+		}
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 
 		if r.Method == "OPTIONS" {
@@ -210,24 +155,3 @@ func enableCORS(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
-func loadEnv(path string) {
-	fmt.Printf("Loading .env from: %s\n", path)
-	f, err := os.Open(path)
-	if err != nil {
-		fmt.Printf("Error open .env: %v\n", err)
-		return
-	}
-	defer f.Close()
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) == 2 {
-			os.Setenv(parts[0], parts[1])
-			fmt.Printf("Loaded env: %s\n", parts[0])
-		}
-	}
-}
@@ -0,0 +1,135 @@
+// Package metrics holds the Prometheus metrics served on /metrics: HTTP
+// traffic, the database pool, LINE API calls, scheduled jobs and a few
+// household gauges worked out from the database on every scrape.
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "house"
+
+// Registry has the process and Go runtime collectors and the metrics below.
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Name: "http_requests_total",
+		Help: "HTTP requests by route pattern, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace, Name: "http_request_duration_seconds",
+		Help:    "Time to answer HTTP requests by route pattern and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	LineCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Name: "line_api_calls_total",
+		Help: "LINE Messaging API calls by endpoint and outcome (ok, rejected, error).",
+	}, []string{"endpoint", "outcome"})
+
+	JobRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Name: "scheduler_job_runs_total",
+		Help: "Runs of scheduled jobs by job and outcome (ok, error).",
+	}, []string{"job", "outcome"})
+
+	JobLastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace, Name: "scheduler_job_last_success_timestamp_seconds",
+		Help: "Unix time of the last successful run of each scheduled job.",
+	}, []string{"job"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewGoCollector(),
+		HTTPRequests, HTTPDuration, LineCalls, JobRuns, JobLastSuccess,
+	)
+}
+
+// Handler serves the metrics in the Prometheus text format. A household gauge
+// whose query fails is left out rather than failing the whole scrape.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError})
+}
+
+// RegisterDB adds the connection pool statistics and the household gauges of
+// db. lowStock is the shopping list threshold, see config.Config.
+func RegisterDB(db *sql.DB, lowStock float64) {
+	Registry.MustRegister(collectors.NewDBStatsCollector(db, "house"), &householdCollector{db: db, lowStock: lowStock})
+}
+
+// JobRun counts a run of a scheduled job.
+func JobRun(job string, err error) {
+	if err != nil {
+		JobRuns.WithLabelValues(job, "error").Inc()
+		return
+	}
+	JobRuns.WithLabelValues(job, "ok").Inc()
+	JobLastSuccess.WithLabelValues(job).SetToCurrentTime()
+}
+
+var (
+	belowReorderDesc = prometheus.NewDesc(namespace+"_ingredients_below_reorder_point",
+		"Tracked ingredients whose stock is below the shopping list threshold.", nil, nil)
+	expiringDesc = prometheus.NewDesc(namespace+"_expiring_items",
+		"Ingredients and prepared food in stock that expire within 7 days or have expired, by kind.", []string{"kind"}, nil)
+	mealsDesc = prometheus.NewDesc(namespace+"_meals",
+		"Planned meals not cooked yet from today on (planned) and meals cooked in the last 7 days (cooked).", []string{"state"}, nil)
+)
+
+// householdCollector queries the household gauges when metrics are scraped.
+type householdCollector struct {
+	db       *sql.DB
+	lowStock float64
+}
+
+func (c *householdCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- belowReorderDesc
+	ch <- expiringDesc
+	ch <- mealsDesc
+}
+
+func (c *householdCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	gauge := func(desc *prometheus.Desc, query string, args []interface{}, labels ...string) {
+		var n float64
+		if err := c.db.QueryRowContext(ctx, query, args...).Scan(&n); err != nil {
+			slog.Warn("Metrics query failed", "metric", desc.String(), "error", err)
+			ch <- prometheus.NewInvalidMetric(desc, err)
+			return
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, n, labels...)
+	}
+
+	// The configured time zone decides what today is, not the database's
+	y, m, d := time.Now().Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+
+	gauge(belowReorderDesc, `SELECT COUNT(*) FROM ingredients WHERE is_tracked = TRUE AND current_stock < $1`,
+		[]interface{}{c.lowStock})
+	// The same items as the expiring list with ?days=7
+	gauge(expiringDesc, `
+		SELECT COUNT(*) FROM ingredients
+		WHERE expiry_date IS NOT NULL AND current_stock > 0 AND expiry_date <= $1::DATE`,
+		[]interface{}{today.AddDate(0, 0, 7)}, "ingredient")
+	gauge(expiringDesc, `
+		SELECT COUNT(*) FROM prepared_foods
+		WHERE expiry_date IS NOT NULL AND servings > 0 AND expiry_date <= $1::DATE`,
+		[]interface{}{today.AddDate(0, 0, 7)}, "prepared")
+	gauge(mealsDesc, `SELECT COUNT(*) FROM meal_plan WHERE date >= $1::DATE AND NOT COALESCE(is_cooked, FALSE)`,
+		[]interface{}{today}, "planned")
+	gauge(mealsDesc, `SELECT COUNT(*) FROM meal_plan WHERE is_cooked = TRUE AND date >= $1::DATE`,
+		[]interface{}{today.AddDate(0, 0, -7)}, "cooked")
+}
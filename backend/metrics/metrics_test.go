@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/Kano-Chien/house_management/backend/database"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestHouseholdCollector(t *testing.T) {
+	db, err := database.Open("sqlite:" + filepath.Join(t.TempDir(), "house.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := database.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO ingredients (name, current_stock, is_tracked, expiry_date) VALUES ('Milk', 1, TRUE, date('now', '+2 days'))`,
+		`INSERT INTO ingredients (name, current_stock, is_tracked, expiry_date) VALUES ('Rice', 500, TRUE, date('now', '+30 days'))`,
+		`INSERT INTO ingredients (name, current_stock, is_tracked) VALUES ('Salt', 0, FALSE)`,
+		`INSERT INTO meal_plan (date, meal_type) VALUES (date('now', '+1 day'), 'Dinner')`,
+		`INSERT INTO meal_plan (date, meal_type, is_cooked) VALUES (date('now', '-1 day'), 'Lunch', TRUE)`,
+		`INSERT INTO meal_plan (date, meal_type, is_cooked) VALUES (date('now', '-20 days'), 'Lunch', TRUE)`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(&householdCollector{db: db, lowStock: 3})
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]float64{}
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			name := f.GetName()
+			for _, l := range m.GetLabel() {
+				name += "/" + l.GetValue()
+			}
+			got[name] = m.GetGauge().GetValue()
+		}
+	}
+	want := map[string]float64{
+		"house_ingredients_below_reorder_point": 1,
+		"house_expiring_items/ingredient":       1,
+		"house_expiring_items/prepared":         0,
+		"house_meals/planned":                   1,
+		"house_meals/cooked":                    1,
+	}
+	for name, v := range want {
+		if got[name] != v {
+			t.Errorf("%s = %v, want %v (all: %v)", name, got[name], v, got)
+		}
+	}
+}
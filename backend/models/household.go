@@ -0,0 +1,23 @@
+package models
+
+type HouseholdMember struct {
+	ID           int           `json:"id"`
+	Name         string        `json:"name"`
+	IsGuest      bool          `json:"is_guest"`    // Guests are only checked for meals they attend
+	LineLinked   bool          `json:"line_linked"` // Read-only, see HouseholdHandler.LinkLine
+	Restrictions []Restriction `json:"restrictions"`
+}
+
+type Restriction struct {
+	Name   string `json:"name"`   // An ingredient flag (allergy) or a diet
+	Strict *bool  `json:"strict"` // Strict restrictions block scheduling; default true for allergies, false for diets
+}
+
+// DietConflict is a recipe that breaks one restriction of one member.
+type DietConflict struct {
+	MemberID    int      `json:"member_id"`
+	MemberName  string   `json:"member_name"`
+	Restriction string   `json:"restriction"`
+	Ingredients []string `json:"ingredients"`
+	Blocking    bool     `json:"blocking"`
+}
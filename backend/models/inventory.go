@@ -11,5 +11,28 @@ type Ingredient struct {
 	Price              float64    `json:"price"`
 	Category           string     `json:"category"`
 	IsTracked          bool       `json:"is_tracked"`
-	PlannedConsumption float64    `json:"planned_consumption"` // Calculated, not stored directly
+	LocationID         *int       `json:"location_id"`
+	LocationName       string     `json:"location_name,omitempty"` // For display
+	Flags              []string   `json:"flags,omitempty"`         // Allergens and diet-relevant contents
+	Aliases            []string   `json:"aliases,omitempty"`       // Other names used when looking the ingredient up
+	PlannedConsumption float64    `json:"planned_consumption"`     // Calculated, not stored directly
+}
+
+// ExpiryAlert is an ingredient or prepared food (batch or leftover) that expires soon.
+type ExpiryAlert struct {
+	Kind       string    `json:"kind"` // ingredient, batch or leftover
+	ID         int       `json:"id"`   // Ingredient or prepared food ID
+	Name       string    `json:"name"`
+	Quantity   float64   `json:"quantity"`
+	Unit       string    `json:"unit"` // Servings for prepared food
+	ExpiryDate time.Time `json:"expiry_date"`
+	DaysLeft   int       `json:"days_left"` // Negative once expired
+}
+
+// IngredientMatch is an existing ingredient whose name or alias looks like a searched name.
+type IngredientMatch struct {
+	ID        int     `json:"id"`
+	Name      string  `json:"name"`
+	MatchedOn string  `json:"matched_on"` // The name or alias that matched
+	Score     float64 `json:"score"`      // 1 is an exact match
 }
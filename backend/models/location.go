@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+type Location struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	ItemCount   int    `json:"item_count"` // Calculated, not stored directly
+}
+
+type InventoryHistory struct {
+	ID               int       `json:"id"`
+	IngredientID     int       `json:"ingredient_id"`
+	IngredientName   string    `json:"ingredient_name,omitempty"` // For display
+	Action           string    `json:"action"`                    // move, stocktake, import
+	QuantityChange   float64   `json:"quantity_change"`
+	FromLocationID   *int      `json:"from_location_id"`
+	FromLocationName string    `json:"from_location_name,omitempty"` // For display
+	ToLocationID     *int      `json:"to_location_id"`
+	ToLocationName   string    `json:"to_location_name,omitempty"` // For display
+	Note             string    `json:"note"`
+	CreatedAt        time.Time `json:"created_at"`
+}
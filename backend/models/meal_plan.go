@@ -3,10 +3,22 @@ package models
 import "time"
 
 type MealPlan struct {
-	ID         int       `json:"id"`
-	Date       time.Time `json:"date"`
-	MealType   string    `json:"meal_type"` // Lunch, Dinner
-	RecipeID   *int      `json:"recipe_id"`
-	RecipeName string    `json:"recipe_name,omitempty"` // For display
-	IsCooked   bool      `json:"is_cooked"`
+	ID          int       `json:"id"`
+	Date        time.Time `json:"date"`
+	MealType    string    `json:"meal_type"` // Lunch, Dinner
+	RecipeID    *int      `json:"recipe_id"`
+	RecipeName  string    `json:"recipe_name,omitempty"` // For display
+	IsCooked    bool      `json:"is_cooked"`
+	Servings    *float64  `json:"servings"`     // Servings eaten, null means the whole batch
+	IsLeftovers bool      `json:"is_leftovers"` // Eat leftovers of the recipe instead of cooking it
+	GuestCount  int       `json:"guest_count"`  // Guests who are not household members
+	// Servings from explicit servings or attendance; null means the whole batch
+	PlannedServings *float64 `json:"planned_servings"`
+}
+
+type MealAttendee struct {
+	MemberID  int    `json:"member_id"`
+	Name      string `json:"name"`
+	IsGuest   bool   `json:"is_guest"`
+	Attending bool   `json:"attending"`
 }
@@ -0,0 +1,44 @@
+package models
+
+// Nutrition facts. Sodium is in mg, energy in kcal and the rest in grams.
+type Nutrition struct {
+	Kcal    float64 `json:"kcal"`
+	Protein float64 `json:"protein"`
+	Fat     float64 `json:"fat"`
+	Carbs   float64 `json:"carbs"`
+	Fibre   float64 `json:"fibre"`
+	Sodium  float64 `json:"sodium"`
+}
+
+// Add adds factor times o to n.
+func (n *Nutrition) Add(o Nutrition, factor float64) {
+	n.Kcal += o.Kcal * factor
+	n.Protein += o.Protein * factor
+	n.Fat += o.Fat * factor
+	n.Carbs += o.Carbs * factor
+	n.Fibre += o.Fibre * factor
+	n.Sodium += o.Sodium * factor
+}
+
+type IngredientNutrition struct {
+	IngredientID int      `json:"ingredient_id"`
+	Name         string   `json:"name,omitempty"` // For display
+	Unit         string   `json:"unit,omitempty"` // For display
+	Basis        string   `json:"basis"`          // 100g or unit
+	GramsPerUnit *float64 `json:"grams_per_unit"` // Weight of one stock unit, for the 100g basis
+	Source       string   `json:"source"`         // manual or dataset
+	Nutrition
+}
+
+type RecipeNutrition struct {
+	Servings   int       `json:"servings"`
+	Total      Nutrition `json:"total"` // One batch
+	PerServing Nutrition `json:"per_serving"`
+	Missing    []string  `json:"missing"` // Ingredients without usable nutrition data
+}
+
+type DayNutrition struct {
+	Date      string    `json:"date"` // YYYY-MM-DD
+	Meals     int       `json:"meals"`
+	Nutrition Nutrition `json:"nutrition"`
+}
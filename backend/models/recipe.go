@@ -1,11 +1,19 @@
 package models
 
+import "time"
+
 type Recipe struct {
 	ID           int                `json:"id"`
 	Name         string             `json:"name"`
 	Instructions string             `json:"instructions"`
 	Notes        string             `json:"notes"`
+	Servings     int                `json:"servings"` // Servings one batch makes
 	Ingredients  []RecipeIngredient `json:"ingredients,omitempty"`
+	Steps        []RecipeStep       `json:"steps,omitempty"`
+	Tags         []Tag              `json:"tags,omitempty"`
+	Components   []RecipeComponent  `json:"components,omitempty"`
+	Nutrition    *RecipeNutrition   `json:"nutrition,omitempty"`
+	Conflicts    []DietConflict     `json:"conflicts,omitempty"` // With the profiles of the people eating
 }
 
 type RecipeIngredient struct {
@@ -14,3 +22,41 @@ type RecipeIngredient struct {
 	Quantity     float64 `json:"quantity"`
 	Unit         string  `json:"unit,omitempty"` // For display
 }
+
+type RecipeStep struct {
+	ID              int                `json:"id"`
+	RecipeID        int                `json:"recipe_id"`
+	Position        int                `json:"position"`
+	Text            string             `json:"text"`
+	DurationSeconds *int               `json:"duration_seconds"` // Optional timer
+	Temperature     *float64           `json:"temperature"`      // Optional oven/pan temperature
+	TemperatureUnit string             `json:"temperature_unit"` // C or F
+	IngredientIDs   []int              `json:"ingredient_ids"`
+	Ingredients     []RecipeIngredient `json:"ingredients,omitempty"` // For display
+}
+
+type Tag struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Kind        string `json:"kind"`                   // cuisine, course, dietary, other
+	RecipeCount int    `json:"recipe_count,omitempty"` // Calculated, not stored directly
+}
+
+type RecipeComponent struct {
+	ComponentRecipeID int     `json:"component_recipe_id"`
+	Name              string  `json:"name,omitempty"` // For display
+	Quantity          float64 `json:"quantity"`
+	Unit              string  `json:"unit"`               // serving or batch
+	Servings          int     `json:"servings,omitempty"` // Servings per batch of the component, for display
+}
+
+type PreparedFood struct {
+	ID         int        `json:"id"`
+	RecipeID   int        `json:"recipe_id"`
+	RecipeName string     `json:"recipe_name,omitempty"`  // For display
+	Kind       string     `json:"kind"`                   // batch or leftover
+	MealPlanID *int       `json:"meal_plan_id,omitempty"` // Meal that produced a leftover
+	Servings   float64    `json:"servings"`
+	PreparedOn time.Time  `json:"prepared_on"`
+	ExpiryDate *time.Time `json:"expiry_date,omitempty"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+type Stocktake struct {
+	ID           int        `json:"id"`
+	Status       string     `json:"status"` // open, committed, cancelled
+	Category     *string    `json:"category"`
+	LocationID   *int       `json:"location_id"`
+	Reason       string     `json:"reason"`
+	StartedAt    time.Time  `json:"started_at"`
+	CommittedAt  *time.Time `json:"committed_at,omitempty"`
+	CountedItems int        `json:"counted_items"` // Calculated, not stored directly
+}
+
+type StocktakeLine struct {
+	IngredientID    int      `json:"ingredient_id"`
+	Name            string   `json:"name"`
+	Unit            string   `json:"unit"`
+	SystemStock     float64  `json:"system_stock"`
+	CountedQuantity *float64 `json:"counted_quantity"` // Null until counted
+	Variance        float64  `json:"variance"`         // counted - system, 0 when not counted
+	VarianceValue   float64  `json:"variance_value"`   // variance * price
+}
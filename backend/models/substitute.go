@@ -0,0 +1,40 @@
+package models
+
+type IngredientSubstitute struct {
+	ID             int     `json:"id"`
+	IngredientID   int     `json:"ingredient_id"`
+	IngredientName string  `json:"ingredient_name,omitempty"` // For display
+	SubstituteID   int     `json:"substitute_id"`
+	SubstituteName string  `json:"substitute_name,omitempty"` // For display
+	Ratio          float64 `json:"ratio"`                     // Substitute quantity per unit of the ingredient
+	RecipeID       *int    `json:"recipe_id"`                 // Null applies to every recipe
+	RecipeName     string  `json:"recipe_name,omitempty"`     // For display
+	Note           string  `json:"note"`
+}
+
+// Substitution is a substitute used in place of part of an ingredient.
+type Substitution struct {
+	IngredientID   int     `json:"ingredient_id"`
+	IngredientName string  `json:"ingredient_name"`
+	SubstituteID   int     `json:"substitute_id"`
+	SubstituteName string  `json:"substitute_name"`
+	Replaced       float64 `json:"replaced"` // Quantity of the ingredient replaced
+	Quantity       float64 `json:"quantity"` // Quantity of the substitute used
+}
+
+type MissingIngredient struct {
+	IngredientID int     `json:"ingredient_id"`
+	Name         string  `json:"name"`
+	Quantity     float64 `json:"quantity"`
+	Unit         string  `json:"unit"`
+}
+
+// RecipeAvailability tells whether stock covers a recipe, with substitutes.
+type RecipeAvailability struct {
+	RecipeID      int                 `json:"recipe_id"`
+	Name          string              `json:"name"`
+	Batches       float64             `json:"batches"`
+	CanCook       bool                `json:"can_cook"`
+	Missing       []MissingIngredient `json:"missing"`
+	Substitutions []Substitution      `json:"substitutions"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+type WasteEntry struct {
+	ID           int       `json:"id"`
+	IngredientID *int      `json:"ingredient_id,omitempty"`
+	RecipeID     *int      `json:"recipe_id,omitempty"` // Set for discarded prepared food
+	Name         string    `json:"name"`
+	Quantity     float64   `json:"quantity"`
+	Unit         string    `json:"unit"`
+	Value        float64   `json:"value"`  // Quantity times price at the time of discarding
+	Reason       string    `json:"reason"` // expired, spoiled, over-cooked, other
+	Note         string    `json:"note"`
+	DiscardedAt  time.Time `json:"discarded_at"`
+}
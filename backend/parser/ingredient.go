@@ -0,0 +1,320 @@
+// Package parser turns free-text recipe lines into structured data.
+package parser
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type Ingredient struct {
+	Quantity    float64 `json:"quantity"`
+	QuantityMax float64 `json:"quantity_max,omitempty"` // Upper bound of a range such as "2-3"
+	Unit        string  `json:"unit,omitempty"`
+	Name        string  `json:"name"`
+	Note        string  `json:"note,omitempty"` // "sifted", "room temperature", ...
+}
+
+// Amount is the quantity to plan with: the upper bound of a range so planned
+// consumption never undercounts.
+func (i Ingredient) Amount() float64 {
+	if i.QuantityMax > i.Quantity {
+		return i.QuantityMax
+	}
+	return i.Quantity
+}
+
+// unitAliases maps the spellings found in recipes to one canonical unit.
+var unitAliases = map[string]string{
+	"cup": "cup", "cups": "cup", "c": "cup",
+	"tablespoon": "tbsp", "tablespoons": "tbsp", "tbsp": "tbsp", "tbs": "tbsp", "tbl": "tbsp",
+	"teaspoon": "tsp", "teaspoons": "tsp", "tsp": "tsp",
+	"g": "g", "gram": "g", "grams": "g", "gr": "g",
+	"kg": "kg", "kilogram": "kg", "kilograms": "kg",
+	"mg": "mg",
+	"ml": "ml", "milliliter": "ml", "milliliters": "ml", "millilitre": "ml", "millilitres": "ml", "cc": "ml",
+	"l": "l", "liter": "l", "liters": "l", "litre": "l", "litres": "l",
+	"oz": "oz", "ounce": "oz", "ounces": "oz",
+	"lb": "lb", "lbs": "lb", "pound": "lb", "pounds": "lb",
+	"pinch": "pinch", "pinches": "pinch",
+	"clove": "clove", "cloves": "clove",
+	"can": "can", "cans": "can",
+	"slice": "slice", "slices": "slice",
+	"piece": "pcs", "pieces": "pcs", "pc": "pcs", "pcs": "pcs",
+	"bunch": "bunch", "bunches": "bunch",
+}
+
+// cjkUnits are Japanese and Chinese units. They are often written without
+// spaces ("醤油大さじ1", "雞蛋2顆"), so they are also matched as word suffixes.
+var cjkUnits = map[string]string{
+	"大さじ": "tbsp", "大匙": "tbsp", "湯匙": "tbsp", "汤匙": "tbsp",
+	"小さじ": "tsp", "小匙": "tsp", "茶匙": "tsp",
+	"カップ": "cup", "杯": "cup",
+	"グラム": "g", "克": "g", "公克": "g",
+	"キロ": "kg", "公斤": "kg", "千克": "kg",
+	"ミリリットル": "ml", "毫升": "ml",
+	"リットル": "l", "公升": "l", "升": "l",
+	"片": "slice", "枚": "slice",
+	"瓣": "clove", "かけ": "clove",
+	"個": "pcs", "个": "pcs", "顆": "pcs", "颗": "pcs", "粒": "pcs", "本": "pcs", "根": "pcs", "條": "pcs", "条": "pcs", "塊": "pcs", "块": "pcs",
+	"罐": "can", "缶": "can",
+	"把": "bunch", "束": "bunch",
+	"斤": "斤",
+}
+
+// cjkUnitsByLength lists cjkUnits longest first so "公克" wins over "克".
+var cjkUnitsByLength = func() []string {
+	var units []string
+	for u := range cjkUnits {
+		units = append(units, u)
+	}
+	sort.Slice(units, func(i, j int) bool {
+		if len([]rune(units[i])) != len([]rune(units[j])) {
+			return len([]rune(units[i])) > len([]rune(units[j]))
+		}
+		return units[i] < units[j]
+	})
+	return units
+}()
+
+// vulgarFractions are the single-rune fractions recipe sites like to use.
+var vulgarFractions = map[rune]float64{
+	'½': 0.5, '⅓': 1.0 / 3, '⅔': 2.0 / 3, '¼': 0.25, '¾': 0.75,
+	'⅕': 0.2, '⅖': 0.4, '⅗': 0.6, '⅘': 0.8, '⅙': 1.0 / 6, '⅚': 5.0 / 6,
+	'⅛': 0.125, '⅜': 0.375, '⅝': 0.625, '⅞': 0.875,
+}
+
+var (
+	thousandsSeparator = regexp.MustCompile(`(\d),(\d{3})(\D|$)`)
+	numberRange        = regexp.MustCompile(`(\d)\s*[-–~]\s*(\d)`)
+	numberRun          = regexp.MustCompile(`\d+(?:\.\d+)?(?:/\d+)?`)
+	parenthesized      = regexp.MustCompile(`\(([^)]*)\)`)
+)
+
+// ParseIngredientLine splits a free-text ingredient line into quantity, unit,
+// name and note. It understands
+//
+//	"2 1/2 cups flour, sifted"  quantity first, with fractions and a note
+//	"3-4 cloves garlic"         ranges (Quantity 3, QuantityMax 4)
+//	"大さじ1 醤油"               unit before the quantity
+//	"鹽 1 茶匙", "雞蛋2顆"        name first, with or without spaces
+//
+// ok is false when the line has no quantity or no name, e.g. "salt to taste".
+func ParseIngredientLine(line string) (ing Ingredient, ok bool) {
+	text, notes := extractNotes(normalize(line))
+	tokens := strings.Fields(text)
+
+	// "a pinch of salt"
+	if len(tokens) > 1 && (strings.EqualFold(tokens[0], "a") || strings.EqualFold(tokens[0], "an")) {
+		if _, isUnit := lookupUnit(tokens[1]); isUnit {
+			tokens[0] = "1"
+		}
+	}
+
+	start := -1
+	for i, tok := range tokens {
+		if _, isNumber := parseNumber(tok); isNumber {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return Ingredient{}, false
+	}
+
+	// Quantity: a whole number optionally followed by a fraction ("1 1/2")
+	end := start
+	for end < len(tokens) {
+		v, isNumber := parseNumber(tokens[end])
+		if !isNumber || (end > start && v >= 1) {
+			break
+		}
+		ing.Quantity += v
+		end++
+	}
+	if ing.Quantity <= 0 {
+		return Ingredient{}, false
+	}
+
+	// Range: "2 - 3", "2 to 3"
+	if end+1 < len(tokens) && (tokens[end] == "-" || strings.EqualFold(tokens[end], "to")) {
+		if v, isNumber := parseNumber(tokens[end+1]); isNumber && v > ing.Quantity {
+			ing.QuantityMax = v
+			end += 2
+		}
+	}
+
+	before := append([]string(nil), tokens[:start]...)
+	after := tokens[end:]
+
+	// Unit right after the quantity, or right before it ("大さじ1", "醤油大さじ1")
+	if len(after) > 0 {
+		if unit, isUnit := lookupUnit(strings.TrimSuffix(after[0], ".")); isUnit {
+			ing.Unit = unit
+			after = after[1:]
+		}
+	}
+	if ing.Unit == "" && len(before) > 0 {
+		last := before[len(before)-1]
+		if unit, isUnit := lookupUnit(last); isUnit {
+			ing.Unit = unit
+			before = before[:len(before)-1]
+		} else if unit, rest := cutCJKUnitSuffix(last); unit != "" {
+			ing.Unit = unit
+			before[len(before)-1] = rest
+		}
+	}
+	if len(after) > 0 && strings.EqualFold(after[0], "of") {
+		after = after[1:]
+	}
+
+	// The name is whatever follows the quantity; when nothing does, it came first ("鹽 1 茶匙")
+	if len(after) > 0 {
+		ing.Name = strings.Join(after, " ")
+		if len(before) > 0 {
+			notes = append([]string{strings.Join(before, " ")}, notes...)
+		}
+	} else {
+		ing.Name = strings.Join(before, " ")
+	}
+	ing.Name = strings.TrimSpace(ing.Name)
+	if ing.Name == "" {
+		return Ingredient{}, false
+	}
+	ing.Note = strings.Join(notes, "; ")
+	return ing, true
+}
+
+// Line is one line of a pasted block together with its parse result.
+type Line struct {
+	Text string `json:"line"`
+	Ingredient
+	OK bool `json:"ok"`
+}
+
+// ParseIngredientLines parses a pasted block, one ingredient per line.
+// Blank lines are skipped.
+func ParseIngredientLines(text string) []Line {
+	var lines []Line
+	for _, text := range strings.Split(text, "\n") {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		ing, ok := ParseIngredientLine(text)
+		lines = append(lines, Line{Text: text, Ingredient: ing, OK: ok})
+	}
+	return lines
+}
+
+// normalize folds full-width characters, strips list bullets and puts spaces
+// around numbers so "1½cups", "鹽1茶匙" and "2～3個" all tokenize cleanly.
+func normalize(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= '０' && r <= '９':
+			r = '0' + (r - '０')
+		case r >= 'Ａ' && r <= 'Ｚ', r >= 'ａ' && r <= 'ｚ':
+			r = r - 'Ａ' + 'A'
+		case r == '／':
+			r = '/'
+		case r == '．':
+			r = '.'
+		case r == '～', r == '〜':
+			r = '~'
+		case r == '（':
+			r = '('
+		case r == '）':
+			r = ')'
+		case r == '，', r == '、':
+			r = ','
+		case r == '　':
+			r = ' '
+		}
+		if _, found := vulgarFractions[r]; found {
+			sb.WriteRune(' ')
+			sb.WriteRune(r)
+			sb.WriteRune(' ')
+			continue
+		}
+		sb.WriteRune(r)
+	}
+
+	s = strings.TrimSpace(sb.String())
+	s = strings.TrimLeft(s, "-*•・ ")
+	s = thousandsSeparator.ReplaceAllString(s, "$1$2$3")
+	s = numberRange.ReplaceAllString(s, "$1 - $2")
+	s = numberRun.ReplaceAllString(s, " $0 ")
+	return s
+}
+
+// extractNotes removes parenthesized text and everything after the first comma.
+func extractNotes(s string) (string, []string) {
+	var notes []string
+	for _, m := range parenthesized.FindAllStringSubmatch(s, -1) {
+		if note := strings.Join(strings.Fields(m[1]), " "); note != "" {
+			notes = append(notes, note)
+		}
+	}
+	s = parenthesized.ReplaceAllString(s, " ")
+
+	if text, note, found := strings.Cut(s, ","); found {
+		s = text
+		if note = strings.Join(strings.Fields(note), " "); note != "" {
+			notes = append(notes, note)
+		}
+	}
+	return s, notes
+}
+
+func lookupUnit(tok string) (string, bool) {
+	if unit, found := unitAliases[strings.ToLower(tok)]; found {
+		return unit, true
+	}
+	if unit, found := cjkUnits[tok]; found {
+		return unit, true
+	}
+	return "", false
+}
+
+// cutCJKUnitSuffix splits "醤油大さじ" into the unit and "醤油". Single-character
+// units are skipped, they end too many names ("牛肉片", "蒜瓣").
+func cutCJKUnitSuffix(tok string) (unit, rest string) {
+	for _, u := range cjkUnitsByLength {
+		if len([]rune(u)) < 2 {
+			break
+		}
+		if strings.HasSuffix(tok, u) && len(tok) > len(u) {
+			return cjkUnits[u], strings.TrimSuffix(tok, u)
+		}
+	}
+	return "", tok
+}
+
+// parseNumber reads "2", "1.5", "1/2" or "½".
+func parseNumber(s string) (float64, bool) {
+	if r := []rune(s); len(r) == 1 {
+		if v, found := vulgarFractions[r[0]]; found {
+			return v, true
+		}
+	}
+	if num, den, found := strings.Cut(s, "/"); found {
+		n, err1 := strconv.ParseFloat(num, 64)
+		d, err2 := strconv.ParseFloat(den, 64)
+		if err1 != nil || err2 != nil || d == 0 {
+			return 0, false
+		}
+		return n / d, true
+	}
+	if s == "" || !unicode.IsDigit(rune(s[0])) {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
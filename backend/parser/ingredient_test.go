@@ -0,0 +1,59 @@
+package parser
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseIngredientLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want Ingredient
+	}{
+		{"2 1/2 cups flour, sifted", Ingredient{Quantity: 2.5, Unit: "cup", Name: "flour", Note: "sifted"}},
+		{"大さじ1 醤油", Ingredient{Quantity: 1, Unit: "tbsp", Name: "醤油"}},
+		{"鹽 1 茶匙", Ingredient{Quantity: 1, Unit: "tsp", Name: "鹽"}},
+		{"醤油大さじ1", Ingredient{Quantity: 1, Unit: "tbsp", Name: "醤油"}},
+		{"雞蛋2顆", Ingredient{Quantity: 2, Unit: "pcs", Name: "雞蛋"}},
+		{"２個 卵", Ingredient{Quantity: 2, Unit: "pcs", Name: "卵"}},
+
+		// Ranges
+		{"3-4 cloves garlic", Ingredient{Quantity: 3, QuantityMax: 4, Unit: "clove", Name: "garlic"}},
+		{"2 to 3 eggs", Ingredient{Quantity: 2, QuantityMax: 3, Name: "eggs"}},
+		{"2～3個 じゃがいも", Ingredient{Quantity: 2, QuantityMax: 3, Unit: "pcs", Name: "じゃがいも"}},
+
+		// Unicode fractions, alone and after a whole number
+		{"¾ tsp salt", Ingredient{Quantity: 0.75, Unit: "tsp", Name: "salt"}},
+		{"1½cups milk", Ingredient{Quantity: 1.5, Unit: "cup", Name: "milk"}},
+
+		{"a pinch of salt", Ingredient{Quantity: 1, Unit: "pinch", Name: "salt"}},
+		{"1,000 g rice", Ingredient{Quantity: 1000, Unit: "g", Name: "rice"}},
+		{"200g butter (room temperature)", Ingredient{Quantity: 200, Unit: "g", Name: "butter", Note: "room temperature"}},
+		{"- 2 tbsp. olive oil", Ingredient{Quantity: 2, Unit: "tbsp", Name: "olive oil"}},
+		{"3 eggs", Ingredient{Quantity: 3, Name: "eggs"}},
+	}
+	for _, tt := range tests {
+		got, ok := ParseIngredientLine(tt.line)
+		if !ok || got.Unit != tt.want.Unit || got.Name != tt.want.Name || got.Note != tt.want.Note ||
+			math.Abs(got.Quantity-tt.want.Quantity) > 1e-9 || got.QuantityMax != tt.want.QuantityMax {
+			t.Errorf("ParseIngredientLine(%q) = %+v, %v; want %+v", tt.line, got, ok, tt.want)
+		}
+	}
+
+	// No quantity, or no name
+	for _, line := range []string{"salt to taste", "", "fresh parsley", "2 cups", "0 eggs"} {
+		if got, ok := ParseIngredientLine(line); ok {
+			t.Errorf("ParseIngredientLine(%q) = %+v, want not ok", line, got)
+		}
+	}
+}
+
+func TestParseIngredientLines(t *testing.T) {
+	lines := ParseIngredientLines("2 eggs\n\n  salt to taste\n100 ml milk\n")
+	if len(lines) != 3 || !lines[0].OK || lines[1].OK || lines[1].Text != "salt to taste" || lines[2].Unit != "ml" {
+		t.Errorf("lines = %+v", lines)
+	}
+	if got := (Ingredient{Quantity: 2, QuantityMax: 3}).Amount(); got != 3 {
+		t.Errorf("Amount of 2-3 = %v, want the upper bound", got)
+	}
+}
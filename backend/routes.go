@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/Kano-Chien/house_management/backend/config"
+	"github.com/Kano-Chien/house_management/backend/handlers"
+	"github.com/Kano-Chien/house_management/frontend"
+)
+
+// newRouter registers the resource routes, the older action routes
+// (/api/inventory/delete, /api/recipes/edit, ...) that the frontend still uses
+// and the embedded frontend itself.
+// Ingredients, recipes, the meal plan and the shopping list go through store;
+// the other handlers query db directly. Zero settings in cfg leave the handlers'
+// defaults; the admin routes are only there with a cfg.AdminToken.
+func newRouter(db *sql.DB, store handlers.Store, cfg config.Config) *http.ServeMux {
+	inventoryHandler := &handlers.InventoryHandler{DB: db, Ingredients: store, ExpiringDays: cfg.ExpiringDays}
+	recipeHandler := &handlers.RecipeHandler{DB: db, Recipes: store, Ingredients: store}
+	mealPlanHandler := &handlers.MealPlanHandler{DB: db, MealPlans: store, LeftoverDays: cfg.LeftoverDays}
+	shoppingListHandler := &handlers.ShoppingListHandler{Shopping: store, LowStock: cfg.LowStockThreshold}
+	lineNotifyHandler := &handlers.LineNotifyHandler{DB: db, ChannelSecret: cfg.LineChannelSecret, AccessToken: cfg.LineAccessToken}
+	locationHandler := &handlers.LocationHandler{DB: db}
+	stocktakeHandler := &handlers.StocktakeHandler{DB: db}
+	tagHandler := &handlers.TagHandler{DB: db}
+	householdHandler := &handlers.HouseholdHandler{DB: db}
+	backupHandler := &handlers.BackupHandler{DB: db}
+
+	// Method+path patterns; the mux answers 405 for a known path with another method
+	mux := http.NewServeMux()
+
+	// Ingredients
+	mux.HandleFunc("GET /api/ingredients", inventoryHandler.GetInventory)
+	mux.HandleFunc("POST /api/ingredients", inventoryHandler.AddIngredient)
+	mux.HandleFunc("GET /api/ingredients/expiring", inventoryHandler.GetExpiring)
+	mux.HandleFunc("GET /api/ingredients/suggest", inventoryHandler.SuggestIngredients)
+	mux.HandleFunc("GET /api/ingredients/history", inventoryHandler.GetHistory)
+	mux.HandleFunc("GET /api/ingredients/nutrition", inventoryHandler.GetNutrition)
+	mux.HandleFunc("POST /api/ingredients/nutrition/import", inventoryHandler.ImportNutrition)
+	mux.HandleFunc("GET /api/ingredients/export", inventoryHandler.ExportIngredients)
+	mux.HandleFunc("POST /api/ingredients/import", inventoryHandler.ImportIngredients)
+	mux.HandleFunc("GET /api/ingredients/{id}", byID(inventoryHandler.GetIngredient))
+	mux.HandleFunc("PATCH /api/ingredients/{id}", byID(inventoryHandler.EditIngredient))
+	mux.HandleFunc("DELETE /api/ingredients/{id}", byID(inventoryHandler.DeleteIngredient))
+	mux.HandleFunc("PUT /api/ingredients/{id}/stock", byID(inventoryHandler.UpdateStock))
+	mux.HandleFunc("POST /api/ingredients/{id}/move", byID(inventoryHandler.MoveIngredient))
+	mux.HandleFunc("POST /api/ingredients/{id}/discard", withPathIDs(inventoryHandler.Discard, "ingredient_id", "id"))
+	mux.HandleFunc("POST /api/ingredients/{id}/merge", withPathIDs(inventoryHandler.MergeIngredients, "canonical_id", "id"))
+	mux.HandleFunc("GET /api/ingredients/{id}/history", withPathIDs(inventoryHandler.GetHistory, "ingredient_id", "id"))
+	mux.HandleFunc("GET /api/ingredients/{id}/nutrition", withPathIDs(inventoryHandler.GetNutrition, "ingredient_id", "id"))
+	mux.HandleFunc("PUT /api/ingredients/{id}/nutrition", withPathIDs(inventoryHandler.SetNutrition, "ingredient_id", "id"))
+	mux.HandleFunc("DELETE /api/ingredients/{id}/nutrition", withPathIDs(inventoryHandler.DeleteNutrition, "ingredient_id", "id"))
+	mux.HandleFunc("PUT /api/ingredients/{id}/flags", withPathIDs(inventoryHandler.SetIngredientFlags, "ingredient_id", "id"))
+	mux.HandleFunc("PUT /api/ingredients/{id}/aliases", withPathIDs(inventoryHandler.SetAliases, "ingredient_id", "id"))
+	mux.HandleFunc("GET /api/ingredients/{id}/substitutes", withPathIDs(inventoryHandler.GetSubstitutes, "ingredient_id", "id"))
+
+	mux.HandleFunc("GET /api/substitutes", inventoryHandler.GetSubstitutes)
+	mux.HandleFunc("POST /api/substitutes", inventoryHandler.AddSubstitute)
+	mux.HandleFunc("DELETE /api/substitutes/{id}", byID(inventoryHandler.DeleteSubstitute))
+
+	mux.HandleFunc("GET /api/prepared-foods", recipeHandler.GetPreparedFoods)
+	mux.HandleFunc("POST /api/prepared-foods/{id}/discard", withPathIDs(inventoryHandler.Discard, "prepared_food_id", "id"))
+
+	mux.HandleFunc("GET /api/waste", inventoryHandler.GetWaste)
+	mux.HandleFunc("GET /api/waste/report", inventoryHandler.GetWasteReport)
+
+	// Locations and stocktakes
+	mux.HandleFunc("GET /api/locations", locationHandler.GetLocations)
+	mux.HandleFunc("POST /api/locations", locationHandler.CreateLocation)
+	mux.HandleFunc("PUT /api/locations/{id}", byID(locationHandler.UpdateLocation))
+	mux.HandleFunc("DELETE /api/locations/{id}", byID(locationHandler.DeleteLocation))
+	mux.HandleFunc("GET /api/locations/{id}/stock", withPathIDs(locationHandler.GetLocationStock, "location_id", "id"))
+
+	mux.HandleFunc("GET /api/stocktakes", stocktakeHandler.GetStocktakes)
+	mux.HandleFunc("POST /api/stocktakes", stocktakeHandler.StartStocktake)
+	mux.HandleFunc("PUT /api/stocktakes/{id}/counts", withPathIDs(stocktakeHandler.SubmitCounts, "stocktake_id", "id"))
+	mux.HandleFunc("GET /api/stocktakes/{id}/variance", byID(stocktakeHandler.GetVariance))
+	mux.HandleFunc("POST /api/stocktakes/{id}/commit", byID(stocktakeHandler.CommitStocktake))
+	mux.HandleFunc("POST /api/stocktakes/{id}/cancel", byID(stocktakeHandler.CancelStocktake))
+
+	// Recipes
+	mux.HandleFunc("GET /api/recipes", recipeHandler.GetRecipes)
+	mux.HandleFunc("POST /api/recipes", recipeHandler.CreateRecipe)
+	mux.HandleFunc("GET /api/recipes/search", recipeHandler.SearchRecipes)
+	mux.HandleFunc("GET /api/recipes/availability", recipeHandler.GetRecipeAvailability)
+	mux.HandleFunc("GET /api/recipes/export", recipeHandler.ExportRecipes)
+	mux.HandleFunc("POST /api/recipes/import", recipeHandler.ImportRecipes)
+	mux.HandleFunc("POST /api/recipes/import/jsonld", recipeHandler.ImportSchemaRecipe)
+	mux.HandleFunc("GET /api/recipes/{id}", byID(recipeHandler.GetRecipeDetail))
+	mux.HandleFunc("PATCH /api/recipes/{id}", byID(recipeHandler.UpdateRecipeName))
+	mux.HandleFunc("DELETE /api/recipes/{id}", byID(recipeHandler.DeleteRecipe))
+	mux.HandleFunc("GET /api/recipes/{id}/cost", byID(recipeHandler.GetRecipeCost))
+	mux.HandleFunc("GET /api/recipes/{id}/availability", byID(recipeHandler.GetRecipeAvailability))
+	mux.HandleFunc("POST /api/recipes/{id}/prepare", withPathIDs(recipeHandler.PrepareRecipe, "recipe_id", "id"))
+	mux.HandleFunc("GET /api/recipes/{id}/ingredients", withPathIDs(recipeHandler.GetRecipeIngredients, "recipe_id", "id"))
+	mux.HandleFunc("POST /api/recipes/{id}/ingredients", withPathIDs(recipeHandler.AddRecipeIngredient, "recipe_id", "id"))
+	mux.HandleFunc("POST /api/recipes/{id}/ingredients/paste", withPathIDs(recipeHandler.PasteIngredients, "recipe_id", "id"))
+	mux.HandleFunc("PATCH /api/recipes/{id}/ingredients/{ingredientId}",
+		withPathIDs(recipeHandler.UpdateIngredientQuantity, "recipe_id", "id", "ingredient_id", "ingredientId"))
+	mux.HandleFunc("DELETE /api/recipes/{id}/ingredients/{ingredientId}",
+		withPathIDs(recipeHandler.RemoveRecipeIngredient, "recipe_id", "id", "ingredient_id", "ingredientId"))
+	mux.HandleFunc("GET /api/recipes/{id}/steps", withPathIDs(recipeHandler.GetRecipeSteps, "recipe_id", "id"))
+	mux.HandleFunc("POST /api/recipes/{id}/steps", withPathIDs(recipeHandler.AddRecipeStep, "recipe_id", "id"))
+	mux.HandleFunc("PUT /api/recipes/{id}/steps/order", withPathIDs(recipeHandler.ReorderRecipeSteps, "recipe_id", "id"))
+	mux.HandleFunc("PUT /api/recipes/{id}/steps/{stepId}",
+		withPathIDs(recipeHandler.UpdateRecipeStep, "recipe_id", "id", "id", "stepId"))
+	mux.HandleFunc("DELETE /api/recipes/{id}/steps/{stepId}",
+		withPathIDs(recipeHandler.DeleteRecipeStep, "recipe_id", "id", "id", "stepId"))
+	mux.HandleFunc("POST /api/recipes/{id}/tags", withPathIDs(recipeHandler.AddRecipeTag, "recipe_id", "id"))
+	mux.HandleFunc("DELETE /api/recipes/{id}/tags/{tagId}",
+		withPathIDs(recipeHandler.RemoveRecipeTag, "recipe_id", "id", "tag_id", "tagId"))
+	mux.HandleFunc("GET /api/recipes/{id}/components", withPathIDs(recipeHandler.GetRecipeComponents, "recipe_id", "id"))
+	mux.HandleFunc("POST /api/recipes/{id}/components", withPathIDs(recipeHandler.AddRecipeComponent, "recipe_id", "id"))
+	mux.HandleFunc("DELETE /api/recipes/{id}/components/{componentId}",
+		withPathIDs(recipeHandler.RemoveRecipeComponent, "recipe_id", "id", "component_recipe_id", "componentId"))
+
+	mux.HandleFunc("GET /api/tags", tagHandler.GetTags)
+	mux.HandleFunc("POST /api/tags", tagHandler.CreateTag)
+	mux.HandleFunc("DELETE /api/tags/{id}", byID(tagHandler.DeleteTag))
+
+	// Household
+	mux.HandleFunc("GET /api/members", householdHandler.GetMembers)
+	mux.HandleFunc("POST /api/members", householdHandler.CreateMember)
+	mux.HandleFunc("PUT /api/members/{id}", byID(householdHandler.UpdateMember))
+	mux.HandleFunc("DELETE /api/members/{id}", byID(householdHandler.DeleteMember))
+	mux.HandleFunc("POST /api/members/{id}/attendance", withPathIDs(mealPlanHandler.MarkAttendance, "member_id", "id"))
+	mux.HandleFunc("POST /api/members/{id}/line-link", byID(householdHandler.LinkLine))
+	mux.HandleFunc("DELETE /api/members/{id}/line-link", byID(householdHandler.UnlinkLine))
+	mux.HandleFunc("GET /api/dietary", householdHandler.GetDietaryOptions)
+
+	// Meal plan
+	mux.HandleFunc("GET /api/meal-plans", mealPlanHandler.GetMealPlan)
+	mux.HandleFunc("POST /api/meal-plans", mealPlanHandler.ScheduleMeal)
+	mux.HandleFunc("GET /api/meal-plans/nutrition", mealPlanHandler.GetNutritionSummary)
+	mux.HandleFunc("GET /api/meal-plans/export", mealPlanHandler.ExportMealPlan)
+	mux.HandleFunc("POST /api/meal-plans/import", mealPlanHandler.ImportMealPlan)
+	mux.HandleFunc("DELETE /api/meal-plans/{id}", byID(mealPlanHandler.DeleteMealPlan))
+	mux.HandleFunc("POST /api/meal-plans/{id}/cook", byID(mealPlanHandler.CookMeal))
+	mux.HandleFunc("GET /api/meal-plans/{id}/attendance", withPathIDs(mealPlanHandler.GetAttendance, "meal_plan_id", "id"))
+	mux.HandleFunc("PUT /api/meal-plans/{id}/attendance", withPathIDs(mealPlanHandler.SetAttendance, "meal_plan_id", "id"))
+
+	mux.HandleFunc("GET /api/shopping-list", shoppingListHandler.GetShoppingList)
+	mux.HandleFunc("POST /api/line/send-shopping-list", lineNotifyHandler.SendShoppingList)
+	mux.HandleFunc("POST /api/line/webhook", lineNotifyHandler.Webhook)
+
+	// Admin; a restore can replace all data, so only with a token
+	if cfg.AdminToken != "" {
+		mux.HandleFunc("GET /api/admin/backup", handlers.AdminOnly(cfg.AdminToken, backupHandler.Download))
+		mux.HandleFunc("POST /api/admin/restore", handlers.AdminOnly(cfg.AdminToken, backupHandler.Restore))
+	}
+
+	// Deprecated action routes, kept until the frontend moves to the routes above
+	legacy := []struct {
+		pattern, successor string
+		handler            http.HandlerFunc
+	}{
+		{"GET /api/inventory", "/api/ingredients", inventoryHandler.GetInventory},
+		{"POST /api/inventory", "/api/ingredients", inventoryHandler.AddIngredient},
+		{"PUT /api/inventory/stock", "/api/ingredients/{id}/stock", inventoryHandler.UpdateStock},
+		{"PUT /api/inventory/edit", "/api/ingredients/{id}", inventoryHandler.EditIngredient},
+		{"POST /api/inventory/delete", "/api/ingredients/{id}", inventoryHandler.DeleteIngredient},
+		{"POST /api/inventory/move", "/api/ingredients/{id}/move", inventoryHandler.MoveIngredient},
+		{"GET /api/inventory/history", "/api/ingredients/history", inventoryHandler.GetHistory},
+		{"GET /api/inventory/expiring", "/api/ingredients/expiring", inventoryHandler.GetExpiring},
+		{"POST /api/inventory/discard", "/api/ingredients/{id}/discard", inventoryHandler.Discard},
+		{"GET /api/inventory/nutrition", "/api/ingredients/nutrition", inventoryHandler.GetNutrition},
+		{"PUT /api/inventory/nutrition", "/api/ingredients/{id}/nutrition", inventoryHandler.SetNutrition},
+		{"POST /api/inventory/nutrition/delete", "/api/ingredients/{id}/nutrition", inventoryHandler.DeleteNutrition},
+		{"POST /api/inventory/nutrition/import", "/api/ingredients/nutrition/import", inventoryHandler.ImportNutrition},
+		{"PUT /api/inventory/flags", "/api/ingredients/{id}/flags", inventoryHandler.SetIngredientFlags},
+		{"PUT /api/inventory/aliases", "/api/ingredients/{id}/aliases", inventoryHandler.SetAliases},
+		{"GET /api/inventory/suggest", "/api/ingredients/suggest", inventoryHandler.SuggestIngredients},
+		{"POST /api/inventory/merge", "/api/ingredients/{id}/merge", inventoryHandler.MergeIngredients},
+		{"GET /api/inventory/export", "/api/ingredients/export", inventoryHandler.ExportIngredients},
+		{"POST /api/inventory/import", "/api/ingredients/import", inventoryHandler.ImportIngredients},
+		{"POST /api/substitutes/delete", "/api/substitutes/{id}", inventoryHandler.DeleteSubstitute},
+		{"PUT /api/locations/edit", "/api/locations/{id}", locationHandler.UpdateLocation},
+		{"POST /api/locations/delete", "/api/locations/{id}", locationHandler.DeleteLocation},
+		{"GET /api/locations/stock", "/api/locations/{id}/stock", locationHandler.GetLocationStock},
+		{"GET /api/stocktake", "/api/stocktakes", stocktakeHandler.GetStocktakes},
+		{"POST /api/stocktake", "/api/stocktakes", stocktakeHandler.StartStocktake},
+		{"PUT /api/stocktake/counts", "/api/stocktakes/{id}/counts", stocktakeHandler.SubmitCounts},
+		{"GET /api/stocktake/variance", "/api/stocktakes/{id}/variance", stocktakeHandler.GetVariance},
+		{"POST /api/stocktake/commit", "/api/stocktakes/{id}/commit", stocktakeHandler.CommitStocktake},
+		{"POST /api/stocktake/cancel", "/api/stocktakes/{id}/cancel", stocktakeHandler.CancelStocktake},
+		{"GET /api/recipes/ingredients", "/api/recipes/{id}/ingredients", recipeHandler.GetRecipeIngredients},
+		{"POST /api/recipes/ingredients", "/api/recipes/{id}/ingredients", recipeHandler.AddRecipeIngredient},
+		{"POST /api/recipes/ingredients/remove", "/api/recipes/{id}/ingredients/{ingredientId}", recipeHandler.RemoveRecipeIngredient},
+		{"POST /api/recipes/ingredients/paste", "/api/recipes/{id}/ingredients/paste", recipeHandler.PasteIngredients},
+		{"PUT /api/recipes/ingredients/edit", "/api/recipes/{id}/ingredients/{ingredientId}", recipeHandler.UpdateIngredientQuantity},
+		{"POST /api/recipes/delete", "/api/recipes/{id}", recipeHandler.DeleteRecipe},
+		{"PUT /api/recipes/edit", "/api/recipes/{id}", recipeHandler.UpdateRecipeName},
+		{"GET /api/recipes/detail", "/api/recipes/{id}", recipeHandler.GetRecipeDetail},
+		{"GET /api/recipes/steps", "/api/recipes/{id}/steps", recipeHandler.GetRecipeSteps},
+		{"POST /api/recipes/steps", "/api/recipes/{id}/steps", recipeHandler.AddRecipeStep},
+		{"PUT /api/recipes/steps/edit", "/api/recipes/{id}/steps/{stepId}", recipeHandler.UpdateRecipeStep},
+		{"POST /api/recipes/steps/delete", "/api/recipes/{id}/steps/{stepId}", recipeHandler.DeleteRecipeStep},
+		{"PUT /api/recipes/steps/reorder", "/api/recipes/{id}/steps/order", recipeHandler.ReorderRecipeSteps},
+		{"POST /api/recipes/tags", "/api/recipes/{id}/tags", recipeHandler.AddRecipeTag},
+		{"POST /api/recipes/tags/remove", "/api/recipes/{id}/tags/{tagId}", recipeHandler.RemoveRecipeTag},
+		{"GET /api/recipes/components", "/api/recipes/{id}/components", recipeHandler.GetRecipeComponents},
+		{"POST /api/recipes/components", "/api/recipes/{id}/components", recipeHandler.AddRecipeComponent},
+		{"POST /api/recipes/components/remove", "/api/recipes/{id}/components/{componentId}", recipeHandler.RemoveRecipeComponent},
+		{"GET /api/recipes/cost", "/api/recipes/{id}/cost", recipeHandler.GetRecipeCost},
+		{"POST /api/recipes/prepare", "/api/recipes/{id}/prepare", recipeHandler.PrepareRecipe},
+		{"POST /api/tags/delete", "/api/tags/{id}", tagHandler.DeleteTag},
+		{"GET /api/household", "/api/members", householdHandler.GetMembers},
+		{"POST /api/household", "/api/members", householdHandler.CreateMember},
+		{"PUT /api/household/edit", "/api/members/{id}", householdHandler.UpdateMember},
+		{"POST /api/household/delete", "/api/members/{id}", householdHandler.DeleteMember},
+		{"GET /api/mealplan", "/api/meal-plans", mealPlanHandler.GetMealPlan},
+		{"POST /api/mealplan", "/api/meal-plans", mealPlanHandler.ScheduleMeal},
+		{"POST /api/mealplan/delete", "/api/meal-plans/{id}", mealPlanHandler.DeleteMealPlan},
+		{"POST /api/mealplan/cook", "/api/meal-plans/{id}/cook", mealPlanHandler.CookMeal},
+		{"GET /api/mealplan/attendance", "/api/meal-plans/{id}/attendance", mealPlanHandler.GetAttendance},
+		{"PUT /api/mealplan/attendance", "/api/meal-plans/{id}/attendance", mealPlanHandler.SetAttendance},
+		{"POST /api/mealplan/out", "/api/members/{id}/attendance", mealPlanHandler.MarkAttendance},
+		{"GET /api/mealplan/nutrition", "/api/meal-plans/nutrition", mealPlanHandler.GetNutritionSummary},
+		{"GET /api/mealplan/export", "/api/meal-plans/export", mealPlanHandler.ExportMealPlan},
+		{"POST /api/mealplan/import", "/api/meal-plans/import", mealPlanHandler.ImportMealPlan},
+	}
+	for _, route := range legacy {
+		mux.HandleFunc(route.pattern, deprecated(route.successor, route.handler))
+	}
+
+	// Everything else is the app; GET only, so other methods on API paths
+	// still get 405
+	mux.Handle("GET /", frontend.Handler())
+
+	return mux
+}
+
+// deprecated marks responses of an old route with the route replacing it.
+func deprecated(successor string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+successor+">; rel=\"successor-version\"")
+		next(w, r)
+	}
+}
+
+// byID passes the {id} path value to a handler that reads "id".
+func byID(next http.HandlerFunc) http.HandlerFunc {
+	return withPathIDs(next, "id", "id")
+}
+
+// withPathIDs hands path values to handlers written for the action routes,
+// which read IDs from the query string (GET) or the JSON body. pairs are
+// field, wildcard: withPathIDs(h, "recipe_id", "id") sets recipe_id to {id}.
+// A path value overrides the same field in the request.
+func withPathIDs(next http.HandlerFunc, pairs ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ids := map[string]int{}
+		for i := 0; i+1 < len(pairs); i += 2 {
+			id, err := strconv.Atoi(r.PathValue(pairs[i+1]))
+			if err != nil {
+				http.Error(w, "Invalid "+pairs[i+1], http.StatusBadRequest)
+				return
+			}
+			ids[pairs[i]] = id
+		}
+
+		if r.Method == "GET" || r.Method == "HEAD" {
+			q := r.URL.Query()
+			for field, id := range ids {
+				q.Set(field, strconv.Itoa(id))
+			}
+			r.URL.RawQuery = q.Encode()
+			next(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fields := map[string]json.RawMessage{}
+		if len(bytes.TrimSpace(body)) > 0 {
+			if err := json.Unmarshal(body, &fields); err != nil {
+				http.Error(w, "Request body must be a JSON object: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		for field, id := range ids {
+			fields[field] = json.RawMessage(strconv.Itoa(id))
+		}
+		body, _ = json.Marshal(fields)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+		next(w, r)
+	}
+}
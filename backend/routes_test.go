@@ -0,0 +1,528 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kano-Chien/house_management/backend/config"
+	"github.com/Kano-Chien/house_management/backend/database"
+	"github.com/Kano-Chien/house_management/backend/handlers"
+)
+
+var routePattern = regexp.MustCompile(`"((?:GET|POST|PUT|PATCH|DELETE) /api/[^"]*)"`)
+
+// Every pattern registered in routes.go must be reachable, not shadowed by another.
+func TestRoutesResolve(t *testing.T) {
+	src, err := os.ReadFile("routes.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	patterns := routePattern.FindAllStringSubmatch(string(src), -1)
+	if len(patterns) < 100 {
+		t.Fatalf("found only %d route patterns in routes.go", len(patterns))
+	}
+
+	mux := newRouter(nil, handlers.NewMemoryStore(), config.Config{AdminToken: testAdminToken})
+	wildcard := regexp.MustCompile(`\{[^}]+\}`)
+	for _, m := range patterns {
+		method, path, _ := strings.Cut(m[1], " ")
+		req := httptest.NewRequest(method, wildcard.ReplaceAllString(path, "1"), nil)
+		if _, got := mux.Handler(req); got != m[1] {
+			t.Errorf("%s resolves to %q", m[1], got)
+		}
+	}
+}
+
+// newTestClient sends requests to router and checks their status, returning
+// the decoded JSON body.
+func newTestClient(t *testing.T, router http.Handler) func(method, target, body string, status int) interface{} {
+	return func(method, target, body string, status int) interface{} {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		if w.Code != status {
+			t.Fatalf("%s %s: status %d, want %d: %s", method, target, w.Code, status, w.Body.String())
+		}
+		var v interface{}
+		json.Unmarshal(w.Body.Bytes(), &v)
+		return v
+	}
+}
+
+func TestStoreRoutes(t *testing.T) {
+	router := handlers.JSONErrors(newRouter(nil, handlers.NewMemoryStore(), config.Config{}))
+	client := newTestClient(t, router)
+	do := func(method, target, body string, status int) map[string]interface{} {
+		t.Helper()
+		v, _ := client(method, target, body, status).(map[string]interface{})
+		return v
+	}
+
+	do("POST", "/api/ingredients", `{"name": "Rice", "current_stock": 1000, "is_tracked": true}`, http.StatusCreated)
+	do("PATCH", "/api/ingredients/1", `{"price": 2}`, http.StatusOK)
+	do("PATCH", "/api/ingredients/x", `{"price": 2}`, http.StatusBadRequest)
+	do("POST", "/api/recipes", `{"name": "Rice bowl", "servings": 2}`, http.StatusCreated)
+	do("POST", "/api/recipes/2/ingredients", `{"ingredient_id": 1, "quantity": 100}`, http.StatusOK)
+	do("PATCH", "/api/recipes/2/ingredients/1", `{"quantity": 150}`, http.StatusOK)
+
+	today := time.Now().Format("2006-01-02")
+	meal := do("POST", "/api/meal-plans", `{"date": "`+today+`", "meal_type": "Dinner", "recipe_id": 2}`, http.StatusOK)
+	if meal["id"] != 3.0 {
+		t.Fatalf("meal = %v", meal)
+	}
+	do("POST", "/api/meal-plans/3/cook", `{}`, http.StatusOK)
+	do("POST", "/api/meal-plans/3/cook", `{}`, http.StatusConflict)
+
+	ingredient := do("GET", "/api/ingredients/1", "", http.StatusOK)
+	if ingredient["current_stock"] != 850.0 || ingredient["price"] != 2.0 {
+		t.Errorf("ingredient = %v", ingredient)
+	}
+
+	// Old action routes still work and point at their successor
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("PUT", "/api/inventory/stock", strings.NewReader(`{"id": 1, "new_stock": 5}`)))
+	if w.Code != http.StatusOK || w.Header().Get("Deprecation") != "true" ||
+		w.Header().Get("Link") != `</api/ingredients/{id}/stock>; rel="successor-version"` {
+		t.Errorf("legacy route: %d %v", w.Code, w.Header())
+	}
+
+	if e := do("GET", "/api/nothing", "", http.StatusNotFound); e["code"] != "not_found" {
+		t.Errorf("unknown route = %v", e)
+	}
+	if e := do("PUT", "/api/shopping-list", "", http.StatusMethodNotAllowed); e["code"] != "method_not_allowed" {
+		t.Errorf("wrong method = %v", e)
+	}
+}
+
+// newSQLiteDB opens a migrated SQLite database in a temporary file.
+func newSQLiteDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := database.Open("sqlite:" + filepath.Join(t.TempDir(), "house.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := database.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// The handlers' SQL is written for PostgreSQL; the routes that go beyond the
+// store all have to work on SQLite as well.
+func TestSQLiteRoutes(t *testing.T) {
+	db := newSQLiteDB(t)
+	// Applying the schema again keeps the data
+	if err := database.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	do := newTestClient(t, handlers.JSONErrors(newRouter(db, handlers.NewSQLStore(db), config.Config{})))
+
+	soon := time.Now().AddDate(0, 0, 2).Format("2006-01-02")
+	do("POST", "/api/locations", `{"name": "Fridge"}`, http.StatusCreated)
+	do("POST", "/api/ingredients", `{"name": "Rice", "current_stock": 1000, "unit": "g", "price": 0.01, "is_tracked": true}`, http.StatusCreated)
+	do("POST", "/api/ingredients", `{"name": "Eggs", "current_stock": 2, "unit": "pcs", "is_tracked": true, "expiry_date": "`+soon+`T00:00:00Z"}`, http.StatusCreated)
+	do("POST", "/api/ingredients", `{"name": "Egg", "current_stock": 3, "unit": "pcs", "is_tracked": true}`, http.StatusCreated)
+	do("POST", "/api/ingredients", `{"name": "Salt", "current_stock": 1, "location_id": 99}`, http.StatusUnprocessableEntity)
+	do("POST", "/api/ingredients/1/move", `{"location_id": 1}`, http.StatusOK)
+	do("PUT", "/api/ingredients/2/flags", `{"flags": ["egg"]}`, http.StatusOK)
+	do("POST", "/api/members", `{"name": "Ann", "restrictions": [{"name": "vegan"}]}`, http.StatusCreated)
+	do("POST", "/api/recipes", `{"name": "Fried rice", "servings": 2, "notes": "<p>Day-old rice</p>",
+		"ingredients": [{"ingredient_id": 1, "quantity": 200}, {"ingredient_id": 2, "quantity": 1}],
+		"steps": [{"text": "Fry", "ingredient_ids": [1, 2]}]}`, http.StatusCreated)
+	do("POST", "/api/recipes/1/tags", `{"tag_name": "Quick"}`, http.StatusOK)
+	do("POST", "/api/tags", `{"name": "quick"}`, http.StatusConflict)
+
+	results := do("GET", "/api/recipes/search?q=fried+rice&tag=quick", "", http.StatusOK).([]interface{})
+	if len(results) != 1 {
+		t.Errorf("search = %v", results)
+	}
+	// Ingredient filters match aliases too
+	do("PUT", "/api/ingredients/1/aliases", `{"aliases": ["gohan"]}`, http.StatusOK)
+	if results := do("GET", "/api/recipes/search?include=gohan", "", http.StatusOK).([]interface{}); len(results) != 1 {
+		t.Errorf("include by alias = %v", results)
+	}
+	if results := do("GET", "/api/recipes/search?exclude=Goha", "", http.StatusOK).([]interface{}); len(results) != 0 {
+		t.Errorf("exclude by alias = %v", results)
+	}
+	expiring := do("GET", "/api/ingredients/expiring?days=7", "", http.StatusOK).([]interface{})
+	if len(expiring) != 1 || expiring[0].(map[string]interface{})["days_left"] != 2.0 {
+		t.Errorf("expiring = %v", expiring)
+	}
+
+	meal := do("POST", "/api/meal-plans", `{"date": "`+soon+`", "meal_type": "Dinner", "recipe_id": 1, "member_ids": [1]}`, http.StatusOK)
+	if warnings := meal.(map[string]interface{})["warnings"].([]interface{}); len(warnings) != 1 {
+		t.Errorf("vegan warning missing: %v", meal)
+	}
+	list := do("GET", "/api/shopping-list", "", http.StatusOK).([]interface{})
+	if len(list) != 1 || list[0].(map[string]interface{})["name"] != "Eggs" {
+		t.Errorf("shopping list = %v", list)
+	}
+	do("GET", "/api/meal-plans/nutrition", "", http.StatusOK)
+	do("POST", "/api/meal-plans/1/cook", `{"leftover_days": 2}`, http.StatusOK)
+	if rice := do("GET", "/api/ingredients/1", "", http.StatusOK).(map[string]interface{}); rice["current_stock"] != 800.0 {
+		t.Errorf("rice after cooking = %v", rice)
+	}
+
+	do("POST", "/api/ingredients/3/merge", `{"duplicate_id": 2}`, http.StatusOK)
+	do("POST", "/api/ingredients/1/discard", `{"quantity": 100, "reason": "spoiled"}`, http.StatusCreated)
+	report := do("GET", "/api/waste/report", "", http.StatusOK).(map[string]interface{})
+	if report["total_value"] != 1.0 || len(report["months"].([]interface{})) != 1 {
+		t.Errorf("waste report = %v", report)
+	}
+
+	do("POST", "/api/stocktakes", `{"location_id": 1}`, http.StatusCreated)
+	do("PUT", "/api/stocktakes/1/counts", `{"counts": [{"ingredient_id": 1, "counted_quantity": 650}]}`, http.StatusOK)
+	do("POST", "/api/stocktakes/1/commit", `{"reason": "monthly"}`, http.StatusOK)
+	history := do("GET", "/api/ingredients/history?ingredient_id=1", "", http.StatusOK).([]interface{})
+	if len(history) != 3 || history[0].(map[string]interface{})["quantity_change"] != -50.0 {
+		t.Errorf("history = %v", history)
+	}
+
+	// A step is only reached through its own recipe
+	do("POST", "/api/recipes", `{"name": "Toast", "servings": 1}`, http.StatusCreated)
+	do("PUT", "/api/recipes/2/steps/1", `{"text": "Burn"}`, http.StatusNotFound)
+	do("DELETE", "/api/recipes/2/steps/1", "", http.StatusNotFound)
+	do("PUT", "/api/recipes/1/steps/1", `{"text": "Fry well"}`, http.StatusOK)
+	do("DELETE", "/api/recipes/1/steps/1", "", http.StatusOK)
+
+	// A location called "Unassigned" is not mixed up with the items that have none
+	do("POST", "/api/locations", `{"name": "Unassigned"}`, http.StatusCreated)
+	do("POST", "/api/ingredients", `{"name": "Flour", "current_stock": 1, "location_id": 2}`, http.StatusCreated)
+	groups := do("GET", "/api/locations/stock", "", http.StatusOK).([]interface{})
+	if len(groups) != 3 || groups[1].(map[string]interface{})["location_id"] != 2.0 ||
+		groups[2].(map[string]interface{})["location_id"] != nil {
+		t.Errorf("stock by location = %v", groups)
+	}
+}
+
+const testAdminToken = "0123456789abcdef"
+
+// asAdmin sends every request with the admin token.
+func asAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("Authorization", "Bearer "+testAdminToken)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func TestAdminToken(t *testing.T) {
+	db := newSQLiteDB(t)
+	do := newTestClient(t, handlers.JSONErrors(newRouter(db, handlers.NewSQLStore(db), config.Config{})))
+	do("GET", "/api/admin/backup", "", http.StatusNotFound)
+
+	router := handlers.JSONErrors(newRouter(db, handlers.NewSQLStore(db), config.Config{AdminToken: testAdminToken}))
+	for _, auth := range []string{"", "Bearer wrong", testAdminToken} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/api/admin/restore?mode=replace", nil)
+		req.Header.Set("Authorization", auth)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("Authorization %q: %d %v", auth, w.Code, w.Header())
+		}
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	cfg := config.Config{AdminToken: testAdminToken}
+	src := newSQLiteDB(t)
+	do := newTestClient(t, asAdmin(handlers.JSONErrors(newRouter(src, handlers.NewSQLStore(src), cfg))))
+	soon := time.Now().AddDate(0, 0, 2).Format("2006-01-02")
+	do("POST", "/api/locations", `{"name": "Fridge"}`, http.StatusCreated)
+	do("POST", "/api/ingredients", `{"name": "Rice", "current_stock": 1000, "unit": "g", "price": 0.01, "is_tracked": true}`, http.StatusCreated)
+	do("POST", "/api/ingredients", `{"name": "Eggs", "current_stock": 2, "is_tracked": true, "location_id": 1, "expiry_date": "`+soon+`T00:00:00Z"}`, http.StatusCreated)
+	do("POST", "/api/members", `{"name": "Ann"}`, http.StatusCreated)
+	do("POST", "/api/recipes", `{"name": "Fried rice", "servings": 2,
+		"ingredients": [{"ingredient_id": 1, "quantity": 200}, {"ingredient_id": 2, "quantity": 4}],
+		"steps": [{"text": "Fry", "ingredient_ids": [1, 2]}]}`, http.StatusCreated)
+	do("POST", "/api/recipes/1/tags", `{"tag_name": "Quick"}`, http.StatusOK)
+	do("POST", "/api/meal-plans", `{"date": "`+soon+`", "meal_type": "Dinner", "recipe_id": 1}`, http.StatusOK)
+
+	router := asAdmin(handlers.JSONErrors(newRouter(src, handlers.NewSQLStore(src), cfg)))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/admin/backup", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("backup: %d %v", w.Code, w.Header())
+	}
+	archive := w.Body.String()
+
+	dst := newSQLiteDB(t)
+	do = newTestClient(t, asAdmin(handlers.JSONErrors(newRouter(dst, handlers.NewSQLStore(dst), cfg))))
+	// IDs start elsewhere in the target, so references have to be rewritten
+	do("POST", "/api/recipes", `{"name": "Toast"}`, http.StatusCreated)
+	do("POST", "/api/members", `{"name": "Ann"}`, http.StatusCreated)
+	do("POST", "/api/ingredients", `{"name": "rice", "current_stock": 5000, "is_tracked": true}`, http.StatusCreated)
+
+	rep := do("POST", "/api/admin/restore", archive, http.StatusOK).(map[string]interface{})
+	restored, matched := rep["restored"].(map[string]interface{}), rep["matched"].(map[string]interface{})
+	if restored["recipes"] != 1.0 || restored["recipe_step_ingredients"] != 2.0 || matched["household_members"] != 1.0 ||
+		restored["ingredients"] != 1.0 || matched["ingredients"] != 1.0 {
+		t.Errorf("restore report = %v", rep)
+	}
+	// An ingredient that is there already keeps its stock
+	if rice := do("GET", "/api/ingredients/1", "", http.StatusOK).(map[string]interface{}); rice["current_stock"] != 5000.0 {
+		t.Errorf("matched ingredient = %v", rice)
+	}
+	recipe := do("GET", "/api/recipes/2", "", http.StatusOK).(map[string]interface{})
+	if recipe["name"] != "Fried rice" || len(recipe["ingredients"].([]interface{})) != 2 || len(recipe["steps"].([]interface{})) != 1 {
+		t.Errorf("restored recipe = %v", recipe)
+	}
+	list := do("GET", "/api/shopping-list", "", http.StatusOK).([]interface{})
+	if len(list) != 1 || list[0].(map[string]interface{})["name"] != "Eggs" {
+		t.Errorf("shopping list after restore = %v", list)
+	}
+	if eggs := do("GET", "/api/ingredients/2", "", http.StatusOK).(map[string]interface{}); eggs["location_id"] != 1.0 ||
+		!strings.HasPrefix(eggs["expiry_date"].(string), soon) {
+		t.Errorf("restored ingredient = %v", eggs)
+	}
+
+	// Merging the same archive again finds everything there already
+	rep = do("POST", "/api/admin/restore", archive, http.StatusOK).(map[string]interface{})
+	for table, n := range rep["restored"].(map[string]interface{}) {
+		if n != 0.0 {
+			t.Errorf("second merge restored %v %s", n, table)
+		}
+	}
+	if rep["matched"].(map[string]interface{})["meal_plan"] != 1.0 {
+		t.Errorf("second merge report = %v", rep)
+	}
+	recipes := do("GET", "/api/recipes", "", http.StatusOK).([]interface{})
+	ingredients := do("GET", "/api/ingredients", "", http.StatusOK).([]interface{})
+	plan := do("GET", "/api/meal-plans", "", http.StatusOK).([]interface{})
+	steps := do("GET", "/api/recipes/2/steps", "", http.StatusOK).([]interface{})
+	if len(recipes) != 2 || len(ingredients) != 2 || len(plan) != 1 || len(steps) != 1 {
+		t.Errorf("after merging twice: %d recipes, %d ingredients, %d meals, %d steps", len(recipes), len(ingredients), len(plan), len(steps))
+	}
+	if eggs := do("GET", "/api/ingredients/2", "", http.StatusOK).(map[string]interface{}); eggs["current_stock"] != 2.0 {
+		t.Errorf("stock after merging twice = %v", eggs)
+	}
+
+	do("POST", "/api/admin/restore?mode=replace", archive, http.StatusOK)
+	if recipes := do("GET", "/api/recipes", "", http.StatusOK).([]interface{}); len(recipes) != 1 {
+		t.Errorf("recipes after replace = %v", recipes)
+	}
+
+	do("POST", "/api/admin/restore?mode=append", archive, http.StatusBadRequest)
+	do("POST", "/api/admin/restore", "not a zip", http.StatusBadRequest)
+	var newer bytes.Buffer
+	z := zip.NewWriter(&newer)
+	f, _ := z.Create("manifest.json")
+	f.Write([]byte(`{"format": "house-backup", "version": 99}`))
+	z.Close()
+	if e := do("POST", "/api/admin/restore", newer.String(), http.StatusBadRequest).(map[string]interface{}); !strings.Contains(e["message"].(string), "newer") {
+		t.Errorf("newer archive = %v", e)
+	}
+}
+
+// Imports and exports on SQLite: a JSON-LD page, pasted ingredient lines, the
+// bundled nutrition dataset, and a round trip of every export into an empty database.
+func TestSQLiteImportRoutes(t *testing.T) {
+	src := newSQLiteDB(t)
+	router := handlers.JSONErrors(newRouter(src, handlers.NewSQLStore(src), config.Config{}))
+	do := newTestClient(t, router)
+
+	page, err := os.ReadFile("handlers/testdata/recipe.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported := do("POST", "/api/recipes/import/jsonld", string(page), http.StatusCreated).(map[string]interface{})
+	recipe := imported["recipe"].(map[string]interface{})
+	if recipe["servings"] != 4.0 || len(recipe["ingredients"].([]interface{})) != 3 || len(imported["unmatched"].([]interface{})) != 1 {
+		t.Errorf("JSON-LD import = %v", imported)
+	}
+	do("POST", "/api/recipes/import/jsonld", "<html></html>", http.StatusBadRequest)
+
+	paste := `{"text": "2 eggs\n1 cup rice\nsalt to taste", "dry_run": true}`
+	if lines := do("POST", "/api/recipes/1/ingredients/paste", paste, http.StatusOK).(map[string]interface{})["lines"]; len(lines.([]interface{})) != 3 {
+		t.Errorf("dry run lines = %v", lines)
+	}
+	paste = strings.Replace(paste, "true", "false", 1)
+	pasted := do("POST", "/api/recipes/1/ingredients/paste", paste, http.StatusOK).(map[string]interface{})
+	if len(pasted["added"].([]interface{})) != 2 || len(pasted["unmatched"].([]interface{})) != 1 {
+		t.Errorf("paste = %v", pasted)
+	}
+	do("POST", "/api/recipes/9/ingredients/paste", paste, http.StatusNotFound)
+	// A line naming an alias is added under the ingredient's own name
+	do("PUT", "/api/ingredients/1/aliases", `{"aliases": ["gohan"]}`, http.StatusOK)
+	pasted = do("POST", "/api/recipes/1/ingredients/paste", `{"text": "1 cup gohan"}`, http.StatusOK).(map[string]interface{})
+	if added := pasted["added"].([]interface{}); len(added) != 1 || added[0].(map[string]interface{})["name"] != "rice" {
+		t.Errorf("paste by alias = %v", pasted)
+	}
+	// Pasting replaces the quantity of a line already in the recipe
+	if ingredients := do("GET", "/api/recipes/1/ingredients", "", http.StatusOK).([]interface{}); len(ingredients) != 4 {
+		t.Errorf("recipe ingredients after paste = %v", ingredients)
+	}
+
+	// Without an upload the bundled dataset is used; a second run keeps what is there
+	rep := do("POST", "/api/ingredients/nutrition/import", "", http.StatusOK).(map[string]interface{})
+	if rep["created"].(float64) < 2 {
+		t.Errorf("nutrition import = %v", rep)
+	}
+	eggs := do("GET", "/api/ingredients/nutrition?ingredient_id=4", "", http.StatusOK).([]interface{})
+	if len(eggs) != 1 || eggs[0].(map[string]interface{})["kcal"] != 143.0 {
+		t.Errorf("egg nutrition = %v", eggs)
+	}
+	if rep := do("POST", "/api/ingredients/nutrition/import", "", http.StatusOK).(map[string]interface{}); rep["created"] != 0.0 {
+		t.Errorf("second nutrition import = %v", rep)
+	}
+
+	tomorrow := time.Now().AddDate(0, 0, 1).Format("2006-01-02")
+	do("POST", "/api/meal-plans", `{"date": "`+tomorrow+`", "meal_type": "Dinner", "recipe_id": 1, "servings": 2.5, "guest_count": 1}`, http.StatusOK)
+	do("POST", "/api/meal-plans", `{"date": "`+tomorrow+`", "meal_type": "Lunch", "recipe_id": 1, "leftovers": true}`, http.StatusOK)
+
+	export := func(router http.Handler, target string) string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Disposition"), "attachment") {
+			t.Fatalf("GET %s: %d %v", target, w.Code, w.Header())
+		}
+		return w.Body.String()
+	}
+	exports := []struct{ export, imp string }{
+		{"/api/ingredients/export?format=csv", "/api/ingredients/import?format=csv"},
+		{"/api/recipes/export?format=json", "/api/recipes/import?format=json"},
+		{"/api/meal-plans/export?format=csv", "/api/meal-plans/import?format=csv"},
+	}
+	// Servings, leftovers and guests are exported with the meal
+	if data := export(router, exports[2].export); !strings.Contains(data, tomorrow+",Dinner,Weeknight & Curry,false,2.5,false,1\n") ||
+		!strings.Contains(data, tomorrow+",Lunch,Weeknight & Curry,false,,true,0\n") {
+		t.Errorf("meal plan export = %q", data)
+	}
+
+	dst := newSQLiteDB(t)
+	dstRouter := handlers.JSONErrors(newRouter(dst, handlers.NewSQLStore(dst), config.Config{}))
+	doDst := newTestClient(t, dstRouter)
+	for _, e := range exports {
+		data := export(router, e.export)
+		if rep := doDst("POST", e.imp+"&dry_run=true", data, http.StatusOK).(map[string]interface{}); rep["failed"] != 0.0 {
+			t.Errorf("dry run of %s = %v", e.imp, rep)
+		}
+		if rep := doDst("POST", e.imp, data, http.StatusOK).(map[string]interface{}); rep["created"] == 0.0 || rep["failed"] != 0.0 {
+			t.Errorf("import of %s = %v", e.imp, rep)
+		}
+		if got := export(dstRouter, e.export); got != data {
+			t.Errorf("%s after the round trip:\n%s\nwant\n%s", e.export, got, data)
+		}
+	}
+}
+
+// Substitutes, sub-recipes and attendance only exist in the SQL store, so the
+// cooking and shopping rules that use them are tested here.
+func TestSQLiteCookRules(t *testing.T) {
+	db := newSQLiteDB(t)
+	do := newTestClient(t, handlers.JSONErrors(newRouter(db, handlers.NewSQLStore(db), config.Config{})))
+	stock := func(id string) interface{} {
+		t.Helper()
+		return do("GET", "/api/ingredients/"+id, "", http.StatusOK).(map[string]interface{})["current_stock"]
+	}
+	today := time.Now().Format("2006-01-02")
+
+	do("POST", "/api/ingredients", `{"name": "Tomatoes", "current_stock": 6, "unit": "pcs", "is_tracked": true}`, http.StatusCreated)
+	do("POST", "/api/ingredients", `{"name": "Butter", "current_stock": 10, "unit": "g", "is_tracked": true}`, http.StatusCreated)
+	do("POST", "/api/ingredients", `{"name": "Margarine", "current_stock": 200, "unit": "g", "is_tracked": true}`, http.StatusCreated)
+	do("POST", "/api/ingredients", `{"name": "Pasta", "current_stock": 500, "unit": "g", "is_tracked": true}`, http.StatusCreated)
+	do("POST", "/api/ingredients", `{"name": "Basil", "current_stock": 0, "is_tracked": true}`, http.StatusCreated)
+	do("POST", "/api/recipes", `{"name": "Tomato sauce", "servings": 4,
+		"ingredients": [{"ingredient_id": 1, "quantity": 4}, {"ingredient_id": 2, "quantity": 50}]}`, http.StatusCreated)
+	do("POST", "/api/recipes", `{"name": "Pasta", "servings": 2, "ingredients": [{"ingredient_id": 4, "quantity": 200}]}`, http.StatusCreated)
+
+	// Pasta takes two servings, half a batch, of the sauce
+	do("POST", "/api/recipes/2/components", `{"component_recipe_id": 1, "quantity": 2}`, http.StatusOK)
+	do("POST", "/api/recipes/1/components", `{"component_recipe_id": 2, "quantity": 1}`, http.StatusConflict)
+	do("POST", "/api/recipes/1/components", `{"component_recipe_id": 1, "quantity": 1}`, http.StatusConflict)
+	do("POST", "/api/recipes/2/components", `{"component_recipe_id": 1, "quantity": 1, "unit": "jar"}`, http.StatusBadRequest)
+	if c := do("GET", "/api/recipes/2/components", "", http.StatusOK).([]interface{}); len(c) != 1 {
+		t.Errorf("components = %v", c)
+	}
+
+	do("POST", "/api/substitutes", `{"ingredient_id": 2, "substitute_id": 3, "note": "Any fat"}`, http.StatusCreated)
+	do("POST", "/api/substitutes", `{"ingredient_id": 2, "substitute_id": 2}`, http.StatusBadRequest)
+	if subs := do("GET", "/api/ingredients/2/substitutes", "", http.StatusOK).([]interface{}); len(subs) != 1 ||
+		subs[0].(map[string]interface{})["substitute_name"] != "Margarine" {
+		t.Errorf("substitutes = %v", subs)
+	}
+
+	// Ann eats, Ben is out; guests count as servings too
+	do("POST", "/api/members", `{"name": "Ann"}`, http.StatusCreated)
+	do("POST", "/api/members", `{"name": "Ben"}`, http.StatusCreated)
+	do("POST", "/api/meal-plans", `{"date": "`+today+`", "meal_type": "Dinner", "recipe_id": 2, "member_ids": [1]}`, http.StatusOK)
+	attendance := func() map[string]interface{} {
+		t.Helper()
+		return do("GET", "/api/meal-plans/1/attendance", "", http.StatusOK).(map[string]interface{})
+	}
+	if a := attendance(); a["planned_servings"] != 1.0 {
+		t.Errorf("attendance = %v", a)
+	}
+	if a := do("PUT", "/api/meal-plans/1/attendance", `{"member_ids": [1, 2], "guest_count": 1}`, http.StatusOK).(map[string]interface{}); a["planned_servings"] != 3.0 {
+		t.Errorf("attendance with a guest = %v", a)
+	}
+	do("POST", "/api/members/2/attendance", `{"date": "`+today+`", "meal_type": "dinner"}`, http.StatusOK)
+	do("POST", "/api/members/2/attendance", `{"date": "`+today+`", "meal_type": "lunch"}`, http.StatusNotFound)
+	a := attendance()
+	if ben := a["members"].([]interface{})[1].(map[string]interface{}); a["planned_servings"] != 2.0 || ben["attending"] != false {
+		t.Errorf("attendance after Ben is out = %v", a)
+	}
+	do("PUT", "/api/meal-plans/9/attendance", `{"guest_count": 1}`, http.StatusNotFound)
+
+	// The sauce needs 25g butter: 10g are there and margarine covers the rest,
+	// so only basil is missing unless covered items are asked for
+	list := do("GET", "/api/shopping-list", "", http.StatusOK).([]interface{})
+	if len(list) != 1 || list[0].(map[string]interface{})["name"] != "Basil" {
+		t.Errorf("shopping list = %v", list)
+	}
+	list = do("GET", "/api/shopping-list?include_covered=true", "", http.StatusOK).([]interface{})
+	if len(list) != 2 || list[0].(map[string]interface{})["covered_by"] != "Margarine" ||
+		list[0].(map[string]interface{})["planned_consumption"] != 25.0 {
+		t.Errorf("shopping list with covered items = %v", list)
+	}
+
+	cooked := do("POST", "/api/meal-plans/1/cook", `{}`, http.StatusOK).(map[string]interface{})
+	subs := cooked["substitutions"].([]interface{})
+	if len(subs) != 1 || subs[0].(map[string]interface{})["quantity"] != 15.0 {
+		t.Errorf("cooked = %v", cooked)
+	}
+	for id, want := range map[string]float64{"1": 4, "2": 0, "3": 185, "4": 300} {
+		if got := stock(id); got != want {
+			t.Errorf("stock of ingredient %s after cooking = %v, want %v", id, got, want)
+		}
+	}
+
+	// With sauce prepared in advance, cooking takes servings of it instead of tomatoes
+	do("POST", "/api/recipes/1/prepare", `{}`, http.StatusCreated)
+	do("POST", "/api/meal-plans", `{"date": "`+today+`", "meal_type": "Lunch", "recipe_id": 2, "servings": 2}`, http.StatusOK)
+	do("POST", "/api/meal-plans/2/cook", `{}`, http.StatusOK)
+	if tomatoes, pasta := stock("1"), stock("4"); tomatoes != 0.0 || pasta != 100.0 {
+		t.Errorf("after cooking with prepared sauce: %v tomatoes, %v pasta", tomatoes, pasta)
+	}
+	prepared := do("GET", "/api/prepared-foods", "", http.StatusOK).([]interface{})
+	if len(prepared) != 1 || prepared[0].(map[string]interface{})["servings"] != 2.0 {
+		t.Errorf("prepared foods = %v", prepared)
+	}
+
+	// A chain of sub-recipes deeper than the limit cannot be cooked
+	for i := 0; i <= 11; i++ {
+		do("POST", "/api/recipes", fmt.Sprintf(`{"name": "Layer %d", "servings": 1}`, i), http.StatusCreated)
+		if i > 0 {
+			do("POST", fmt.Sprintf("/api/recipes/%d/components", 2+i), fmt.Sprintf(`{"component_recipe_id": %d, "quantity": 1}`, 3+i), http.StatusOK)
+		}
+	}
+	do("POST", "/api/recipes/14/ingredients", `{"ingredient_id": 4, "quantity": 1}`, http.StatusOK)
+	do("POST", "/api/meal-plans", `{"date": "`+today+`", "meal_type": "Breakfast", "recipe_id": 3}`, http.StatusOK)
+	if e := do("POST", "/api/meal-plans/3/cook", `{}`, http.StatusUnprocessableEntity).(map[string]interface{}); e["code"] != "nesting_too_deep" {
+		t.Errorf("cooking a deep chain = %v", e)
+	}
+	do("GET", "/api/recipes/3/availability", "", http.StatusUnprocessableEntity)
+	do("GET", "/api/recipes/4/availability", "", http.StatusOK)
+}
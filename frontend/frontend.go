@@ -0,0 +1,89 @@
+// Package frontend embeds the built app (npm run build writes dist) so the
+// server binary serves it itself, next to /api.
+package frontend
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// dist holds a .gitkeep until the app is built, so the package always compiles.
+//
+//go:embed all:dist
+var dist embed.FS
+
+type file struct {
+	data []byte
+	etag string
+}
+
+// Handler serves the files in dist. Other paths without an extension get
+// index.html so the app's own routes survive a reload; unknown /api paths and
+// missing files are 404s.
+//
+// Vite names everything under assets/ after its content, so those are cached
+// for a year. The rest (index.html, manifest.json, sw.js, icons) keep their
+// names across builds and are revalidated by ETag on every load.
+func Handler() http.Handler {
+	built, _ := fs.Sub(dist, "dist")
+	return newHandler(built)
+}
+
+func newHandler(fsys fs.FS) http.Handler {
+	files := map[string]file{}
+	fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		files[name] = file{data, `"` + hex.EncodeToString(sum[:8]) + `"`}
+		return nil
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api" || strings.HasPrefix(r.URL.Path, "/api/") {
+			http.NotFound(w, r)
+			return
+		}
+		name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+		f, ok := files[name]
+		if !ok {
+			if path.Ext(name) != "" {
+				http.NotFound(w, r)
+				return
+			}
+			name = "index.html"
+			if f, ok = files[name]; !ok {
+				http.Error(w, "frontend not built, run npm run build in frontend", http.StatusNotFound)
+				return
+			}
+		}
+
+		h := w.Header()
+		if strings.HasPrefix(name, "assets/") {
+			h.Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			h.Set("Cache-Control", "no-cache")
+			h.Set("ETag", f.etag)
+		}
+		switch name {
+		case "manifest.json":
+			h.Set("Content-Type", "application/manifest+json")
+		case "sw.js":
+			// The worker controls the whole app, not just the directory it is in
+			h.Set("Service-Worker-Allowed", "/")
+		}
+		http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(f.data))
+	})
+}
@@ -0,0 +1,57 @@
+package frontend
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func TestHandler(t *testing.T) {
+	h := newHandler(fstest.MapFS{
+		"index.html":           {Data: []byte("<div id=app></div>")},
+		"sw.js":                {Data: []byte("self.addEventListener('fetch', () => {})")},
+		"manifest.json":        {Data: []byte("{}")},
+		"assets/index-1a2b.js": {Data: []byte("console.log(1)")},
+		".gitkeep":             {},
+	})
+	get := func(target string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", target, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	tests := []struct {
+		target, body, cacheControl string
+		status                     int
+	}{
+		{"/", "<div id=app></div>", "no-cache", http.StatusOK},
+		{"/recipes/3", "<div id=app></div>", "no-cache", http.StatusOK},
+		{"/assets/index-1a2b.js", "console.log(1)", "public, max-age=31536000, immutable", http.StatusOK},
+		{"/assets/index-old.js", "", "", http.StatusNotFound},
+		{"/api/nothing", "", "", http.StatusNotFound},
+		{"/.gitkeep", "", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := get(tt.target)
+		if w.Code != tt.status || (tt.body != "" && w.Body.String() != tt.body) ||
+			(tt.cacheControl != "" && w.Header().Get("Cache-Control") != tt.cacheControl) {
+			t.Errorf("GET %s = %d %q %v", tt.target, w.Code, w.Body, w.Header())
+		}
+	}
+
+	w := get("/sw.js")
+	if w.Header().Get("Service-Worker-Allowed") != "/" || w.Header().Get("Content-Type") != "text/javascript; charset=utf-8" {
+		t.Errorf("sw.js headers = %v", w.Header())
+	}
+	if got := get("/manifest.json").Header().Get("Content-Type"); got != "application/manifest+json" {
+		t.Errorf("manifest.json Content-Type = %q", got)
+	}
+	if w := get("/", "If-None-Match", get("/").Header().Get("ETag")); w.Code != http.StatusNotModified {
+		t.Errorf("revalidation = %d", w.Code)
+	}
+}
@@ -0,0 +1,51 @@
+// Keeps the app shell available offline. Hashed files under /assets/ never
+// change, so they are served from the cache; pages go to the network first and
+// fall back to the cached index.html. API responses are never cached.
+const CACHE = 'house-v1'
+
+self.addEventListener('install', (event) => {
+    event.waitUntil(caches.open(CACHE).then((cache) => cache.addAll(['/', '/manifest.json', '/icon.png'])))
+    self.skipWaiting()
+})
+
+self.addEventListener('activate', (event) => {
+    event.waitUntil(
+        caches.keys()
+            .then((keys) => Promise.all(keys.filter((key) => key !== CACHE).map((key) => caches.delete(key))))
+            .then(() => self.clients.claim())
+    )
+})
+
+self.addEventListener('fetch', (event) => {
+    const url = new URL(event.request.url)
+    if (event.request.method !== 'GET' || url.origin !== location.origin || url.pathname.startsWith('/api/')) {
+        return
+    }
+
+    if (url.pathname.startsWith('/assets/')) {
+        event.respondWith(
+            caches.match(event.request).then((cached) => cached || fetch(event.request).then((response) => {
+                if (response.ok) {
+                    const copy = response.clone()
+                    caches.open(CACHE).then((cache) => cache.put(event.request, copy))
+                }
+                return response
+            }))
+        )
+        return
+    }
+
+    if (event.request.mode === 'navigate') {
+        event.respondWith(
+            fetch(event.request)
+                .then((response) => {
+                    if (response.ok) {
+                        const copy = response.clone()
+                        caches.open(CACHE).then((cache) => cache.put('/', copy))
+                    }
+                    return response
+                })
+                .catch(() => caches.match('/'))
+        )
+    }
+})
@@ -3,3 +3,8 @@ import './style.css'
 import App from './App.vue'
 
 createApp(App).mount('#app')
+
+// The Go server serves sw.js from the root so it controls every page
+if ('serviceWorker' in navigator && import.meta.env.PROD) {
+    navigator.serviceWorker.register('/sw.js', { scope: '/' })
+}